@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// pathNotAllowedError is returned for transfers that would read or write a path
+// outside of the allowed path prefixes.
+type pathNotAllowedError struct {
+	path string
+}
+
+func (e *pathNotAllowedError) Error() string {
+	return fmt.Sprintf("%s is not under any of the allowed path prefixes", e.path)
+}
+
+// isPathNotAllowed returns true if the error is a *pathNotAllowedError.
+func isPathNotAllowed(err error) bool {
+	_, ok := err.(*pathNotAllowedError)
+	return ok
+}
+
+// underPrefix returns true if p is the prefix or a path inside of it. Both are
+// cleaned first, so that e.g. /a/../b isn't treated as being under /a.
+func underPrefix(p, prefix string) bool {
+	p = path.Clean(p)
+	prefix = path.Clean(prefix)
+	return p == prefix || strings.HasPrefix(p, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// checkAllowedPaths returns a *pathNotAllowedError for the first of the paths
+// that isn't under one of the allowed path prefixes. Every path is allowed when
+// no prefixes are configured.
+func (a *App) checkAllowedPaths(paths ...string) error {
+	if len(a.AllowedPathPrefixes) == 0 {
+		return nil
+	}
+
+	for _, p := range paths {
+		allowed := false
+		for _, prefix := range a.AllowedPathPrefixes {
+			if underPrefix(p, prefix) {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return &pathNotAllowedError{path: p}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUnderPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		path, prefix string
+		expected     bool
+	}{
+		{"/input-files", "/input-files", true},
+		{"/input-files/sub", "/input-files", true},
+		{"/input-files/sub", "/input-files/", true},
+		{"/input-files-other", "/input-files", false},
+		{"/input-files/../etc", "/input-files", false},
+		{"/anything", "/", true},
+		{"relative", "/input-files", false},
+	} {
+		if underPrefix(tc.path, tc.prefix) != tc.expected {
+			t.Errorf("underPrefix(%q, %q) was %t", tc.path, tc.prefix, !tc.expected)
+		}
+	}
+}
+
+func TestAllowedDestinations(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.AllowedPathPrefixes = []string{"/some/other/prefix", app.DownloadDestination, "/iplant/home/test-user"}
+
+	if rec, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, ""); rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Errorf("allowed download returned %d %v", rec.Code, body)
+	}
+
+	if rec, body := postTransfer(app.UploadFilesHandler, "/upload?wait=true", nil, ""); rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Errorf("allowed upload returned %d %v", rec.Code, body)
+	}
+}
+
+func TestRejectedDestinations(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.AllowedPathPrefixes = []string{"/some/other/prefix"}
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"download": app.DownloadFilesHandler,
+		"upload":   app.UploadFilesHandler,
+	} {
+		rec, body := postTransfer(handler, "/"+name, nil, "")
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s to a disallowed destination returned %d", name, rec.Code)
+		}
+		if body["error"] == nil {
+			t.Errorf("no error message for the %s", name)
+		}
+	}
+
+	if n := len(app.downloadRecords.records) + len(app.uploadRecords.records); n != 0 {
+		t.Errorf("%d records were created for rejected transfers", n)
+	}
+}
+
+func TestRejectedBatchDestination(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.AllowedPathPrefixes = []string{app.DownloadDestination}
+
+	rec, body := postTransfer(app.BatchDownloadHandler, "/downloads/batch", nil,
+		`[{"paths": ["/a"]}, {"paths": ["/b"], "destination": "/etc"}]`)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("batch with a disallowed destination returned %d", rec.Code)
+	}
+	if body["error"] == nil {
+		t.Error("no error message for the batch")
+	}
+
+	if n := len(app.downloadRecords.records); n != 0 {
+		t.Errorf("%d records were created for a rejected batch", n)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The audit events for a transfer being requested and starting. The event for
+// a finished transfer is named after its final status, e.g. completed.
+const (
+	AuditRequested = "requested"
+	AuditStarted   = "started"
+)
+
+// AuditEvent records a transition in the lifecycle of a transfer. The times are
+// formatted by formatTime, and CompletionTime is empty until the transfer has
+// finished.
+type AuditEvent struct {
+	Event          string `json:"event"`
+	Time           string `json:"time"`
+	UUID           string `json:"uuid"`
+	Kind           string `json:"kind"`
+	User           string `json:"user"`
+	InvocationID   string `json:"invocation_id"`
+	StartTime      string `json:"start_time"`
+	CompletionTime string `json:"completion_time,omitempty"`
+	ErrorMessage   string `json:"error_message,omitempty"`
+}
+
+// AuditSink records audit events somewhere durable.
+type AuditSink interface {
+	Audit(event *AuditEvent) error
+}
+
+// logAuditSink is an AuditSink that writes each event to the service's log as a
+// structured line.
+type logAuditSink struct{}
+
+// Audit logs the event with its values as fields.
+func (logAuditSink) Audit(event *AuditEvent) error {
+	log.WithFields(logrus.Fields{
+		"audit_event":     event.Event,
+		"uuid":            event.UUID,
+		"kind":            event.Kind,
+		"user":            event.User,
+		"invocation_id":   event.InvocationID,
+		"start_time":      event.StartTime,
+		"completion_time": event.CompletionTime,
+		"error_message":   event.ErrorMessage,
+	}).Infof("audit: %s %s %s", event.Kind, event.UUID, event.Event)
+	return nil
+}
+
+// fileAuditSink is an AuditSink that appends each event to a file as a line of
+// JSON. It's safe for concurrent use.
+type fileAuditSink struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// openAuditLog opens the audit log at filePath for appending, creating it with
+// the permissions in mode if it doesn't exist.
+func openAuditLog(filePath string, mode os.FileMode) (*fileAuditSink, error) {
+	if mode == 0 {
+		mode = defaultLogFileMode
+	}
+
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, mode)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open the audit log %s", filePath)
+	}
+	return &fileAuditSink{file: f}, nil
+}
+
+// Audit appends the event to the file.
+func (f *fileAuditSink) Audit(event *AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	_, err = f.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (f *fileAuditSink) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
+
+// auditSinks is an AuditSink that passes each event to all of the sinks in
+// order. It returns the first error, but the event is still passed to the
+// remaining sinks.
+type auditSinks []AuditSink
+
+// Audit passes the event to each of the sinks.
+func (s auditSinks) Audit(event *AuditEvent) error {
+	var first error
+	for _, sink := range s {
+		if err := sink.Audit(event); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// audit passes an event for the record to the app's audit sink. Failures are
+// logged rather than returned, since they shouldn't affect the transfer. It
+// does nothing if there's no audit sink.
+func (a *App) audit(event string, r *TransferRecord) {
+	if a.auditor == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	e := &AuditEvent{
+		Event:        event,
+		Time:         formatTime(time.Now()),
+		UUID:         r.UUID.String(),
+		Kind:         r.Kind,
+		User:         a.User,
+		InvocationID: r.InvocationID,
+		StartTime:    formatTime(r.StartTime),
+		ErrorMessage: r.ErrorMessage,
+	}
+	if !r.CompletionTime.IsZero() {
+		e.CompletionTime = formatTime(r.CompletionTime)
+	}
+	r.mutex.Unlock()
+
+	if err := a.auditor.Audit(e); err != nil {
+		log.Error(errors.Wrapf(err, "failed to audit the %s event for %s %s", event, r.Kind, r.UUID))
+	}
+}
+
+// auditFinished passes the event for the record's final status to the app's
+// audit sink.
+func (a *App) auditFinished(r *TransferRecord) {
+	a.audit(r.CurrentStatus(), r)
+}
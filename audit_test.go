@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingAuditSink is an AuditSink that keeps the events it's given.
+type recordingAuditSink struct {
+	events []*AuditEvent
+	mutex  sync.Mutex
+}
+
+func (s *recordingAuditSink) Audit(event *AuditEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+// waitForEvents waits for n events to be recorded for the UUID, since the event
+// for a finished transfer is passed to the sink just after the transfer's Done
+// channel is closed, and returns their names in order.
+func (s *recordingAuditSink) waitForEvents(uuid string, n int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var names []string
+		s.mutex.Lock()
+		for _, e := range s.events {
+			if e.UUID == uuid {
+				names = append(names, e.Event)
+			}
+		}
+		s.mutex.Unlock()
+
+		if len(names) >= n || time.Now().After(deadline) {
+			return names
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAuditLifecycle(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	sink := &recordingAuditSink{}
+	app.auditor = sink
+	app.InvocationID = "3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0"
+
+	r, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, r)
+
+	expected := []string{AuditRequested, AuditStarted, CompletedStatus}
+	if names := sink.waitForEvents(r.UUID.String(), 3); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("audit events were %v, not %v", names, expected)
+	}
+
+	for _, e := range sink.events {
+		if e.Kind != DownloadKind || e.User != app.User || e.InvocationID != app.InvocationID || e.Time == "" || e.StartTime == "" {
+			t.Errorf("unexpected %s event: %+v", e.Event, e)
+		}
+		if (e.CompletionTime != "") != (e.Event == CompletedStatus) {
+			t.Errorf("%s event had completion time %q", e.Event, e.CompletionTime)
+		}
+	}
+}
+
+func TestAuditFailedAndCancelled(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	sink := &recordingAuditSink{}
+	app.auditor = sink
+
+	os.Remove(app.InputPathList)
+	failed, _ := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if names := sink.waitForEvents(failed.UUID.String(), 2); !reflect.DeepEqual(names, []string{AuditRequested, FailedStatus}) {
+		t.Errorf("audit events for a failed download were %v", names)
+	}
+
+	app.setQueuesPaused(true)
+	cancelled, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.cancelQueued(UploadKind)
+	if names := sink.waitForEvents(cancelled.UUID.String(), 2); !reflect.DeepEqual(names, []string{AuditRequested, CancelledStatus}) {
+		t.Errorf("audit events for a cancelled upload were %v", names)
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	auditLog, err := openAuditLog(filepath.Join(app.LogDirectory, "audit.log"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.auditor = auditSinks{logAuditSink{}, auditLog}
+
+	r, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, r)
+	app.queue(UploadKind).Wait()
+	auditLog.Close()
+
+	f, err := os.Open(filepath.Join(app.LogDirectory, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := &AuditEvent{}
+		if err = json.Unmarshal(scanner.Bytes(), e); err != nil {
+			t.Fatalf("audit log line %q wasn't JSON: %s", scanner.Text(), err)
+		}
+		if e.UUID != r.UUID.String() || e.Kind != UploadKind {
+			t.Errorf("unexpected event %+v", e)
+		}
+		names = append(names, e.Event)
+	}
+
+	if expected := []string{AuditRequested, AuditStarted, CompletedStatus}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("audit log had events %v, not %v", names, expected)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// queueFullRetryAfter is the Retry-After sent with requests that are refused
+// because the queue is full.
+const queueFullRetryAfter = 30 * time.Second
+
+// queueFullError is returned for transfers that would take the number of queued
+// transfers of the kind beyond the maximum queue length.
+type queueFullError struct {
+	kind  string
+	limit int
+}
+
+func (e *queueFullError) Error() string {
+	return fmt.Sprintf("the %s queue is full, it already holds the maximum of %d transfers", e.kind, e.limit)
+}
+
+// isQueueFull returns true if the error is a *queueFullError.
+func isQueueFull(err error) bool {
+	_, ok := err.(*queueFullError)
+	return ok
+}
+
+// checkQueueLength returns a *queueFullError if queueing n more transfers of
+// the kind would take the number waiting to start beyond the maximum queue
+// length. Running transfers don't count. There's no limit if the maximum isn't
+// positive.
+func (a *App) checkQueueLength(kind string, n int) error {
+	if a.MaxQueueLength <= 0 {
+		return nil
+	}
+
+	if a.queue(kind).Pending()+n > a.MaxQueueLength {
+		return &queueFullError{kind: kind, limit: a.MaxQueueLength}
+	}
+	return nil
+}
+
+// writeQueueFull responds to a request that was refused because the queue is
+// full with a 503 and a Retry-After header.
+func writeQueueFull(writer http.ResponseWriter, err error) {
+	writer.Header().Set("Retry-After", strconv.Itoa(int(queueFullRetryAfter.Seconds())))
+	writeJSONError(writer, http.StatusServiceUnavailable, err)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMaxQueueLength(t *testing.T) {
+	app, cleanup := newTestApp(t, batchScript)
+	defer cleanup()
+
+	app.MaxQueueLength = 2
+	app.queue(DownloadKind).SetPaused(true)
+	defer app.queue(DownloadKind).SetPaused(false)
+
+	rec, _ := postTransfer(app.BatchDownloadHandler, "/downloads/batch", nil,
+		`[{"paths": ["/iplant/home/test-user/a.txt"]}, {"paths": ["/iplant/home/test-user/b.txt"]}]`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("filling the queue returned %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec, _ = postTransfer(app.BatchDownloadHandler, "/downloads/batch", nil, `[{"paths": ["/iplant/home/test-user/c.txt"]}]`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("a batch beyond the limit returned %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") != "30" {
+		t.Errorf("Retry-After was %q", rec.Header().Get("Retry-After"))
+	}
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download", nil, "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("a download beyond the limit returned %d %v", rec.Code, body)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("a download beyond the limit didn't get a Retry-After header")
+	}
+
+	if n := len(app.downloadRecords.records); n != 2 {
+		t.Errorf("refused requests created records, there are %d", n)
+	}
+}
+
+func TestMaxQueueLengthUploads(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 1")
+	defer cleanup()
+
+	app.MaxQueueLength = 1
+	app.queue(UploadKind).SetPaused(true)
+
+	rec, body := postTransfer(app.UploadFilesHandler, "/upload", nil, "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("filling the queue returned %d %v", rec.Code, body)
+	}
+
+	rec, body = postTransfer(app.UploadFilesHandler, "/upload", nil, "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("an upload beyond the limit returned %d %v", rec.Code, body)
+	}
+
+	// Once the queued upload is running it no longer counts against the
+	// limit, so further uploads are refused because one is running instead.
+	app.queue(UploadKind).SetPaused(false)
+	waitForRunning(t, app.uploadRecords, UploadingStatus, 1)
+
+	rec, body = postTransfer(app.UploadFilesHandler, "/upload", nil, "")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("an upload while one was running returned %d %v", rec.Code, body)
+	}
+}
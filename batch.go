@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// BatchEntry describes one of the downloads in a batch download request. The
+// destination defaults to the configured download destination. Downloads with a
+// higher priority run before queued downloads with a lower one.
+type BatchEntry struct {
+	Paths       []string `json:"paths"`
+	Destination string   `json:"destination"`
+	Priority    int      `json:"priority"`
+}
+
+// BatchResponse is returned when a batch of downloads is queued.
+type BatchResponse struct {
+	BatchID string   `json:"batch_id"`
+	Records []string `json:"records"`
+}
+
+// BatchStatus describes the status of the downloads in a batch. Status is
+// requested or downloading until every download has finished, then completed
+// if all of them succeeded or failed if any of them failed.
+type BatchStatus struct {
+	BatchID string            `json:"batch_id"`
+	Status  string            `json:"status"`
+	Counts  map[string]int    `json:"counts"`
+	Records []*TransferRecord `json:"records"`
+}
+
+// batchRegistry keeps track of which records belong to each batch.
+type batchRegistry struct {
+	batches map[string][]*TransferRecord
+	batchOf map[*TransferRecord]string
+	mutex   sync.Mutex
+}
+
+// Add registers the records as a new batch and returns its ID.
+func (b *batchRegistry) Add(records []*TransferRecord) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.batches == nil {
+		b.batches = make(map[string][]*TransferRecord)
+		b.batchOf = make(map[*TransferRecord]string)
+	}
+
+	id := uuid.New().String()
+	b.batches[id] = records
+	for _, r := range records {
+		b.batchOf[r] = id
+	}
+	return id
+}
+
+// Remove forgets the batches that any of the records belong to. A batch's
+// status can't be told once one of its records is no longer tracked, so the
+// whole batch goes.
+func (b *batchRegistry) Remove(records ...*TransferRecord) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, r := range records {
+		id, ok := b.batchOf[r]
+		if !ok {
+			continue
+		}
+		for _, member := range b.batches[id] {
+			delete(b.batchOf, member)
+		}
+		delete(b.batches, id)
+	}
+}
+
+// Get returns the records in the batch. The returned bool is false if there
+// isn't a batch with the ID.
+func (b *batchRegistry) Get(id string) ([]*TransferRecord, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	records, ok := b.batches[id]
+	return records, ok
+}
+
+// aggregateStatus combines the statuses of the records in a batch into a
+// single status.
+func aggregateStatus(counts map[string]int, total int) string {
+	finished := counts[CompletedStatus] + counts[FailedStatus] + counts[CancelledStatus]
+
+	switch {
+	case finished < total && counts[DownloadingStatus] > 0:
+		return DownloadingStatus
+	case finished < total:
+		return RequestedStatus
+	case counts[FailedStatus] > 0:
+		return FailedStatus
+	case counts[CancelledStatus] > 0:
+		return CancelledStatus
+	default:
+		return CompletedStatus
+	}
+}
+
+// decodeBatchEntries parses the JSON array of batch entries in the body of the
+// request.
+func decodeBatchEntries(req *http.Request) ([]BatchEntry, error) {
+	var entries []BatchEntry
+	if req.Body == nil {
+		return nil, errors.New("a batch must contain at least one entry")
+	}
+
+	if err := decodeJSON(req.Body, &entries); err != nil {
+		return nil, errors.Wrap(err, "invalid request body")
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("a batch must contain at least one entry")
+	}
+
+	for i, entry := range entries {
+		if len(entry.Paths) == 0 {
+			return nil, fmt.Errorf("batch entry %d doesn't list any paths", i)
+		}
+		if entry.Destination != "" && !path.IsAbs(entry.Destination) {
+			return nil, fmt.Errorf("the destination of batch entry %d must be an absolute path", i)
+		}
+	}
+
+	return entries, nil
+}
+
+// DownloadBatch creates a download record for each of the entries and queues
+// them. Unlike DownloadFiles, the downloads are queued even when other
+// downloads are running; they start as the concurrency limit allows. Entries
+// without a destination go to the download destination. Every destination must
+// be under one of the allowed path prefixes, and is namespaced by user in the
+// same way as the download destination. No records are created if the entries
+// would take the download queue beyond its maximum length.
+func (a *App) DownloadBatch(ctx context.Context, entries []BatchEntry) (string, []*TransferRecord, error) {
+	destinations := make([]string, len(entries))
+	for i, entry := range entries {
+		destinations[i] = entry.Destination
+		if destinations[i] == "" {
+			destinations[i] = a.DownloadDestination
+		}
+		destinations[i] = path.Clean(destinations[i])
+	}
+
+	if err := a.checkAllowedPaths(destinations...); err != nil {
+		return "", nil, err
+	}
+
+	if err := a.checkQueueLength(DownloadKind, len(entries)); err != nil {
+		return "", nil, err
+	}
+
+	userDirectories := make(map[string]string)
+	for i, destination := range destinations {
+		dir, ok := userDirectories[destination]
+		if !ok {
+			var err error
+			if dir, err = a.userDirectory(destination); err != nil {
+				return "", nil, err
+			}
+			userDirectories[destination] = dir
+		}
+		destinations[i] = dir
+	}
+
+	records := make([]*TransferRecord, 0, len(entries))
+
+	for i, entry := range entries {
+		pathList, err := writePathListFile(entry.Paths)
+		if err != nil {
+			for _, r := range records {
+				r.Cancel()
+				removeTempFiles(r.params.tempFiles)
+			}
+			return "", nil, err
+		}
+
+		destination := destinations[i]
+
+		r := NewDownloadRecord()
+		r.Priority = entry.Priority
+		r.params = transferParams{
+			pathList:     pathList,
+			destination:  destination,
+			configPath:   a.ConfigPath,
+			metadataFile: a.MetadataFile,
+			tempFiles:    []string{pathList},
+		}
+		r.params.ctx, r.params.cancel = a.newTransferContext(ctx)
+		records = append(records, r)
+	}
+
+	batchID := a.batches.Add(records)
+
+	for _, r := range records {
+		a.appendRecord(a.downloadRecords, r)
+		a.queue(DownloadKind).Enqueue(r)
+	}
+
+	log.Infof("queued batch %s with %d downloads", batchID, len(records))
+
+	return batchID, records, nil
+}
+
+// BatchDownloadHandler handles requests to download a batch of path lists.
+func (a *App) BatchDownloadHandler(writer http.ResponseWriter, req *http.Request) {
+	log.Info("received batch download request")
+
+	entries, err := decodeBatchEntries(req)
+	if err != nil {
+		writeJSONError(writer, decodeErrorStatus(err), err)
+		return
+	}
+
+	batchID, records, err := a.DownloadBatch(req.Context(), entries)
+	if isPathNotAllowed(err) {
+		log.Warn(err)
+		writeJSONError(writer, http.StatusForbidden, err)
+		return
+	}
+	if isQueueFull(err) {
+		log.Warn(err)
+		writeQueueFull(writer, err)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		writeJSONError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := BatchResponse{BatchID: batchID, Records: make([]string, 0, len(records))}
+	for _, r := range records {
+		response.Records = append(response.Records, r.UUID.String())
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Location", path.Join("/downloads/batch", batchID))
+	writer.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(writer).Encode(response)
+}
+
+// GetBatchStatus returns the aggregate status of a batch of downloads.
+func (a *App) GetBatchStatus(writer http.ResponseWriter, req *http.Request) {
+	batchID := mux.Vars(req)["batchID"]
+
+	records, ok := a.batches.Get(batchID)
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, r := range records {
+		counts[r.CurrentStatus()]++
+	}
+
+	render(writer, req, http.StatusOK, &BatchStatus{
+		BatchID: batchID,
+		Status:  aggregateStatus(counts, len(records)),
+		Counts:  counts,
+		Records: records,
+	})
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// batchScript is a fake porklock that copies the path list it was given into
+// the destination directory.
+const batchScript = `while [ $# -gt 0 ]; do
+  case "$1" in
+    --source-list) list="$2" ;;
+    --destination) dest="$2" ;;
+  esac
+  shift
+done
+cp "$list" "$dest/path-list"`
+
+func getBatchStatus(app *App, batchID string) (int, *BatchStatus) {
+	req := httptest.NewRequest(http.MethodGet, "/downloads/batch/"+batchID, nil)
+	req = mux.SetURLVars(req, map[string]string{"batchID": batchID})
+	rec := httptest.NewRecorder()
+
+	app.GetBatchStatus(rec, req)
+
+	status := &BatchStatus{}
+	json.Unmarshal(rec.Body.Bytes(), status)
+	return rec.Code, status
+}
+
+func TestBatchDownload(t *testing.T) {
+	app, cleanup := newTestApp(t, batchScript)
+	defer cleanup()
+
+	other := filepath.Join(app.LogDirectory, "other-files")
+	if err := os.Mkdir(other, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, _ := postTransfer(app.BatchDownloadHandler, "/downloads/batch", nil,
+		`[{"paths": ["/iplant/home/test-user/a.txt"], "destination": "`+other+`"},
+		  {"paths": ["/iplant/home/test-user/b.txt", "/iplant/home/test-user/c.txt"]}]`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status code was %d: %s", rec.Code, rec.Body.String())
+	}
+
+	response := &BatchResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.BatchID == "" || len(response.Records) != 2 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	if location := rec.Header().Get("Location"); location != "/downloads/batch/"+response.BatchID {
+		t.Errorf("Location was %q", location)
+	}
+
+	for _, id := range response.Records {
+		if app.downloadRecords.FindRecord(id) == nil {
+			t.Errorf("record %s was not created", id)
+		}
+	}
+
+	app.queue(DownloadKind).Wait()
+
+	code, status := getBatchStatus(app, response.BatchID)
+	if code != http.StatusOK {
+		t.Fatalf("status code was %d", code)
+	}
+
+	if status.Status != CompletedStatus || status.Counts[CompletedStatus] != 2 || len(status.Records) != 2 {
+		t.Errorf("unexpected batch status %+v", status)
+	}
+
+	for dir, expected := range map[string]string{
+		other:                   "/iplant/home/test-user/a.txt\n",
+		app.DownloadDestination: "/iplant/home/test-user/b.txt\n/iplant/home/test-user/c.txt\n",
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(dir, "path-list"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(contents) != expected {
+			t.Errorf("path list for %s contained %q", dir, string(contents))
+		}
+	}
+}
+
+func TestBatchDownloadFailure(t *testing.T) {
+	app, cleanup := newTestApp(t, "false")
+	defer cleanup()
+
+	rec, body := postTransfer(app.BatchDownloadHandler, "/downloads/batch", nil, `[{"paths": ["/a"]}, {"paths": ["/b"]}]`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status code was %d: %v", rec.Code, body)
+	}
+
+	app.queue(DownloadKind).Wait()
+
+	_, status := getBatchStatus(app, body["batch_id"].(string))
+	if status.Status != FailedStatus || status.Counts[FailedStatus] != 2 {
+		t.Errorf("unexpected batch status %+v", status)
+	}
+}
+
+func TestBatchDownloadInvalid(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	for _, body := range []string{"", "[]", `{"paths": ["/a"]}`, `[{"paths": []}]`} {
+		rec, decoded := postTransfer(app.BatchDownloadHandler, "/downloads/batch", nil, body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status code for %q was %d", body, rec.Code)
+		}
+		if decoded["error"] == nil {
+			t.Errorf("no error message for %q", body)
+		}
+	}
+
+	if n := len(app.downloadRecords.records); n != 0 {
+		t.Errorf("%d records were created for rejected batches", n)
+	}
+}
+
+func TestBatchStatusNotFound(t *testing.T) {
+	app := &App{}
+
+	if code, _ := getBatchStatus(app, "missing"); code != http.StatusNotFound {
+		t.Errorf("status code was %d", code)
+	}
+}
+
+func TestAggregateStatus(t *testing.T) {
+	for _, tc := range []struct {
+		counts   map[string]int
+		expected string
+	}{
+		{map[string]int{RequestedStatus: 2}, RequestedStatus},
+		{map[string]int{RequestedStatus: 1, DownloadingStatus: 1}, DownloadingStatus},
+		{map[string]int{FailedStatus: 1, DownloadingStatus: 1}, DownloadingStatus},
+		{map[string]int{CompletedStatus: 1, RequestedStatus: 1}, RequestedStatus},
+		{map[string]int{CompletedStatus: 1, FailedStatus: 1}, FailedStatus},
+		{map[string]int{CompletedStatus: 1, CancelledStatus: 1}, CancelledStatus},
+		{map[string]int{CancelledStatus: 1, FailedStatus: 1}, FailedStatus},
+		{map[string]int{CompletedStatus: 2}, CompletedStatus},
+	} {
+		if status := aggregateStatus(tc.counts, 2); status != tc.expected {
+			t.Errorf("aggregate of %v was %s, not %s", tc.counts, status, tc.expected)
+		}
+	}
+}
+
+// postBatch posts the batch and returns its response, failing the test if it
+// wasn't accepted.
+func postBatch(t *testing.T, app *App, body string) *BatchResponse {
+	rec, _ := postTransfer(app.BatchDownloadHandler, "/downloads/batch", nil, body)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status code was %d: %s", rec.Code, rec.Body.String())
+	}
+
+	response := &BatchResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestBatchForgottenWithRecords(t *testing.T) {
+	app, cleanup := newTestApp(t, batchScript)
+	defer cleanup()
+
+	app.downloadRecords.maxRecords = 2
+
+	deleted := postBatch(t, app, `[{"paths": ["/a"]}]`)
+	app.queue(DownloadKind).Wait()
+	if code := deleteDownloadRecord(app, deleted.Records[0]); code != http.StatusNoContent {
+		t.Fatalf("deleting the record returned %d", code)
+	}
+	if code, _ := getBatchStatus(app, deleted.BatchID); code != http.StatusNotFound {
+		t.Errorf("the batch of a deleted record returned %d", code)
+	}
+
+	evicted := postBatch(t, app, `[{"paths": ["/b"]}, {"paths": ["/c"]}]`)
+	app.queue(DownloadKind).Wait()
+	postBatch(t, app, `[{"paths": ["/d"]}]`)
+	app.queue(DownloadKind).Wait()
+	if code, _ := getBatchStatus(app, evicted.BatchID); code != http.StatusNotFound {
+		t.Errorf("the batch of an evicted record returned %d", code)
+	}
+
+	if n := len(app.batches.batches); n != 1 {
+		t.Errorf("%d batches are registered, not 1", n)
+	}
+	if n := len(app.batches.batchOf); n != 1 {
+		t.Errorf("%d records are registered with batches, not 1", n)
+	}
+}
+
+func TestBatchDestinationNamespaced(t *testing.T) {
+	app, cleanup := newTestApp(t, batchScript)
+	defer cleanup()
+
+	app.NamespaceByUser = true
+	other := filepath.Join(app.LogDirectory, "other-files")
+
+	response := postBatch(t, app, `[{"paths": ["/a"], "destination": "`+other+`/"}]`)
+	app.queue(DownloadKind).Wait()
+
+	_, status := getBatchStatus(app, response.BatchID)
+	if status.Status != CompletedStatus {
+		t.Fatalf("the batch was %s", status.Status)
+	}
+	if _, err := os.Stat(filepath.Join(other, app.User, "path-list")); err != nil {
+		t.Errorf("the download didn't go to the user directory: %s", err)
+	}
+}
+
+func TestBatchRelativeDestination(t *testing.T) {
+	app, cleanup := newTestApp(t, batchScript)
+	defer cleanup()
+
+	rec, body := postTransfer(app.BatchDownloadHandler, "/downloads/batch", nil, `[{"paths": ["/a"], "destination": "other-files"}]`)
+	if rec.Code != http.StatusBadRequest || body["error"] == nil {
+		t.Errorf("a relative destination returned %d %v", rec.Code, body)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// limitBody wraps the handler so that it can't read more than maxBytes of the
+// request body. Reads beyond the limit fail with an *http.MaxBytesError. A
+// maxBytes that isn't positive leaves the body unlimited.
+func limitBody(maxBytes int64, next http.HandlerFunc) http.HandlerFunc {
+	if maxBytes <= 0 {
+		return next
+	}
+
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Body != nil {
+			req.Body = http.MaxBytesReader(writer, req.Body, maxBytes)
+		}
+		next(writer, req)
+	}
+}
+
+// decodeErrorStatus returns the status code for a failure to decode a request
+// body, which is a 413 if the body was larger than the limit and a 400
+// otherwise.
+func decodeErrorStatus(err error) int {
+	if _, ok := errors.Cause(err).(*http.MaxBytesError); ok {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOversizedBody(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.GzipMinSize = 1024
+	app.MaxBodyBytes = 64
+	router := app.newRouter()
+
+	oversized := `{"excludes": ["` + strings.Repeat("x", 128) + `"]}`
+	for target, body := range map[string]string{
+		"/download":        oversized,
+		"/upload":          oversized,
+		"/downloads/batch": `[{"paths": ["/` + strings.Repeat("x", 128) + `"]}]`,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("oversized POST to %s returned %d", target, rec.Code)
+		}
+
+		decoded := map[string]interface{}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil || decoded["error"] == nil {
+			t.Errorf("oversized POST to %s didn't return a JSON error: %s", target, rec.Body.String())
+		}
+	}
+
+	if n := len(app.downloadRecords.records) + len(app.uploadRecords.records); n != 0 {
+		t.Errorf("%d records were created for oversized requests", n)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/upload?wait=true", strings.NewReader(`{"priority": 1}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("POST under the limit returned %d", rec.Code)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+)
+
+// CancelAllResponse is the response to a request to cancel the queued
+// transfers of a kind.
+type CancelAllResponse struct {
+	Cancelled int `json:"cancelled"`
+}
+
+// cancelQueued cancels the transfers of the kind that are queued but haven't
+// started, including an upload waiting for the debounce window to pass, and
+// returns the number that were cancelled. The transfers that are running keep
+// going.
+func (a *App) cancelQueued(kind string) int {
+	removed := a.queue(kind).RemovePending()
+	if kind == UploadKind {
+		if r := a.uploadDebounce.take(); r != nil {
+			removed = append(removed, r)
+		}
+	}
+	for _, r := range removed {
+		log.Infof("cancelling queued %s %s", kind, r.UUID)
+		r.SetStatus(CancelledStatus)
+		r.SetCompletionTime()
+		r.Cancel()
+		removeTempFiles(r.params.tempFiles)
+		a.auditFinished(r)
+	}
+	return len(removed)
+}
+
+// CancelAllDownloads handles requests to cancel every queued download.
+func (a *App) CancelAllDownloads(writer http.ResponseWriter, req *http.Request) {
+	render(writer, req, http.StatusOK, &CancelAllResponse{Cancelled: a.cancelQueued(DownloadKind)})
+}
+
+// CancelAllUploads handles requests to cancel every queued upload.
+func (a *App) CancelAllUploads(writer http.ResponseWriter, req *http.Request) {
+	render(writer, req, http.StatusOK, &CancelAllResponse{Cancelled: a.cancelQueued(UploadKind)})
+}
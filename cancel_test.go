@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCancelAllQueued(t *testing.T) {
+	app, cleanup := newTestApp(t, `while [ ! -e "$(dirname "$0")/release" ]; do sleep 0.05; done`)
+	defer cleanup()
+
+	_, records, err := app.DownloadBatch(context.Background(), []BatchEntry{
+		{Paths: []string{"/a"}},
+		{Paths: []string{"/b"}},
+		{Paths: []string{"/c"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForRunning(t, app.downloadRecords, DownloadingStatus, 1)
+
+	rec := httptest.NewRecorder()
+	app.CancelAllDownloads(rec, httptest.NewRequest(http.MethodPost, "/download/cancel-all", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"cancelled":2}` {
+		t.Errorf("cancel-all returned %d %s", rec.Code, rec.Body.String())
+	}
+
+	var running *TransferRecord
+	for _, r := range records {
+		switch r.CurrentStatus() {
+		case DownloadingStatus:
+			running = r
+		case CancelledStatus:
+			waitForStatus(t, r)
+			for _, p := range r.params.tempFiles {
+				if _, err := os.Stat(p); !os.IsNotExist(err) {
+					t.Errorf("temporary file %s wasn't removed", p)
+				}
+			}
+		default:
+			t.Errorf("queued download had status %s", r.CurrentStatus())
+		}
+	}
+	if running == nil {
+		t.Fatal("the running download was disturbed")
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(app.LogDirectory, "release"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, running); status != CompletedStatus {
+		t.Errorf("running download finished with status %s", status)
+	}
+
+	rec = httptest.NewRecorder()
+	app.CancelAllDownloads(rec, httptest.NewRequest(http.MethodPost, "/download/cancel-all", nil))
+	if rec.Body.String() != `{"cancelled":0}` {
+		t.Errorf("cancel-all of an empty queue returned %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ChecksumVerifier compares the checksums of downloaded files against the
+// checksums recorded in iRODS. Verify returns an error if any of them don't
+// match or if they couldn't be compared.
+type ChecksumVerifier interface {
+	Verify(ctx context.Context, pathList, destination string, stdout, stderr io.Writer) error
+}
+
+// commandVerifier is a ChecksumVerifier that runs the checksum command, ichksum
+// by default, for each of the paths in the path list and compares the checksums
+// it lists with the checksums of the files in the destination.
+type commandVerifier struct {
+	app *App
+}
+
+// Verify runs the checksum command for each of the paths in turn, stopping at
+// the first one that can't be checked or doesn't match.
+func (c *commandVerifier) Verify(ctx context.Context, pathList, destination string, stdout, stderr io.Writer) error {
+	paths, err := readPathList(pathList)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if err = c.verifyPath(ctx, p, destination, stdout, stderr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyPath checks the file or directory downloaded from the iRODS path p to
+// the destination. Directories are checked recursively.
+func (c *commandVerifier) verifyPath(ctx context.Context, p, destination string, stdout, stderr io.Writer) error {
+	info, err := os.Stat(filepath.Join(destination, path.Base(p)))
+	if err != nil {
+		return errors.Wrapf(err, "can't check the checksums for %s", p)
+	}
+
+	var listed bytes.Buffer
+	cmd := c.app.newCommand(ctx, c.app.checksumCommand(p, info.IsDir()))
+	cmd.Stdout = io.MultiWriter(&listed, stdout)
+	cmd.Stderr = stderr
+	if err = cmd.Run(); err != nil {
+		return errors.Wrapf(err, "error listing the checksums for %s", p)
+	}
+
+	parent := path.Dir(p)
+	checksums := parseChecksums(parent, listed.String())
+	if len(checksums) == 0 {
+		return fmt.Errorf("no checksums were listed for %s", p)
+	}
+
+	for irodsPath, checksum := range checksums {
+		rel := strings.TrimPrefix(irodsPath, strings.TrimSuffix(parent, "/")+"/")
+		if err = compareChecksum(filepath.Join(destination, filepath.FromSlash(rel)), checksum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checksumCommand returns the command line that lists the iRODS checksums for
+// the path, recursively if it's a collection. It's wrapped like the porklock
+// commands so that it runs in the same environment.
+func (a *App) checksumCommand(irodsPath string, recursive bool) []string {
+	parts := strings.Fields(a.ChecksumCommand)
+	if recursive {
+		parts = append(parts, "-r")
+	}
+	return a.wrapCommand(append(parts, irodsPath))
+}
+
+// checkChecksumCommand returns an error if checksums are verified without a
+// command to list them.
+func (o *Options) checkChecksumCommand() error {
+	if o.VerifyChecksums && len(strings.Fields(o.ChecksumCommand)) == 0 {
+		return errors.New("verify-checksums needs a checksum-command")
+	}
+	return nil
+}
+
+// checksumPattern matches the checksums listed by ichksum, which are either
+// base64 SHA-256 digests prefixed with sha2: or hex MD5 digests, depending on
+// the zone's checksum scheme.
+var checksumPattern = regexp.MustCompile(`^(sha2:[A-Za-z0-9+/=]+|[0-9a-fA-F]{32})$`)
+
+// parseChecksums returns the checksums listed in ichksum's output, keyed by the
+// iRODS path of each data object. Data objects are listed by name under a
+// "C- collection:" line, or under the collection given for the first of them.
+// Lines that don't end with a checksum, like ichksum's totals, are skipped.
+func parseChecksums(collection, output string) map[string]string {
+	checksums := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "C- ") && strings.HasSuffix(line, ":") {
+			collection = strings.TrimSuffix(strings.TrimPrefix(line, "C- "), ":")
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !checksumPattern.MatchString(fields[len(fields)-1]) {
+			continue
+		}
+
+		checksum := fields[len(fields)-1]
+		name := strings.TrimSpace(strings.TrimSuffix(line, checksum))
+		checksums[path.Join(collection, name)] = checksum
+	}
+	return checksums
+}
+
+// compareChecksum returns an error if the checksum of the local file doesn't
+// match the checksum listed by ichksum.
+func compareChecksum(localPath, checksum string) error {
+	var (
+		h      hash.Hash
+		encode func([]byte) string
+	)
+	if strings.HasPrefix(checksum, "sha2:") {
+		h, encode = sha256.New(), func(b []byte) string { return "sha2:" + base64.StdEncoding.EncodeToString(b) }
+	} else {
+		h, encode = md5.New(), hex.EncodeToString
+		checksum = strings.ToLower(checksum)
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "can't check the checksum of %s", localPath)
+	}
+	defer f.Close()
+
+	if _, err = io.Copy(h, f); err != nil {
+		return errors.Wrapf(err, "can't check the checksum of %s", localPath)
+	}
+
+	if local := encode(h.Sum(nil)); local != checksum {
+		return fmt.Errorf("checksum mismatch for %s: iRODS has %s, the local file has %s", localPath, checksum, local)
+	}
+	return nil
+}
+
+// verifyChecksums checks the files downloaded for the record, with the
+// verifier's output going to the transfer's logs. It does nothing unless
+// checksum verification is enabled.
+func (a *App) verifyChecksums(ctx context.Context, r *TransferRecord, logs *transferLogs) error {
+	if !a.VerifyChecksums {
+		return nil
+	}
+
+	if err := a.verifier.Verify(ctx, r.params.pathList, r.params.destination, logs.stdoutOutput, logs.stderrOutput); err != nil {
+		return errors.Wrap(err, "checksum verification failed")
+	}
+
+	r.SetChecksumVerified()
+	return nil
+}
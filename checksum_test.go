@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeVerifier is a ChecksumVerifier that returns err instead of comparing any
+// checksums.
+type fakeVerifier struct {
+	calls int
+	err   error
+}
+
+func (f *fakeVerifier) Verify(ctx context.Context, pathList, destination string, stdout, stderr io.Writer) error {
+	f.calls++
+	return f.err
+}
+
+func TestChecksumMismatchFailsDownload(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.VerifyChecksums = true
+	app.verifier = &fakeVerifier{err: errors.New("checksum mismatch for file.txt")}
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code was %d", rec.Code)
+	}
+
+	if body["status"] != FailedStatus {
+		t.Errorf("download with a checksum mismatch had status %v", body["status"])
+	}
+
+	if msg, _ := body["error_message"].(string); !strings.Contains(msg, "checksum mismatch for file.txt") {
+		t.Errorf("error_message was %q", msg)
+	}
+
+	if body["checksum_verified"] != false {
+		t.Errorf("checksum_verified was %v", body["checksum_verified"])
+	}
+}
+
+func TestChecksumVerified(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.VerifyChecksums = true
+	app.verifier = &fakeVerifier{}
+
+	_, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, "")
+	if body["status"] != CompletedStatus || body["checksum_verified"] != true {
+		t.Errorf("unexpected record %v", body)
+	}
+}
+
+func TestChecksumVerificationSkippedByDefault(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	verifier := &fakeVerifier{err: errors.New("should not be called")}
+	app.verifier = verifier
+
+	_, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, "")
+	if body["status"] != CompletedStatus {
+		t.Errorf("download had status %v", body["status"])
+	}
+
+	if verifier.calls != 0 {
+		t.Errorf("verifier was called %d times", verifier.calls)
+	}
+}
+
+// checksumScript is a fake ichksum that records its arguments in the args file
+// next to it and prints the contents of the checksums file.
+const checksumScript = `#!/bin/sh
+dir="$(dirname "$0")"
+echo "$@" >> "$dir/args"
+cat "$dir/checksums"`
+
+// newChecksumApp returns a test app whose checksum command is checksumScript,
+// listing the checksums, and whose download destination has the files.
+func newChecksumApp(t *testing.T, checksums string, files map[string]string) (*App, func()) {
+	app, cleanup := newTestApp(t, "true")
+
+	command := filepath.Join(app.LogDirectory, "fake-ichksum")
+	if err := ioutil.WriteFile(command, []byte(checksumScript), 0755); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(app.LogDirectory, "checksums"), []byte(checksums), 0644); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	for name, contents := range files {
+		localPath := filepath.Join(app.DownloadDestination, name)
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(localPath, []byte(contents), 0644); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+
+	app.VerifyChecksums = true
+	app.ChecksumCommand = command
+	app.verifier = &commandVerifier{app: app}
+	return app, cleanup
+}
+
+func sha2Checksum(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return "sha2:" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func md5Checksum(contents string) string {
+	sum := md5.Sum([]byte(contents))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCommandVerifier(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		checksums string
+		status    string
+	}{
+		{"sha2", "    file.txt    " + sha2Checksum("contents") + "\n", CompletedStatus},
+		{"md5", "    file.txt    " + strings.ToUpper(md5Checksum("contents")) + "\n", CompletedStatus},
+		{"mismatch", "    file.txt    " + sha2Checksum("corrupted") + "\n", FailedStatus},
+		{"nothing listed", "ERROR: file.txt does not exist\n", FailedStatus},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			app, cleanup := newChecksumApp(t, test.checksums, map[string]string{"file.txt": "contents"})
+			defer cleanup()
+
+			record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status := waitForStatus(t, record); status != test.status {
+				t.Fatalf("the download had status %s: %s", status, record.Snapshot().ErrorMessage)
+			}
+
+			s := record.Snapshot()
+			if s.ChecksumVerified != (test.status == CompletedStatus) {
+				t.Errorf("checksum_verified was %t", s.ChecksumVerified)
+			}
+			if test.name == "mismatch" && !strings.Contains(s.ErrorMessage, "checksum mismatch") {
+				t.Errorf("the error message was %q", s.ErrorMessage)
+			}
+		})
+	}
+}
+
+func TestCommandVerifierCollection(t *testing.T) {
+	checksums := strings.Join([]string{
+		"C- /iplant/home/test-user/dir:",
+		"    a file.txt    " + sha2Checksum("a"),
+		"C- /iplant/home/test-user/dir/sub:",
+		"    b.txt    " + sha2Checksum("b"),
+		"Total checksum performed = 2, Failed checksum = 0",
+	}, "\n")
+	app, cleanup := newChecksumApp(t, checksums, map[string]string{"dir/a file.txt": "a", "dir/sub/b.txt": "changed"})
+	defer cleanup()
+
+	if err := ioutil.WriteFile(app.InputPathList, []byte("/iplant/home/test-user/dir\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := app.verifier.Verify(context.Background(), app.InputPathList, app.DownloadDestination, ioutil.Discard, ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join("dir", "sub", "b.txt")) {
+		t.Errorf("verifying the collection returned %v", err)
+	}
+
+	args, err := ioutil.ReadFile(filepath.Join(app.LogDirectory, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(args)) != "-r /iplant/home/test-user/dir" {
+		t.Errorf("the checksum command was run with %q", args)
+	}
+}
+
+func TestConfigChecksumCommand(t *testing.T) {
+	args := []string{"--user", "u", "--upload-destination", "/dest", "--invocation-id", "3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0", "--verify-checksums"}
+
+	opts, err := parseOptions(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ChecksumCommand != "ichksum" {
+		t.Errorf("the default checksum command was %q", opts.ChecksumCommand)
+	}
+
+	if _, err = parseOptions(append(args, "--checksum-command", " ")); err == nil {
+		t.Error("verify-checksums was accepted without a checksum command")
+	}
+}
+
+func TestFailedTransferRecordsErrorMessage(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 3")
+	defer cleanup()
+
+	_, body := postTransfer(app.UploadFilesHandler, "/upload?wait=true", nil, "")
+	if body["status"] != FailedStatus {
+		t.Fatalf("upload had status %v", body["status"])
+	}
+
+	if msg, _ := body["error_message"].(string); !strings.Contains(msg, "exit status 3") {
+		t.Errorf("error_message was %q", msg)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// chunkPathList splits the paths read from the path list at pathList into path
+// lists of up to size paths each, returning their paths in order. The original
+// path list is returned on its own if it doesn't have more than size paths or
+// size isn't positive. Callers must remove any other path lists that are
+// returned.
+func chunkPathList(pathList string, paths []string, size int) ([]string, error) {
+	if size <= 0 || len(paths) <= size {
+		return []string{pathList}, nil
+	}
+
+	var chunks []string
+	for start := 0; start < len(paths); start += size {
+		end := start + size
+		if end > len(paths) {
+			end = len(paths)
+		}
+
+		chunk, err := writePathListFile(paths[start:end])
+		if err != nil {
+			removeTempFiles(chunks)
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// runChunks runs porklock to download the paths in the path list, splitting
+// them into chunks of the configured size that are downloaded one after the
+// other. The chunks completed so far are recorded on the record, along with the
+// number of paths in them as its progress when there's more than one chunk.
+// Porklock doesn't report its progress within a run, so downloads that aren't
+// split up and uploads have no progress. It stops at the first chunk that
+// fails, returning its error.
+func (a *App) runChunks(ctx context.Context, r *TransferRecord, pathList string, logs *transferLogs) error {
+	paths, err := readPathList(pathList)
+	if err != nil {
+		return err
+	}
+
+	chunks, err := chunkPathList(pathList, paths, a.ChunkSize)
+	if err != nil {
+		return err
+	}
+	chunked := len(chunks) > 1
+	if chunked {
+		defer removeTempFiles(chunks)
+		log.Infof("splitting download %s into %d chunks of up to %d paths", r.UUID, len(chunks), a.ChunkSize)
+	}
+
+	r.SetChunks(0, len(chunks))
+	if chunked {
+		r.SetProgress(0, len(paths))
+	}
+	for i, chunk := range chunks {
+		parts := a.downloadCommand(chunk, r.params.destination, r.params.configPath, r.params.metadataFile)
+		if err = a.runPorklock(ctx, r, parts, logs); err != nil {
+			if chunked {
+				return errors.Wrapf(err, "error running porklock for chunk %d of %d of the downloads", i+1, len(chunks))
+			}
+			return errors.Wrap(err, "error running porklock for downloads")
+		}
+		r.SetChunks(i+1, len(chunks))
+
+		if chunked {
+			r.SetProgress(min((i+1)*a.ChunkSize, len(paths)), len(paths))
+		}
+	}
+	return nil
+}
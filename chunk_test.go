@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chunkScript is a fake porklock that appends the number of paths in its
+// source list to the chunks file next to it. It fails when the fail file
+// exists and the chunks file already has a line.
+const chunkScript = `dir="$(dirname "$0")"
+while [ $# -gt 0 ]; do
+	if [ "$1" = "--source-list" ]; then
+		if [ -e "$dir/fail" ] && [ -s "$dir/chunks" ]; then
+			exit 1
+		fi
+		wc -l < "$2" | tr -d ' ' >> "$dir/chunks"
+	fi
+	shift
+done`
+
+// writeLargePathList replaces the app's input path list with one of n paths.
+func writeLargePathList(t *testing.T, app *App, n int) {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "/iplant/home/test-user/file-%d.txt\n", i)
+	}
+	if err := ioutil.WriteFile(app.InputPathList, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chunkRuns returns the number of paths in each of the porklock runs recorded
+// by chunkScript.
+func chunkRuns(t *testing.T, app *App) []string {
+	contents, err := ioutil.ReadFile(filepath.Join(app.LogDirectory, "chunks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(contents))
+}
+
+func TestChunkedDownload(t *testing.T) {
+	for _, test := range []struct {
+		paths     int
+		chunkSize int
+		runs      []string
+	}{
+		{paths: 25, chunkSize: 10, runs: []string{"10", "10", "5"}},
+		{paths: 20, chunkSize: 10, runs: []string{"10", "10"}},
+		{paths: 10, chunkSize: 10, runs: []string{"10"}},
+		{paths: 25, chunkSize: 0, runs: []string{"25"}},
+	} {
+		name := fmt.Sprintf("%d paths in chunks of %d", test.paths, test.chunkSize)
+		t.Run(name, func(t *testing.T) {
+			app, cleanup := newTestApp(t, chunkScript)
+			defer cleanup()
+
+			app.ChunkSize = test.chunkSize
+			writeLargePathList(t, app, test.paths)
+
+			record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status := waitForStatus(t, record); status != CompletedStatus {
+				t.Fatalf("the download had status %s: %s", status, record.Snapshot().ErrorMessage)
+			}
+
+			runs := chunkRuns(t, app)
+			if strings.Join(runs, " ") != strings.Join(test.runs, " ") {
+				t.Errorf("porklock ran with %v paths, not %v", runs, test.runs)
+			}
+
+			s := record.Snapshot()
+			if s.ChunksTotal != len(test.runs) || s.ChunksCompleted != len(test.runs) {
+				t.Errorf("%d of %d chunks completed", s.ChunksCompleted, s.ChunksTotal)
+			}
+			// Progress is only known for downloads split into chunks.
+			files := test.paths
+			if len(test.runs) == 1 {
+				files = 0
+			}
+			if s.FilesTotal != files || s.FilesTransferred != files {
+				t.Errorf("%d of %d files transferred", s.FilesTransferred, s.FilesTotal)
+			}
+
+			// The chunked path lists are removed once the download has finished.
+			for i, arg := range s.Command {
+				if arg != "--source-list" || len(test.runs) == 1 {
+					continue
+				}
+				if _, err := os.Stat(s.Command[i+1]); !os.IsNotExist(err) {
+					t.Errorf("the chunk %s wasn't removed: %v", s.Command[i+1], err)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkedDownloadFailure(t *testing.T) {
+	app, cleanup := newTestApp(t, chunkScript)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(filepath.Join(app.LogDirectory, "fail"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	app.ChunkSize = 10
+	writeLargePathList(t, app, 25)
+
+	record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, record); status != FailedStatus {
+		t.Fatalf("the download had status %s", status)
+	}
+
+	s := record.Snapshot()
+	if !strings.Contains(s.ErrorMessage, "chunk 2 of 3") {
+		t.Errorf("the error message was %q", s.ErrorMessage)
+	}
+	if s.ChunksTotal != 3 || s.ChunksCompleted != 1 {
+		t.Errorf("%d of %d chunks completed", s.ChunksCompleted, s.ChunksTotal)
+	}
+	if s.FilesTotal != 25 || s.FilesTransferred != 10 {
+		t.Errorf("%d of %d files transferred", s.FilesTransferred, s.FilesTotal)
+	}
+	if runs := chunkRuns(t, app); len(runs) != 1 {
+		t.Errorf("porklock ran %d times after the failure", len(runs))
+	}
+}
+
+func TestChunkedDownloadProgress(t *testing.T) {
+	// The first chunk finishes straight away and the rest run until they're
+	// killed.
+	app, cleanup := newTestApp(t, `dir="$(dirname "$0")"
+if [ -e "$dir/started" ]; then exec sleep 10; fi
+touch "$dir/started"`)
+	defer cleanup()
+
+	app.ChunkSize = 10
+	writeLargePathList(t, app, 25)
+
+	record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer record.Cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for record.Snapshot().FilesTransferred != 10 {
+		if time.Now().After(deadline) {
+			t.Fatal("the first chunk's files were never counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	recordJSON, err := record.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed map[string]interface{}
+	if err = json.Unmarshal(recordJSON, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed["files_total"] != 25.0 || parsed["percent_complete"] != 40.0 {
+		t.Errorf("the download's progress was %v of %v files, %v%%", parsed["files_transferred"], parsed["files_total"], parsed["percent_complete"])
+	}
+	if _, ok := parsed["estimated_seconds_remaining"].(float64); !ok {
+		t.Errorf("estimated_seconds_remaining was %v", parsed["estimated_seconds_remaining"])
+	}
+}
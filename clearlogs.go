@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// LogsCleared reports how many transfer log files were removed by a request to
+// clear the logs and how much space they took up.
+type LogsCleared struct {
+	FilesRemoved int   `json:"files_removed"`
+	BytesFreed   int64 `json:"bytes_freed"`
+}
+
+// all returns every record, in the order they were added.
+func (h *HistoricalRecords) all() []*TransferRecord {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]*TransferRecord{}, h.records...)
+}
+
+// clearLogs deletes the log files of the transfers that have finished. Only the
+// files that the records point to are removed, and logs that are shared with a
+// transfer that hasn't finished are kept. The records themselves are kept, but
+// no longer point to the logs that were removed. Failures to remove a log are
+// logged and the log isn't counted. Transfers can't open their logs between
+// finding the logs in use and removing the others, since the names of the logs
+// are reused by every transfer of a kind unless they're rotated.
+func (a *App) clearLogs() *LogsCleared {
+	a.logsMutex.Lock()
+	defer a.logsMutex.Unlock()
+
+	inUse := a.logsInUse()
+	var finished []*TransferRecord
+	for _, records := range []*HistoricalRecords{a.downloadRecords, a.uploadRecords} {
+		for _, r := range records.all() {
+			if isTerminalStatus(r.CurrentStatus()) {
+				finished = append(finished, r)
+			}
+		}
+	}
+
+	cleared := &LogsCleared{}
+	removed := make(map[string]bool)
+	remove := func(logPath string) bool {
+		if logPath == "" || inUse[logPath] {
+			return false
+		}
+		if removed[logPath] {
+			return true
+		}
+
+		info, err := os.Stat(logPath)
+		if err == nil {
+			err = os.Remove(logPath)
+		}
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warn(errors.Wrapf(err, "failed to remove log file %s", logPath))
+				return false
+			}
+			// The log has already gone, so the record shouldn't point
+			// to it any longer, but there's nothing to count.
+			removed[logPath] = true
+			return true
+		}
+
+		removed[logPath] = true
+		cleared.FilesRemoved++
+		cleared.BytesFreed += info.Size()
+		return true
+	}
+
+	for _, r := range finished {
+		stdoutPath, stderrPath := r.LogPaths()
+		if remove(stdoutPath) {
+			stdoutPath = ""
+		}
+		if remove(stderrPath) {
+			stderrPath = ""
+		}
+		r.SetLogPaths(stdoutPath, stderrPath)
+	}
+
+	return cleared
+}
+
+// ClearLogs removes the log files of finished transfers to free up space in the
+// log directory, and responds with the number of files removed and the bytes
+// freed. The logs of transfers that are queued or running are kept.
+func (a *App) ClearLogs(writer http.ResponseWriter, req *http.Request) {
+	cleared := a.clearLogs()
+	log.Warnf("cleared %d log files, freeing %d bytes", cleared.FilesRemoved, cleared.BytesFreed)
+	render(writer, req, http.StatusOK, cleared)
+}
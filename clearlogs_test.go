@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClearLogs(t *testing.T) {
+	app, cleanup := newTestApp(t, `echo "transferring $3" >&2; [ "$3" = get ] || exec sleep 10`)
+	defer cleanup()
+	defer app.cancelTransfers()
+
+	unrelated := filepath.Join(app.LogDirectory, "unrelated.log")
+	if err := ioutil.WriteFile(unrelated, []byte("not a transfer log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	download, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, download); status != CompletedStatus {
+		t.Fatalf("download had status %s", status)
+	}
+
+	upload, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForRunning(t, app.uploadRecords, UploadingStatus, 1)
+
+	var freed int64
+	stdoutPath, stderrPath := download.LogPaths()
+	for _, p := range []string{stdoutPath, stderrPath} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		freed += info.Size()
+	}
+
+	rec := httptest.NewRecorder()
+	app.ClearLogs(rec, httptest.NewRequest(http.MethodDelete, "/logs", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code was %d: %s", rec.Code, rec.Body.String())
+	}
+
+	cleared := &LogsCleared{}
+	if err = json.Unmarshal(rec.Body.Bytes(), cleared); err != nil {
+		t.Fatal(err)
+	}
+	if cleared.FilesRemoved != 2 || cleared.BytesFreed != freed {
+		t.Errorf("cleared %+v, expected 2 files and %d bytes", cleared, freed)
+	}
+
+	for _, p := range []string{stdoutPath, stderrPath} {
+		if _, err = os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("the finished download's log %s wasn't removed: %v", p, err)
+		}
+	}
+	if s, e := download.LogPaths(); s != "" || e != "" {
+		t.Errorf("the download record still points to its logs: %s %s", s, e)
+	}
+
+	uploadStdout, uploadStderr := upload.LogPaths()
+	for _, p := range []string{uploadStdout, uploadStderr, unrelated} {
+		if _, err = os.Stat(p); err != nil {
+			t.Errorf("%s was removed: %v", p, err)
+		}
+	}
+
+	if app.downloadRecords.FindRecord(download.UUID.String()) == nil {
+		t.Error("the download record was removed along with its logs")
+	}
+
+	// Clearing again finds nothing more to remove.
+	if cleared = app.clearLogs(); cleared.FilesRemoved != 0 || cleared.BytesFreed != 0 {
+		t.Errorf("clearing the logs again cleared %+v", cleared)
+	}
+}
+
+func TestClearLogsWhileTransfersStart(t *testing.T) {
+	app, cleanup := newTestApp(t, "echo transferring >&2; sleep 0.05")
+	defer cleanup()
+
+	stop := make(chan struct{})
+	cleared := make(chan struct{})
+	go func() {
+		defer close(cleared)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				app.clearLogs()
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-cleared
+	}()
+
+	for i := 0; i < 20; i++ {
+		download, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		stdoutPath, stderrPath := download.LogPaths()
+		for stdoutPath == "" && !isTerminalStatus(download.CurrentStatus()) {
+			if time.Now().After(deadline) {
+				t.Fatal("the download never opened its logs")
+			}
+			time.Sleep(time.Millisecond)
+			stdoutPath, stderrPath = download.LogPaths()
+		}
+
+		for _, p := range []string{stdoutPath, stderrPath} {
+			if _, err = os.Stat(p); err != nil && !isTerminalStatus(download.CurrentStatus()) {
+				t.Fatalf("the running download's log was removed: %v", err)
+			}
+		}
+
+		if status := waitForStatus(t, download); status != CompletedStatus {
+			t.Fatalf("download had status %s", status)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	flags "github.com/jessevdk/go-flags"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Options contains the service's settings. They come from the command line and,
+// optionally, from a YAML config file whose keys are the long flag names.
+type Options struct {
+	Config                 string        `long:"config" yaml:"-" description:"The path to a YAML file with values for any of the other options. Options given on the command line override the file's"`
+	ListenPort             int           `short:"l" long:"listen-port" yaml:"listen-port" default:"60001" description:"The port to listen on for requests"`
+	LogDirectory           string        `long:"log-dir" yaml:"log-dir" default:"/input-files" description:"The directory in which to write log files"`
+	User                   string        `long:"user" yaml:"user" description:"The user to run the transfers for"`
+	UploadDestination      string        `long:"upload-destination" yaml:"upload-destination" description:"The destination directory for uploads"`
+	DownloadDestination    string        `long:"download-destination" yaml:"download-destination" default:"/input-files" description:"The destination directory for downloads"`
+	NamespaceByUser        bool          `long:"namespace-by-user" yaml:"namespace-by-user" description:"Download to and upload from a subdirectory of the download destination named after the user"`
+	ExcludesFile           string        `long:"excludes-file" yaml:"excludes-file" default:"/excludes/excludes-file" description:"The path to the excludes file"`
+	PathListFile           string        `long:"path-list-file" yaml:"path-list-file" default:"/input-paths/input-path-list" description:"The path to the input paths list file"`
+	IRODSConfig            string        `long:"irods-config" yaml:"irods-config" default:"/etc/porklock/irods-config.properties" description:"The path to the porklock iRODS config file"`
+	IRODSConfigDir         string        `long:"irods-config-dir" yaml:"irods-config-dir" description:"The directory that iRODS config files given in transfer requests must be in. Requests can't give an iRODS config file if it isn't set"`
+	PorklockPath           string        `long:"porklock-path" yaml:"porklock-path" default:"porklock" description:"The path to the porklock executable"`
+	PorklockJar            string        `long:"porklock-jar" yaml:"porklock-jar" default:"/usr/src/app/porklock-standalone.jar" description:"The path to the porklock jar file"`
+	InvocationID           string        `long:"invocation-id" yaml:"invocation-id" description:"The invocation UUID"`
+	FileMetadata           []string      `short:"m" yaml:"metadata" description:"Metadata to apply to files"`
+	MetadataFile           string        `long:"metadata-file" yaml:"metadata-file" description:"The path to a file of metadata (AVUs) to apply to files, in addition to any given with -m"`
+	NoService              bool          `short:"n" long:"no-service" yaml:"no-service" description:"Disables running as a continuous process. Effectively becomes a download tool"`
+	SelfTest               bool          `long:"self-test" yaml:"self-test" description:"Check that porklock can be run with the configured jar by running its help command, then exit"`
+	LogLevel               string        `long:"log-level" yaml:"log-level" default:"info" description:"The log level (debug, info, warn, or error)"`
+	LogFormat              string        `long:"log-format" yaml:"log-format" default:"text" description:"The log format (text or json)"`
+	LogFileMode            string        `long:"log-file-mode" yaml:"log-file-mode" default:"0644" description:"The octal permissions given to the transfer log files"`
+	LogFallback            bool          `long:"log-fallback" yaml:"log-fallback" description:"Write transfer logs to the system's temporary directory, with a warning, if they can't be created in the log directory"`
+	LogRotateOnRun         bool          `long:"log-rotate-on-run" yaml:"log-rotate-on-run" description:"Name each transfer's stdout and stderr logs after the time it was requested, e.g. downloads.20060102-150405.stdout.log, rather than reusing the same logs for every transfer"`
+	LogRetentionCount      int           `long:"log-retention-count" yaml:"log-retention-count" default:"0" description:"The number of logs of each kind kept with log-rotate-on-run. The oldest beyond it are deleted as each transfer starts. Zero keeps all of them"`
+	CombinedLogs           bool          `long:"combined-logs" yaml:"combined-logs" description:"Write porklock stdout and stderr to a single log file per transfer"`
+	MaxHistory             int           `long:"max-history" yaml:"max-history" default:"0" description:"The number of records of each kind to keep. Older finished records and their logs are removed. Zero keeps everything"`
+	LogTailStatuses        []string      `long:"log-tail-status" yaml:"log-tail-status" default:"failed" description:"A status for which status responses include the tail of the stderr log. May be repeated"`
+	LogTailLines           int           `long:"log-tail-lines" yaml:"log-tail-lines" default:"20" description:"The number of stderr log lines included in status responses"`
+	LineBuffered           bool          `long:"line-buffered" yaml:"line-buffered" description:"Run porklock with line buffered output so that progress reaches the logs promptly"`
+	UnbufferCommand        string        `long:"unbuffer-command" yaml:"unbuffer-command" default:"stdbuf -oL -eL" description:"The command used to run porklock with line buffered output"`
+	TransferWrapper        string        `long:"transfer-wrapper" yaml:"transfer-wrapper" description:"An executable that's run with the porklock command as its arguments, so that it can set up the environment before running porklock"`
+	StatusCacheTTL         time.Duration `long:"status-cache-ttl" yaml:"status-cache-ttl" default:"1s" description:"How long the /status summary is cached"`
+	StatusMaxAge           time.Duration `long:"status-max-age" yaml:"status-max-age" default:"0" description:"How long clients may cache transfer status responses before revalidating them with their ETag. Zero makes clients revalidate every time"`
+	ShutdownLogDestination string        `long:"shutdown-log-destination" yaml:"shutdown-log-destination" description:"The iRODS path to upload the log directory to when the service shuts down"`
+	ShutdownTimeout        time.Duration `long:"shutdown-timeout" yaml:"shutdown-timeout" default:"5m" description:"How long to wait for running transfers when shutting down, and then how long to wait for the final log upload"`
+	GzipMinSize            int           `long:"gzip-min-size" yaml:"gzip-min-size" default:"1024" description:"The smallest response, in bytes, that is gzip encoded for clients that accept it"`
+	RateLimit              float64       `long:"rate-limit" yaml:"rate-limit" default:"0" description:"The number of requests per second allowed to each transfer endpoint. Zero disables rate limiting"`
+	MaxBodyBytes           int64         `long:"max-body-bytes" yaml:"max-body-bytes" default:"1048576" description:"The largest request body, in bytes, accepted by the transfer endpoints. Zero disables the limit"`
+	AllowedPathPrefixes    []string      `long:"allowed-path-prefix" yaml:"allowed-path-prefix" description:"A path prefix that transfer destinations must be under. May be repeated. Every destination is allowed if none are given"`
+	MaxConcurrentDownloads int           `long:"max-concurrent-downloads" yaml:"max-concurrent-downloads" default:"1" description:"The number of downloads that may run at once. Batch downloads beyond it wait in a queue"`
+	MaxConcurrentUploads   int           `long:"max-concurrent-uploads" yaml:"max-concurrent-uploads" default:"1" description:"The number of uploads that may run at once"`
+	MaxTotalTransfers      int           `long:"max-total-transfers" yaml:"max-total-transfers" default:"0" description:"The number of transfers, downloads and uploads together, that may run at once, on top of the limits for each kind. Zero disables the limit"`
+	MaxQueueLength         int           `long:"max-queue-length" yaml:"max-queue-length" default:"0" description:"The number of transfers of each kind that may be waiting to start. Requests beyond it get a 503. Zero disables the limit"`
+	ChunkSize              int           `long:"chunk-size" yaml:"chunk-size" default:"0" description:"The largest number of paths downloaded by a single porklock run. Downloads with more paths are split into chunks that run one after the other. Zero disables chunking"`
+	VerifyChecksums        bool          `long:"verify-checksums" yaml:"verify-checksums" description:"Compare the checksums of downloaded files against the checksums recorded in iRODS after each download, failing the download on a mismatch"`
+	ChecksumCommand        string        `long:"checksum-command" yaml:"checksum-command" default:"ichksum" description:"The command that lists the iRODS checksums of the downloaded paths for verify-checksums"`
+	Resume                 bool          `long:"resume" yaml:"resume" description:"Leave files that are already in the download destination out of downloads, so that re-run downloads only fetch what is missing"`
+	RequireNonempty        bool          `long:"require-nonempty" yaml:"require-nonempty" description:"Fail downloads that finish without adding or updating any files in the download destination"`
+	TransferTimeout        time.Duration `long:"transfer-timeout" yaml:"transfer-timeout" default:"0" description:"How long a transfer may run before porklock is killed. Zero disables the timeout"`
+	TransferRetries        int           `long:"transfer-retries" yaml:"transfer-retries" default:"0" description:"The number of times a failed porklock run is retried"`
+	RetryDelay             time.Duration `long:"retry-delay" yaml:"retry-delay" default:"10s" description:"How long to wait before retrying a failed porklock run"`
+	RetryExitCodes         []int         `long:"retry-exit-codes" yaml:"retry-exit-codes" description:"A porklock exit code that is worth retrying. May be repeated. Failures with other exit codes aren't retried. Every failure is retried if none are given"`
+	MaxTransferTimeout     time.Duration `long:"max-transfer-timeout" yaml:"max-transfer-timeout" default:"24h" description:"The longest timeout that a transfer request may ask for. Zero allows any timeout"`
+	UploadMove             bool          `long:"upload-move" yaml:"upload-move" description:"Remove the files that porklock reports uploading from the download destination once an upload completes, moving them into iRODS. The log directory must not contain the download destination"`
+	UploadMoveConfirm      bool          `long:"upload-move-confirm" yaml:"upload-move-confirm" description:"Confirm that upload-move may remove files from the shared download destination when transfers aren't namespaced by user"`
+	UploadDebounce         time.Duration `long:"upload-debounce" yaml:"upload-debounce" default:"0" description:"How long after an upload finishes that new upload requests are coalesced into a single upload that starts once the time has passed. Zero disables debouncing"`
+	AuditLog               string        `long:"audit-log" yaml:"audit-log" description:"The path to a file that audit events for each transfer are appended to as lines of JSON, in addition to the service's log"`
+	OTelEndpoint           string        `long:"otel-endpoint" yaml:"otel-endpoint" description:"The OTLP/HTTP endpoint to export trace spans to, e.g. http://otel-collector:4318. Tracing is disabled if it is not set"`
+	CORSOrigin             string        `long:"cors-origin" yaml:"cors-origin" description:"The origin allowed to make cross-origin requests, sent in the CORS headers of OPTIONS responses. No CORS headers are sent if it is not set"`
+	PorklockEnv            []string      `long:"porklock-env" yaml:"porklock-env" description:"An environment variable in KEY=VALUE form to set for porklock. May be repeated"`
+	PorklockExtraArgs      []string      `long:"porklock-extra-arg" yaml:"porklock-extra-arg" description:"An extra argument to pass to porklock after the known arguments. May be repeated"`
+}
+
+// parseOptions parses the command-line arguments. If they name a config file
+// with --config, the file's values are used for any options that weren't
+// given on the command line, in preference to the defaults. The required
+// options are checked after the file and the command line are merged.
+func parseOptions(args []string) (*Options, error) {
+	cli := &Options{}
+	parser := flags.NewParser(cli, flags.Default)
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, err
+	}
+
+	opts := cli
+	if cli.Config != "" {
+		merged := *cli
+		if err := loadConfigFile(cli.Config, &merged); err != nil {
+			return nil, err
+		}
+
+		// The file's values replaced every option, so the ones that were given on
+		// the command line are copied back over them.
+		cliValue := reflect.ValueOf(cli).Elem()
+		mergedValue := reflect.ValueOf(&merged).Elem()
+		for _, group := range parser.Groups() {
+			for _, option := range group.Options() {
+				field := mergedValue.FieldByName(option.Field().Name)
+				if field.IsValid() && option.IsSet() && !option.IsSetDefault() {
+					field.Set(cliValue.FieldByName(option.Field().Name))
+				}
+			}
+		}
+		opts = &merged
+	}
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// loadConfigFile reads the YAML config file at configPath into opts. Options
+// that aren't in the file keep their current values. Keys that aren't options
+// are rejected, so that a misspelled option isn't silently ignored.
+func loadConfigFile(configPath string, opts *Options) error {
+	contents, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the config file %s", configPath)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(opts); err != nil && err != io.EOF {
+		return errors.Wrapf(err, "failed to parse the config file %s", configPath)
+	}
+	return nil
+}
+
+// validate checks that the required options have values, that the invocation
+// ID is a UUID, that the log file mode is octal, that moving uploaded files out
+// of a shared download destination is confirmed, that the retry exit codes are
+// valid, that there's a checksum command if checksums are verified, and that
+// the user name can be used as a directory name if transfers are namespaced by
+// user.
+func (o *Options) validate() error {
+	var missing []string
+	for name, value := range map[string]string{
+		"user":               o.User,
+		"upload-destination": o.UploadDestination,
+		"invocation-id":      o.InvocationID,
+	} {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("the required options %s must be set on the command line or in the config file", strings.Join(missing, ", "))
+	}
+
+	if _, err := uuid.Parse(o.InvocationID); err != nil {
+		return fmt.Errorf("the invocation-id %q is not a valid UUID", o.InvocationID)
+	}
+
+	if _, err := parseFileMode(o.LogFileMode); err != nil {
+		return errors.Wrap(err, "invalid log-file-mode")
+	}
+
+	if err := o.checkUploadMove(); err != nil {
+		return err
+	}
+
+	if err := o.checkRetryExitCodes(); err != nil {
+		return err
+	}
+
+	if err := o.checkChecksumCommand(); err != nil {
+		return err
+	}
+
+	if o.NamespaceByUser {
+		return checkUserDirectoryName(o.User)
+	}
+	return nil
+}
+
+// newApp returns an *App configured by the options.
+func newApp(options *Options) *App {
+	// The mode has already been checked by validate.
+	logFileMode, _ := parseFileMode(options.LogFileMode)
+
+	app := &App{
+		LogDirectory:           options.LogDirectory,
+		PorklockPath:           options.PorklockPath,
+		PorklockJar:            options.PorklockJar,
+		InvocationID:           options.InvocationID,
+		ConfigPath:             options.IRODSConfig,
+		IRODSConfigDir:         options.IRODSConfigDir,
+		User:                   options.User,
+		UploadDestination:      options.UploadDestination,
+		DownloadDestination:    options.DownloadDestination,
+		NamespaceByUser:        options.NamespaceByUser,
+		ExcludesPath:           options.ExcludesFile,
+		InputPathList:          options.PathListFile,
+		FileMetadata:           options.FileMetadata,
+		MetadataFile:           options.MetadataFile,
+		LogTailStatuses:        options.LogTailStatuses,
+		LogTailLines:           options.LogTailLines,
+		LineBuffered:           options.LineBuffered,
+		UnbufferCommand:        options.UnbufferCommand,
+		TransferWrapper:        options.TransferWrapper,
+		PorklockEnv:            options.PorklockEnv,
+		PorklockExtraArgs:      options.PorklockExtraArgs,
+		StatusCacheTTL:         options.StatusCacheTTL,
+		StatusMaxAge:           options.StatusMaxAge,
+		ShutdownLogDestination: options.ShutdownLogDestination,
+		ShutdownTimeout:        options.ShutdownTimeout,
+		GzipMinSize:            options.GzipMinSize,
+		LogRotateOnRun:         options.LogRotateOnRun,
+		LogRetentionCount:      options.LogRetentionCount,
+		CombinedLogs:           options.CombinedLogs,
+		LogFallback:            options.LogFallback,
+		LogFileMode:            logFileMode,
+		RateLimit:              options.RateLimit,
+		MaxBodyBytes:           options.MaxBodyBytes,
+		AllowedPathPrefixes:    options.AllowedPathPrefixes,
+		MaxConcurrentDownloads: options.MaxConcurrentDownloads,
+		MaxConcurrentUploads:   options.MaxConcurrentUploads,
+		MaxTotalTransfers:      options.MaxTotalTransfers,
+		MaxQueueLength:         options.MaxQueueLength,
+		ChunkSize:              options.ChunkSize,
+		VerifyChecksums:        options.VerifyChecksums,
+		ChecksumCommand:        options.ChecksumCommand,
+		TransferTimeout:        options.TransferTimeout,
+		TransferRetries:        options.TransferRetries,
+		RetryDelay:             options.RetryDelay,
+		RetryExitCodes:         options.RetryExitCodes,
+		MaxTransferTimeout:     options.MaxTransferTimeout,
+		UploadDebounce:         options.UploadDebounce,
+		CORSOrigin:             options.CORSOrigin,
+		AuditLog:               options.AuditLog,
+		Resume:                 options.Resume,
+		RequireNonempty:        options.RequireNonempty,
+		UploadMove:             options.UploadMove,
+		uploadRecords:          &HistoricalRecords{maxRecords: options.MaxHistory},
+		downloadRecords:        &HistoricalRecords{maxRecords: options.MaxHistory},
+	}
+	app.transferrer = &commandTransferrer{app: app}
+	app.auditor = logAuditSink{}
+	app.verifier = &commandVerifier{app: app}
+
+	return app
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleConfig = `user: file-user
+upload-destination: /iplant/home/file-user/outputs
+invocation-id: 3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0
+log-dir: /var/log/vice
+log-level: warn
+max-history: 50
+max-concurrent-downloads: 3
+transfer-timeout: 2h
+line-buffered: true
+porklock-env:
+  - JAVA_OPTS=-Xmx512m
+metadata:
+  - ipc-analysis-id,abc,UUID
+`
+
+func writeConfigFile(t *testing.T, contents string) (string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(dir, "config.yaml")
+	if err = ioutil.WriteFile(configPath, []byte(contents), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return configPath, func() { os.RemoveAll(dir) }
+}
+
+func TestConfigFile(t *testing.T) {
+	configPath, cleanup := writeConfigFile(t, sampleConfig)
+	defer cleanup()
+
+	options, err := parseOptions([]string{"--config", configPath, "--user", "cli-user", "--max-concurrent-downloads", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options.LogLevel != "warn" {
+		t.Errorf("log level was %q", options.LogLevel)
+	}
+
+	app := newApp(options)
+
+	if app.User != "cli-user" {
+		t.Errorf("user was %q; the command line didn't override the file", app.User)
+	}
+	if app.MaxConcurrentDownloads != 1 {
+		t.Errorf("max concurrent downloads was %d; the command line didn't override the file", app.MaxConcurrentDownloads)
+	}
+	if app.UploadDestination != "/iplant/home/file-user/outputs" || app.InvocationID != "3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0" {
+		t.Errorf("required options weren't read from the file: %q %q", app.UploadDestination, app.InvocationID)
+	}
+	if app.LogDirectory != "/var/log/vice" {
+		t.Errorf("log directory was %q", app.LogDirectory)
+	}
+	if app.downloadRecords.maxRecords != 50 {
+		t.Errorf("max history was %d", app.downloadRecords.maxRecords)
+	}
+	if app.TransferTimeout != 2*time.Hour {
+		t.Errorf("transfer timeout was %s", app.TransferTimeout)
+	}
+	if !app.LineBuffered {
+		t.Error("line buffering wasn't enabled")
+	}
+	if !reflect.DeepEqual(app.PorklockEnv, []string{"JAVA_OPTS=-Xmx512m"}) {
+		t.Errorf("porklock env was %v", app.PorklockEnv)
+	}
+	if !reflect.DeepEqual(app.FileMetadata, []string{"ipc-analysis-id,abc,UUID"}) {
+		t.Errorf("file metadata was %v", app.FileMetadata)
+	}
+
+	// Options in neither the file nor the command line keep their defaults.
+	if app.PorklockPath != "porklock" || app.LogTailLines != 20 {
+		t.Errorf("defaults weren't kept: %q %d", app.PorklockPath, app.LogTailLines)
+	}
+}
+
+func TestConfigRequiredOptions(t *testing.T) {
+	if _, err := parseOptions([]string{"--user", "cli-user"}); err == nil || !strings.Contains(err.Error(), "invocation-id, upload-destination") {
+		t.Errorf("missing required options returned %v", err)
+	}
+
+	configPath, cleanup := writeConfigFile(t, "user: file-user\n")
+	defer cleanup()
+
+	if _, err := parseOptions([]string{"--config", configPath, "--invocation-id", "3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0"}); err == nil || !strings.Contains(err.Error(), "upload-destination") {
+		t.Errorf("missing upload destination returned %v", err)
+	}
+
+	if _, err := parseOptions([]string{"--config", configPath, "--invocation-id", "3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0", "--upload-destination", "/dest"}); err != nil {
+		t.Errorf("merged options were rejected: %s", err)
+	}
+}
+
+func TestConfigFileInvalid(t *testing.T) {
+	configPath, cleanup := writeConfigFile(t, "max-history: lots\n")
+	defer cleanup()
+
+	if _, err := parseOptions([]string{"--config", configPath}); err == nil {
+		t.Error("an invalid config file was accepted")
+	}
+
+	if _, err := parseOptions([]string{"--config", configPath + ".missing"}); err == nil {
+		t.Error("a missing config file was accepted")
+	}
+}
+
+func TestConfigFileUnknownKey(t *testing.T) {
+	configPath, cleanup := writeConfigFile(t, sampleConfig+"max-histroy: 10\n")
+	defer cleanup()
+
+	if _, err := parseOptions([]string{"--config", configPath}); err == nil || !strings.Contains(err.Error(), "max-histroy") {
+		t.Errorf("a config file with an unknown key returned %v", err)
+	}
+}
+
+func TestConfigFileEmpty(t *testing.T) {
+	configPath, cleanup := writeConfigFile(t, "")
+	defer cleanup()
+
+	if _, err := parseOptions([]string{"--config", configPath, "--user", "u", "--upload-destination", "/dest", "--invocation-id", "3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0"}); err != nil {
+		t.Errorf("an empty config file returned %s", err)
+	}
+}
+
+func TestConfigInvalidInvocationID(t *testing.T) {
+	_, err := parseOptions([]string{"--user", "u", "--upload-destination", "/dest", "--invocation-id", "3d1c6b5e-0b33-4d2a-9a51"})
+	if err == nil || !strings.Contains(err.Error(), "not a valid UUID") {
+		t.Errorf("malformed invocation ID returned %v", err)
+	}
+}
+
+func TestConfigLogFileMode(t *testing.T) {
+	args := []string{"--user", "u", "--upload-destination", "/dest", "--invocation-id", "3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0"}
+
+	opts, err := parseOptions(append(args, "--log-file-mode", "0600"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := newApp(opts).LogFileMode; mode != 0600 {
+		t.Errorf("log file mode was %o, not 600", mode)
+	}
+
+	for _, mode := range []string{"0999", "rw-r--r--", "01777"} {
+		if _, err := parseOptions(append(args, "--log-file-mode", mode)); err == nil || !strings.Contains(err.Error(), "log-file-mode") {
+			t.Errorf("log file mode %q returned %v", mode, err)
+		}
+	}
+}
+
+func TestConfigRetryExitCodes(t *testing.T) {
+	args := []string{"--user", "u", "--upload-destination", "/dest", "--invocation-id", "3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0"}
+
+	opts, err := parseOptions(append(args, "--retry-exit-codes", "75", "--retry-exit-codes", "111"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opts.RetryExitCodes, []int{75, 111}) {
+		t.Errorf("retry exit codes were %v", opts.RetryExitCodes)
+	}
+
+	for _, code := range []string{"0", "256", "-1"} {
+		if _, err := parseOptions(append(args, "--retry-exit-codes", code)); err == nil {
+			t.Errorf("retry exit code %s was accepted", code)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+)
+
+// transfersContext returns the context that every transfer's context is derived
+// from. Cancelling it with cancelTransfers stops all of the transfers, queued or
+// running.
+func (a *App) transfersContext() context.Context {
+	a.transfersOnce.Do(func() {
+		a.transfersCtx, a.cancelAll = context.WithCancel(context.Background())
+	})
+	return a.transfersCtx
+}
+
+// cancelTransfers cancels every queued and running transfer.
+func (a *App) cancelTransfers() {
+	a.transfersContext()
+	a.cancelAll()
+}
+
+// newTransferContext returns the context for a transfer requested with the
+// parent context, usually the request's. The transfer's context keeps the
+// parent's values but not its cancellation, since a non-blocking transfer
+// outlives its request. It's cancelled instead when the transfer is cancelled,
+// when all transfers are cancelled, or when the transfer timeout elapses after
+// the transfer starts.
+func (a *App) newTransferContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	stop := context.AfterFunc(a.transfersContext(), cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// runContext returns the context that the record's porklock commands run with,
+// which applies the timeout from the transfer's request or, failing that, the
+// configured transfer timeout. The returned cancel function must be called once
+// the commands have finished.
+func (a *App) runContext(r *TransferRecord) (context.Context, context.CancelFunc) {
+	ctx := r.params.ctx
+	if ctx == nil {
+		ctx = a.transfersContext()
+	}
+
+	timeout := a.TransferTimeout
+	if r.params.timeout > 0 {
+		timeout = r.params.timeout
+	}
+
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// waitForStatus waits for the record to finish, failing the test if it takes
+// longer than a few seconds, and returns its final status.
+func waitForStatus(t *testing.T, r *TransferRecord) string {
+	t.Helper()
+
+	select {
+	case <-r.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the transfer did not finish")
+	}
+	return r.CurrentStatus()
+}
+
+func TestCancelTransfersStopsPorklock(t *testing.T) {
+	app, cleanup := newTestApp(t, "exec sleep 10")
+	defer cleanup()
+
+	record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.cancelTransfers()
+
+	if status := waitForStatus(t, record); status != FailedStatus {
+		t.Errorf("cancelled download had status %s", status)
+	}
+}
+
+func TestTransferTimeout(t *testing.T) {
+	app, cleanup := newTestApp(t, "exec sleep 10")
+	defer cleanup()
+
+	app.TransferTimeout = 100 * time.Millisecond
+
+	record, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := waitForStatus(t, record); status != FailedStatus {
+		t.Errorf("timed out upload had status %s", status)
+	}
+}
+
+func TestRequestTimeoutOverride(t *testing.T) {
+	app, cleanup := newTestApp(t, "exec sleep 10")
+	defer cleanup()
+
+	app.TransferTimeout = time.Hour
+	app.MaxTransferTimeout = time.Minute
+
+	start := time.Now()
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, `{"timeout": "100ms"}`)
+	if rec.Code != http.StatusOK || body["status"] != FailedStatus {
+		t.Errorf("download with a timeout returned %d %v", rec.Code, body)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the request's timeout wasn't used, the download took %s", elapsed)
+	}
+}
+
+func TestRequestTimeoutInvalid(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.MaxTransferTimeout = time.Minute
+
+	for name, body := range map[string]string{
+		"over the maximum": `{"timeout": "2m"}`,
+		"negative":         `{"timeout": "-1s"}`,
+		"malformed":        `{"timeout": "soon"}`,
+		"not a string":     `{"timeout": 60}`,
+	} {
+		for kind, handler := range map[string]http.HandlerFunc{
+			DownloadKind: app.DownloadFilesHandler,
+			UploadKind:   app.UploadFilesHandler,
+		} {
+			if rec, decoded := postTransfer(handler, "/"+kind, nil, body); rec.Code != http.StatusBadRequest || decoded["error"] == nil {
+				t.Errorf("%s with a timeout %s returned %d", kind, name, rec.Code)
+			}
+		}
+	}
+
+	if n := len(app.downloadRecords.records) + len(app.uploadRecords.records); n != 0 {
+		t.Errorf("%d records were created for invalid timeouts", n)
+	}
+}
+
+func TestNonBlockingTransferOutlivesRequest(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.2")
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	record, err := app.DownloadFiles(ctx, &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	if status := waitForStatus(t, record); status != CompletedStatus {
+		t.Errorf("download had status %s after its request ended", status)
+	}
+}
+
+func TestBlockingClientAbortCancelsTransfer(t *testing.T) {
+	app, cleanup := newTestApp(t, "exec sleep 10")
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodPost, "/download?wait=true", nil).WithContext(ctx)
+	app.DownloadFilesHandler(httptest.NewRecorder(), req)
+
+	record := app.downloadRecords.records[0]
+	if status := waitForStatus(t, record); status != FailedStatus {
+		t.Errorf("download had status %s after its client went away", status)
+	}
+}
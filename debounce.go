@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer holds back transfers that are requested too soon after the previous
+// one finished, so that a burst of requests results in a single transfer.
+type debouncer struct {
+	last    time.Time
+	pending *TransferRecord
+	mutex   sync.Mutex
+}
+
+// coalesce returns the record of the transfer that's waiting for the window to
+// pass since the previous transfer finished. If there isn't one but the window
+// hasn't passed yet, a record is created with create and passed to start once it
+// has. Returns nil if the transfer can start right away.
+func (d *debouncer) coalesce(window time.Duration, create func() *TransferRecord, start func(*TransferRecord)) *TransferRecord {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.pending != nil {
+		return d.pending
+	}
+
+	delay := window - time.Since(d.last)
+	if d.last.IsZero() || delay <= 0 {
+		return nil
+	}
+
+	r := create()
+	d.pending = r
+	time.AfterFunc(delay, func() {
+		d.mutex.Lock()
+		waiting := d.pending == r
+		if waiting {
+			d.pending = nil
+		}
+		d.mutex.Unlock()
+
+		if waiting {
+			start(r)
+		}
+	})
+	return r
+}
+
+// take removes the record of the transfer that's waiting for the window to pass
+// and returns it, so that it isn't started. Returns nil if there isn't one.
+func (d *debouncer) take() *TransferRecord {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	r := d.pending
+	d.pending = nil
+	return r
+}
+
+// finished records that a transfer has just finished, starting a new window.
+func (d *debouncer) finished() {
+	d.mutex.Lock()
+	d.last = time.Now()
+	d.mutex.Unlock()
+}
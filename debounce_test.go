@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func countRuns(t *testing.T, app *App) int {
+	t.Helper()
+
+	runs, err := ioutil.ReadFile(filepath.Join(app.LogDirectory, "runs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(runs), "\n")
+}
+
+func TestUploadDebounce(t *testing.T) {
+	app, cleanup := newTestApp(t, `echo run >> "$(dirname "$0")/runs"`)
+	defer cleanup()
+
+	app.UploadDebounce = 300 * time.Millisecond
+
+	first, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, first)
+
+	var coalesced *TransferRecord
+	for i := 0; i < 3; i++ {
+		r, err := app.UploadFiles(context.Background(), &TransferRequest{})
+		if err != nil {
+			t.Fatalf("request %d within the window failed: %s", i, err)
+		}
+		if coalesced == nil {
+			coalesced = r
+		} else if r != coalesced {
+			t.Errorf("request %d within the window got a new record", i)
+		}
+	}
+
+	if r := app.uploadRecords.FindRecord(coalesced.UUID.String()); r == nil || r.CurrentStatus() != RequestedStatus {
+		t.Error("the coalesced upload didn't wait for the window to pass")
+	}
+	if n := countRuns(t, app); n != 1 {
+		t.Errorf("porklock ran %d times before the window passed", n)
+	}
+
+	if status := waitForStatus(t, coalesced); status != CompletedStatus {
+		t.Errorf("coalesced upload finished with status %s", status)
+	}
+	if n := countRuns(t, app); n != 2 {
+		t.Errorf("porklock ran %d times, not 2", n)
+	}
+	if n := len(app.uploadRecords.records); n != 2 {
+		t.Errorf("%d upload records were created, not 2", n)
+	}
+}
+
+func TestCancelDebouncedUpload(t *testing.T) {
+	app, cleanup := newTestApp(t, `echo run >> "$(dirname "$0")/runs"`)
+	defer cleanup()
+
+	app.UploadDebounce = 100 * time.Millisecond
+
+	first, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, first)
+
+	pending, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := app.cancelQueued(UploadKind); n != 1 {
+		t.Errorf("%d uploads were cancelled, not 1", n)
+	}
+	if status := waitForStatus(t, pending); status != CancelledStatus {
+		t.Errorf("debounced upload had status %s after cancelling", status)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if n := countRuns(t, app); n != 1 {
+		t.Errorf("porklock ran %d times, not 1", n)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// errDraining is returned to requests for new transfers once the service has
+// been drained.
+var errDraining = errors.New("the service is draining and isn't accepting new transfers")
+
+// DrainStatus reports whether the service has stopped accepting new transfers.
+type DrainStatus struct {
+	Draining bool `json:"draining"`
+}
+
+// isDraining returns true once the service has been drained.
+func (a *App) isDraining() bool {
+	return atomic.LoadInt32(&a.draining) != 0
+}
+
+// rejectWhenDraining wraps the handler so that requests get a 503 once the
+// service has been drained.
+func (a *App) rejectWhenDraining(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if a.isDraining() {
+			writeJSONError(writer, http.StatusServiceUnavailable, errDraining)
+			return
+		}
+		next(writer, req)
+	}
+}
+
+// Drain stops the service from accepting new transfers. Transfers that are
+// already queued or running carry on, and the status endpoints keep working.
+// There's no way to undo it short of restarting the service.
+func (a *App) Drain(writer http.ResponseWriter, req *http.Request) {
+	if atomic.CompareAndSwapInt32(&a.draining, 0, 1) {
+		log.Warn("draining, no new transfers will be accepted")
+	}
+	render(writer, req, http.StatusOK, &DrainStatus{Draining: true})
+}
+
+// GetDrainStatus reports whether the service has been drained.
+func (a *App) GetDrainStatus(writer http.ResponseWriter, req *http.Request) {
+	render(writer, req, http.StatusOK, &DrainStatus{Draining: a.isDraining()})
+}
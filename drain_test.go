@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDrain(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.2")
+	defer cleanup()
+
+	app.GzipMinSize = 1024
+	router := app.newRouter()
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	if rec := serve(http.MethodGet, "/drain"); rec.Body.String() != `{"draining":false}` {
+		t.Errorf("drain state before draining was %s", rec.Body.String())
+	}
+
+	rec := serve(http.MethodPost, "/download")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("download before draining returned %d", rec.Code)
+	}
+	download := app.downloadRecords.records[0]
+
+	if rec := serve(http.MethodPost, "/drain"); rec.Code != http.StatusOK || rec.Body.String() != `{"draining":true}` {
+		t.Errorf("drain returned %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(http.MethodGet, "/drain"); rec.Body.String() != `{"draining":true}` {
+		t.Errorf("drain state after draining was %s", rec.Body.String())
+	}
+
+	for _, target := range []string{"/download", "/upload", "/downloads/batch"} {
+		if rec := serve(http.MethodPost, target); rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("POST to %s after draining returned %d", target, rec.Code)
+		}
+	}
+	if n := len(app.downloadRecords.records) + len(app.uploadRecords.records); n != 1 {
+		t.Errorf("%d records exist after draining", n)
+	}
+
+	if status := waitForStatus(t, download); status != CompletedStatus {
+		t.Errorf("download running while draining finished with status %s", status)
+	}
+
+	for _, target := range []string{"/status", "/downloads", "/download/" + download.UUID.String()} {
+		if rec := serve(http.MethodGet, target); rec.Code != http.StatusOK {
+			t.Errorf("GET %s after draining returned %d", target, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETag returns an entity tag for the representation of the record, which
+// changes whenever any of the record's fields do, including the progress of a
+// running transfer.
+func (r *TransferRecord) ETag(representation string) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return fmt.Sprintf(`"%s-%d-%s"`, r.UUID, r.version, representation)
+}
+
+// statusRepresentation returns the name of the representation of a record that
+// the request gets: a text summary, or JSON with or without the events.
+func statusRepresentation(req *http.Request, events bool) string {
+	switch {
+	case wantsText(req):
+		return "text"
+	case events:
+		return "json-events"
+	default:
+		return "json"
+	}
+}
+
+// matchesETag returns true if the request's If-None-Match header lists the
+// entity tag or is "*". Weak tags are compared by their opaque part.
+func matchesETag(req *http.Request, etag string) bool {
+	for _, header := range req.Header["If-None-Match"] {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// statusCacheControl returns the Cache-Control header value for status
+// responses. Clients have to revalidate with the ETag on every poll unless a
+// max age is configured.
+func statusCacheControl(maxAge time.Duration) string {
+	if maxAge <= 0 {
+		return "no-cache"
+	}
+	return fmt.Sprintf("max-age=%d, must-revalidate", int(maxAge/time.Second))
+}
+
+// notModified sets the ETag and Cache-Control headers for the representation of
+// the record's status response, then responds with a 304 and returns true if the
+// request's If-None-Match header shows that the client already has the current
+// status.
+func (a *App) notModified(writer http.ResponseWriter, req *http.Request, r *TransferRecord, representation string) bool {
+	etag := r.ETag(representation)
+	writer.Header().Set("ETag", etag)
+	writer.Header().Add("Vary", "Accept")
+	writer.Header().Set("Cache-Control", statusCacheControl(a.StatusMaxAge))
+
+	if matchesETag(req, etag) {
+		writer.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
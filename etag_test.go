@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatusETag(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+	router := app.newRouter()
+
+	r := NewDownloadRecord()
+	app.downloadRecords.Append(r)
+
+	poll := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/download/"+r.UUID.String(), nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := poll("")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first poll returned %d with ETag %q", rec.Code, etag)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Cache-Control was %q", cc)
+	}
+
+	if rec = poll(etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("poll with a current ETag returned %d %q", rec.Code, rec.Body.String())
+	}
+	if rec = poll(`"other", W/` + etag); rec.Code != http.StatusNotModified {
+		t.Errorf("poll with a list of ETags returned %d", rec.Code)
+	}
+
+	r.SetStatus(DownloadingStatus)
+	rec = poll(etag)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("poll after a status change returned %d", rec.Code)
+	}
+	if rec.Header().Get("ETag") == etag {
+		t.Error("ETag didn't change with the status")
+	}
+
+	etag = rec.Header().Get("ETag")
+	r.SetCompletionTime()
+	if rec = poll(etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("poll after completion returned %d with ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestStatusCacheControl(t *testing.T) {
+	for maxAge, expected := range map[time.Duration]string{
+		0:                "no-cache",
+		2 * time.Second:  "max-age=2, must-revalidate",
+		90 * time.Second: "max-age=90, must-revalidate",
+	} {
+		if cc := statusCacheControl(maxAge); cc != expected {
+			t.Errorf("Cache-Control for %s was %q, not %q", maxAge, cc, expected)
+		}
+	}
+}
+
+func TestStatusETagProgress(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+	router := app.newRouter()
+
+	r := NewDownloadRecord()
+	app.downloadRecords.Append(r)
+	r.SetRunning(DownloadingStatus)
+
+	poll := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/download/"+r.UUID.String(), nil)
+		req.Header.Set("If-None-Match", etag)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	etag := poll("").Header().Get("ETag")
+	for name, update := range map[string]func(){
+		"progress": func() { r.SetProgress(10, 25) },
+		"chunks":   func() { r.SetChunks(1, 3) },
+		"attempts": func() { r.SetAttempts(2) },
+	} {
+		update()
+		rec := poll(etag)
+		if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+			t.Errorf("poll after a change to the %s returned %d with ETag %q", name, rec.Code, rec.Header().Get("ETag"))
+		}
+		etag = rec.Header().Get("ETag")
+	}
+
+	if rec := poll(etag); rec.Code != http.StatusNotModified {
+		t.Errorf("poll without any changes returned %d", rec.Code)
+	}
+}
+
+func TestStatusETagRepresentation(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+	router := app.newRouter()
+
+	r := NewDownloadRecord()
+	app.downloadRecords.Append(r)
+
+	poll := func(query, accept, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/download/"+r.UUID.String()+query, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	jsonTag := poll("", "", "").Header().Get("ETag")
+	textTag := poll("", "text/plain", "").Header().Get("ETag")
+	eventsTag := poll("?events=true", "", "").Header().Get("ETag")
+	if jsonTag == textTag || jsonTag == eventsTag || textTag == eventsTag {
+		t.Errorf("the representations shared ETags: %q, %q and %q", jsonTag, textTag, eventsTag)
+	}
+
+	if rec := poll("?events=true", "", jsonTag); rec.Code != http.StatusOK {
+		t.Errorf("poll for the events with the JSON ETag returned %d", rec.Code)
+	}
+	if rec := poll("", "text/plain", textTag); rec.Code != http.StatusNotModified {
+		t.Errorf("poll for the text with its own ETag returned %d", rec.Code)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// StatusEvent records a transfer reaching a status.
+type StatusEvent struct {
+	Status string
+	Time   time.Time
+}
+
+// MarshalJSON serializes the event with its time formatted by formatTime.
+func (e StatusEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Status string `json:"status"`
+		Time   string `json:"time"`
+	}{
+		Status: e.Status,
+		Time:   formatTime(e.Time),
+	})
+}
+
+// addEvent records the record reaching the status now, unless it's already the
+// last status recorded. The mutex must be held by the caller.
+func (r *TransferRecord) addEvent(status string) {
+	if n := len(r.events); n > 0 && r.events[n-1].Status == status {
+		return
+	}
+	r.events = append(r.events, StatusEvent{Status: status, Time: time.Now()})
+}
+
+// Events returns a copy of the statuses the record has had, in the order it
+// reached them, starting with the requested status at its StartTime.
+func (r *TransferRecord) Events() []StatusEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]StatusEvent(nil), r.events...)
+}
+
+// recordWithEvents is a record that's serialized with its events in an events
+// field.
+type recordWithEvents struct {
+	*TransferRecord
+}
+
+// MarshalJSON serializes the record along with its events.
+func (r recordWithEvents) MarshalJSON() ([]byte, error) {
+	return r.marshalJSON(r.Events())
+}
+
+// wantsEvents returns true if the request has an events query parameter of
+// true, asking for the record's events to be included.
+func wantsEvents(req *http.Request) (bool, error) {
+	switch events := req.URL.Query().Get("events"); events {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid value for events: %q", events)
+	}
+}
+
+// GetTransferEvents responds with the events of the download or upload whose
+// UUID is in the path, as a JSON array.
+func (a *App) GetTransferEvents(writer http.ResponseWriter, request *http.Request) {
+	foundRecord := a.findAnyRecord(mux.Vars(request)["id"])
+	if foundRecord == nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	render(writer, request, http.StatusOK, foundRecord.Events())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransferEvents(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, record); status != CompletedStatus {
+		t.Fatalf("the download had status %s", status)
+	}
+
+	router := app.newRouter()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transfers/"+record.UUID.String()+"/events", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("getting the events returned %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var events []struct {
+		Status string `json:"status"`
+		Time   string `json:"time"`
+	}
+	if err = json.Unmarshal(rec.Body.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{RequestedStatus, DownloadingStatus, CompletedStatus}
+	if len(events) != len(expected) {
+		t.Fatalf("the events were %v", events)
+	}
+	for i, e := range events {
+		if e.Status != expected[i] {
+			t.Errorf("event %d was %s, not %s", i, e.Status, expected[i])
+		}
+		if e.Time == "" {
+			t.Errorf("event %d has no time", i)
+		}
+	}
+	if events[0].Time != formatTime(record.StartTime) {
+		t.Errorf("the requested event was at %s, not the start time", events[0].Time)
+	}
+
+	// The status response only includes the events when asked.
+	for target, included := range map[string]bool{
+		"/download/" + record.UUID.String():                  false,
+		"/download/" + record.UUID.String() + "?events=true": true,
+	} {
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var body map[string]interface{}
+		if err = json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := body["events"]; ok != included {
+			t.Errorf("%s returned %v", target, body)
+		}
+		if body["status"] != CompletedStatus {
+			t.Errorf("%s had status %v", target, body["status"])
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/download/"+record.UUID.String()+"?events=yes", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("an invalid events parameter returned %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transfers/missing/events", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("the events of a missing transfer returned %d", rec.Code)
+	}
+}
+
+func TestTransferEventsFailed(t *testing.T) {
+	r := NewUploadRecord()
+	r.SetRunning(UploadingStatus)
+	r.SetStatus(UploadingStatus)
+	r.SetFailed(context.Canceled)
+
+	var statuses []string
+	for _, e := range r.Events() {
+		statuses = append(statuses, e.Status)
+	}
+	if len(statuses) != 3 || statuses[0] != RequestedStatus || statuses[1] != UploadingStatus || statuses[2] != FailedStatus {
+		t.Errorf("the events were %v", statuses)
+	}
+}
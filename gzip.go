@@ -0,0 +1,188 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// acceptsGzip returns true if the request's Accept-Encoding header allows a
+// gzip encoded response.
+func acceptsGzip(req *http.Request) bool {
+	for _, header := range req.Header["Accept-Encoding"] {
+		for _, part := range strings.Split(header, ",") {
+			fields := strings.Split(part, ";")
+			if strings.TrimSpace(fields[0]) != "gzip" {
+				continue
+			}
+
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(param, "q=") {
+					continue
+				}
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil && q == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// compressibleType returns false for content types that are already compressed
+// or that are streamed, since buffering them for compression would delay events.
+func compressibleType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range []string{
+		"text/event-stream",
+		"application/gzip",
+		"application/x-gzip",
+		"application/zip",
+		"image/",
+		"video/",
+		"audio/",
+	} {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether the
+// response is large enough to be worth compressing, then either gzip encodes
+// the rest of the response or passes it through unchanged.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the response headers, compressing the response if compress is
+// true and the response hasn't already been encoded, then writes out anything
+// that was buffered.
+func (w *gzipResponseWriter) decide(compress bool) error {
+	w.decided = true
+
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	compress = compress &&
+		status != http.StatusNoContent &&
+		status != http.StatusNotModified &&
+		header.Get("Content-Encoding") == "" &&
+		compressibleType(header.Get("Content-Type"))
+
+	if compress {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// Flush sends any buffered data to the client. A response that hasn't reached
+// the minimum size by the time it's flushed is sent uncompressed.
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// close finishes the response, sending small responses uncompressed.
+func (w *gzipResponseWriter) close() error {
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// gzipMiddleware gzip encodes responses of at least minSize bytes for clients
+// that accept gzip encoding, other than WebSocket upgrades.
+func gzipMiddleware(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+			writer.Header().Add("Vary", "Accept-Encoding")
+
+			// WebSocket connections are hijacked, which the gzip writer
+			// doesn't support.
+			if !acceptsGzip(req) || websocket.IsWebSocketUpgrade(req) {
+				next.ServeHTTP(writer, req)
+				return
+			}
+
+			gw := &gzipResponseWriter{ResponseWriter: writer, minSize: minSize}
+			defer func() {
+				if err := gw.close(); err != nil {
+					log.Error(err)
+				}
+			}()
+
+			next.ServeHTTP(gw, req)
+		})
+	}
+}
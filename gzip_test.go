@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveGzip(handler http.HandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/downloads", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	rec := httptest.NewRecorder()
+	gzipMiddleware(100)(handler).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGzipLargeResponse(t *testing.T) {
+	payload := strings.Repeat(`{"status":"completed"}`, 50)
+
+	rec := serveGzip(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(payload))
+	}, "deflate, gzip;q=0.8")
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding was %q", rec.Header().Get("Content-Encoding"))
+	}
+
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type was %q", rec.Header().Get("Content-Type"))
+	}
+
+	reader, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != payload {
+		t.Errorf("decompressed body did not match the payload")
+	}
+}
+
+func TestGzipSmallResponse(t *testing.T) {
+	rec := serveGzip(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("small"))
+	}, "gzip")
+
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("small response was encoded as %q", rec.Header().Get("Content-Encoding"))
+	}
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status code was %d, not %d", rec.Code, http.StatusNotFound)
+	}
+
+	if rec.Body.String() != "small" {
+		t.Errorf("body was %q", rec.Body.String())
+	}
+}
+
+func TestGzipAlreadyEncoded(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(strings.Repeat("log line\n", 100)))
+	gz.Close()
+
+	rec := serveGzip(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}, "gzip")
+
+	if !bytes.Equal(rec.Body.Bytes(), compressed.Bytes()) {
+		t.Error("already encoded response was compressed again")
+	}
+}
+
+func TestGzipNotAccepted(t *testing.T) {
+	payload := strings.Repeat("log line\n", 100)
+
+	for _, acceptEncoding := range []string{"", "gzip;q=0", "br"} {
+		rec := serveGzip(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(payload))
+		}, acceptEncoding)
+
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("response for %q was encoded as %q", acceptEncoding, rec.Header().Get("Content-Encoding"))
+		}
+
+		if rec.Body.String() != payload {
+			t.Errorf("body for %q did not match the payload", acceptEncoding)
+		}
+	}
+}
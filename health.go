@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// checkLogDirectory returns an error if a file can't be created in the log
+// directory.
+func (a *App) checkLogDirectory() error {
+	f, err := ioutil.TempFile(a.LogDirectory, ".readyz")
+	if err != nil {
+		return errors.Wrapf(err, "log directory %s is not writable", a.LogDirectory)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// checkLogWrites returns the error from the last attempt to create transfer
+// logs if it failed. The failure is forgotten once a file can be created in the
+// log directory again, so that the service becomes ready again without needing
+// another transfer to be routed to it.
+func (a *App) checkLogWrites() error {
+	err := a.logWrites.get()
+	if err == nil {
+		return nil
+	}
+
+	if a.checkLogDirectory() == nil {
+		a.logWrites.set(nil)
+		return nil
+	}
+	return err
+}
+
+// checkPorklock returns an error if the porklock executable, or the transfer
+// wrapper if there is one, can't be found.
+func (a *App) checkPorklock() error {
+	if _, err := exec.LookPath(a.PorklockPath); err != nil {
+		return errors.Wrapf(err, "porklock executable %s not found", a.PorklockPath)
+	}
+	if a.TransferWrapper != "" {
+		if _, err := exec.LookPath(a.TransferWrapper); err != nil {
+			return errors.Wrapf(err, "transfer wrapper %s not found", a.TransferWrapper)
+		}
+	}
+	return nil
+}
+
+// Livez responds with a 200 as long as the service is able to handle requests.
+func (a *App) Livez(writer http.ResponseWriter, request *http.Request) {
+	writer.WriteHeader(http.StatusOK)
+}
+
+// Readyz reports whether the service is able to run transfers. It responds with
+// a 503 if any of its checks fail, including when the logs for the last
+// transfer couldn't be created in the log directory.
+func (a *App) Readyz(writer http.ResponseWriter, request *http.Request) {
+	ready := true
+	checks := make(map[string]string)
+
+	for name, check := range map[string]func() error{
+		"porklock":      a.checkPorklock,
+		"log_directory": a.checkLogDirectory,
+		"log_writes":    a.checkLogWrites,
+	} {
+		if err := check(); err != nil {
+			ready = false
+			checks[name] = err.Error()
+		} else {
+			checks[name] = "ok"
+		}
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"ready":  ready,
+		"checks": checks,
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readyz(t *testing.T, app *App) (int, map[string]interface{}) {
+	rec := httptest.NewRecorder()
+	app.Readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, body
+}
+
+func TestLivez(t *testing.T) {
+	app := &App{LogDirectory: "/nonexistent"}
+
+	rec := httptest.NewRecorder()
+	app.Livez(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status code was %d, not %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	if code, body := readyz(t, app); code != http.StatusOK || body["ready"] != true {
+		t.Errorf("readyz returned %d %v", code, body)
+	}
+}
+
+func TestReadyzMissingPorklock(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.PorklockPath = filepath.Join(app.LogDirectory, "nonexistent-porklock")
+
+	code, body := readyz(t, app)
+	if code != http.StatusServiceUnavailable || body["ready"] != false {
+		t.Errorf("readyz returned %d %v", code, body)
+	}
+}
+
+func TestReadyzReadOnlyLogDirectory(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	logDir := filepath.Join(app.LogDirectory, "logs")
+	if err := os.Mkdir(logDir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	app.LogDirectory = logDir
+	defer os.Chmod(logDir, 0755)
+
+	if f, err := os.Create(filepath.Join(logDir, "probe")); err == nil {
+		f.Close()
+		t.Skip("the log directory is writable despite its permissions, probably because the tests are running as root")
+	}
+
+	code, body := readyz(t, app)
+	if code != http.StatusServiceUnavailable || body["ready"] != false {
+		t.Errorf("readyz returned %d %v", code, body)
+	}
+
+	checks := body["checks"].(map[string]interface{})
+	if checks["log_directory"] == "ok" {
+		t.Error("log directory check passed for a read-only directory")
+	}
+}
+
+func TestReadyzMissingLogDirectory(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.LogDirectory = filepath.Join(app.LogDirectory, "nonexistent")
+
+	if code, body := readyz(t, app); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz returned %d %v", code, body)
+	}
+}
+
+func TestTransferMissingPorklock(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.PorklockPath = filepath.Join(app.LogDirectory, "nonexistent-porklock")
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"download": app.DownloadFilesHandler,
+		"upload":   app.UploadFilesHandler,
+	} {
+		_, body := postTransfer(handler, "/"+name+"?wait=true", nil, "")
+		if body["status"] != FailedStatus {
+			t.Errorf("%s without porklock had status %v", name, body["status"])
+		}
+
+		expected := "porklock executable " + app.PorklockPath + " not found"
+		if msg, _ := body["error_message"].(string); !strings.Contains(msg, expected) {
+			t.Errorf("%s error_message was %q", name, msg)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"sync"
+)
+
+const (
+	// idempotencyKeyHeader is the request header containing a client-chosen key
+	// that identifies retries of the same transfer request.
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// maxIdempotencyKeys is the number of keys of each kind that are remembered.
+	// The oldest keys are forgotten first.
+	maxIdempotencyKeys = 1000
+)
+
+// idempotencyKeys maps idempotency keys to the UUIDs of the records created for
+// them.
+type idempotencyKeys struct {
+	ids   map[string]string
+	order []string
+	mutex sync.Mutex
+}
+
+// startOnce returns the record that was created for the key if there is one and
+// it's still in the records, with replayed set to true. Otherwise it calls start
+// and remembers the record it returns, as long as the transfer was started.
+// Requests without a key always call start.
+func (k *idempotencyKeys) startOnce(key string, records *HistoricalRecords, start func() (*TransferRecord, error)) (r *TransferRecord, replayed bool, err error) {
+	if key == "" {
+		r, err = start()
+		return r, false, err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if id, ok := k.ids[key]; ok {
+		if r = records.FindRecord(id); r != nil {
+			return r, true, nil
+		}
+	}
+
+	r, err = start()
+	if err == nil {
+		k.remember(key, r.UUID.String())
+	}
+	return r, false, err
+}
+
+// remember records the UUID for the key, forgetting the oldest keys if there
+// are too many. The mutex must be held by the caller.
+func (k *idempotencyKeys) remember(key, id string) {
+	if k.ids == nil {
+		k.ids = make(map[string]string)
+	}
+
+	if _, ok := k.ids[key]; !ok {
+		k.order = append(k.order, key)
+	}
+	k.ids[key] = id
+
+	for len(k.order) > maxIdempotencyKeys {
+		delete(k.ids, k.order[0])
+		k.order[0] = ""
+		k.order = k.order[1:]
+	}
+}
+
+// writeReplayedResponse responds to a retried request with the record that was
+// created by the original request. Blocking requests wait for the transfer to
+// finish first.
+func writeReplayedResponse(writer http.ResponseWriter, req *http.Request, r *TransferRecord, blocking bool) {
+	if blocking && !waitForRecord(req, r) {
+		return
+	}
+
+	writer.Header().Set("Idempotent-Replayed", "true")
+	writer.Header().Set("Location", path.Join("/", r.Kind, r.UUID.String()))
+	render(writer, req, http.StatusOK, r)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestIdempotentTransfers(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.2")
+	defer cleanup()
+
+	for _, tc := range []struct {
+		kind    string
+		handler http.HandlerFunc
+		records *HistoricalRecords
+	}{
+		{DownloadKind, app.DownloadFilesHandler, app.downloadRecords},
+		{UploadKind, app.UploadFilesHandler, app.uploadRecords},
+	} {
+		header := http.Header{idempotencyKeyHeader: {tc.kind + "-key"}}
+
+		first, firstBody := postTransfer(tc.handler, "/"+tc.kind, header, "")
+		if first.Code != http.StatusAccepted {
+			t.Fatalf("first %s returned %d", tc.kind, first.Code)
+		}
+
+		second, secondBody := postTransfer(tc.handler, "/"+tc.kind, header, "")
+		if second.Code != http.StatusOK {
+			t.Errorf("retried %s returned %d", tc.kind, second.Code)
+		}
+		if second.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("retried %s wasn't marked as replayed", tc.kind)
+		}
+		if secondBody["uuid"] != firstBody["uuid"] {
+			t.Errorf("retried %s returned record %v, not %v", tc.kind, secondBody["uuid"], firstBody["uuid"])
+		}
+		if second.Header().Get("Location") != first.Header().Get("Location") {
+			t.Errorf("retried %s Location was %q", tc.kind, second.Header().Get("Location"))
+		}
+
+		if n := len(tc.records.records); n != 1 {
+			t.Errorf("%d %s records were created", n, tc.kind)
+		}
+	}
+}
+
+func TestIdempotentBlockingRetry(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.2")
+	defer cleanup()
+
+	header := http.Header{idempotencyKeyHeader: {"key"}}
+	first, firstBody := postTransfer(app.DownloadFilesHandler, "/download", header, "")
+	if first.Code != http.StatusAccepted {
+		t.Fatalf("first download returned %d", first.Code)
+	}
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", header, "")
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus || body["uuid"] != firstBody["uuid"] {
+		t.Errorf("blocking retry returned %d %v", rec.Code, body)
+	}
+}
+
+func TestIdempotencyKeyEviction(t *testing.T) {
+	records := &HistoricalRecords{}
+	keys := &idempotencyKeys{}
+
+	start := func() (*TransferRecord, error) {
+		r := NewDownloadRecord()
+		records.Append(r)
+		return r, nil
+	}
+
+	first, _, _ := keys.startOnce("key-0", records, start)
+	for i := 1; i <= maxIdempotencyKeys; i++ {
+		keys.startOnce(fmt.Sprintf("key-%d", i), records, start)
+	}
+
+	if n := len(keys.ids); n != maxIdempotencyKeys {
+		t.Errorf("%d keys were remembered", n)
+	}
+
+	r, replayed, _ := keys.startOnce("key-0", records, start)
+	if replayed || r == first {
+		t.Error("the oldest key wasn't forgotten")
+	}
+
+	if _, replayed, _ := keys.startOnce(fmt.Sprintf("key-%d", maxIdempotencyKeys), records, start); !replayed {
+		t.Error("the newest key was forgotten")
+	}
+}
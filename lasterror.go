@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+)
+
+// LastFailure returns the failed record with the latest StartTime, or nil if
+// none of the records have failed.
+func (h *HistoricalRecords) LastFailure() *TransferRecord {
+	var last *TransferRecord
+	for _, r := range h.FindByStatus(FailedStatus) {
+		if last == nil || r.StartTime.After(last.StartTime) {
+			last = r
+		}
+	}
+	return last
+}
+
+// lastError responds with the most recently started of the failed records, or a
+// 204 if none of them have failed.
+func (a *App) lastError(writer http.ResponseWriter, request *http.Request, records *HistoricalRecords) {
+	r := records.LastFailure()
+	if r == nil {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	a.setLogTail(r)
+	render(writer, request, http.StatusOK, r)
+}
+
+// GetLastDownloadError returns the most recent failed download.
+func (a *App) GetLastDownloadError(writer http.ResponseWriter, request *http.Request) {
+	a.lastError(writer, request, a.downloadRecords)
+}
+
+// GetLastUploadError returns the most recent failed upload.
+func (a *App) GetLastUploadError(writer http.ResponseWriter, request *http.Request) {
+	a.lastError(writer, request, a.uploadRecords)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLastError(t *testing.T) {
+	app, cleanup := newTestApp(t, "echo broken >&2; exit 3")
+	defer cleanup()
+
+	getLastError := func(handler http.HandlerFunc) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/download/last-error", nil))
+
+		body := map[string]interface{}{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec, body
+	}
+
+	if rec, _ := getLastError(app.GetLastDownloadError); rec.Code != http.StatusNoContent {
+		t.Errorf("last error without failures returned %d", rec.Code)
+	}
+
+	var failures []*TransferRecord
+	for i := 0; i < 2; i++ {
+		r, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := waitForStatus(t, r); status != FailedStatus {
+			t.Fatalf("download finished with status %s", status)
+		}
+		failures = append(failures, r)
+	}
+
+	rec, body := getLastError(app.GetLastDownloadError)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("last error returned %d", rec.Code)
+	}
+	if body["uuid"] != failures[1].UUID.String() {
+		t.Errorf("last error was %v, not the latest failure %s", body["uuid"], failures[1].UUID)
+	}
+	if body["exit_code"] != float64(3) {
+		t.Errorf("exit code was %v", body["exit_code"])
+	}
+	if body["error_message"] == nil {
+		t.Error("the last error had no error message")
+	}
+
+	if rec, _ := getLastError(app.GetLastUploadError); rec.Code != http.StatusNoContent {
+		t.Errorf("last upload error returned %d with only failed downloads", rec.Code)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultPageLimit is the number of records listed when no limit is given.
+	defaultPageLimit = 100
+
+	// maxPageLimit caps the number of records listed in a single response.
+	maxPageLimit = 1000
+)
+
+// RecordPage is the response to a request to list records. Total is the number
+// of records there are, not the number in the page.
+type RecordPage struct {
+	Total   int             `json:"total"`
+	Records transferRecords `json:"records"`
+}
+
+// TextSummary returns the summaries of the records in the page, one per line.
+func (p *RecordPage) TextSummary() string {
+	return p.Records.TextSummary()
+}
+
+// Page returns the total number of records and up to limit of them starting at
+// offset, in the order they were added.
+func (h *HistoricalRecords) Page(offset, limit int) (int, []*TransferRecord) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return len(h.records), append([]*TransferRecord{}, pageOf(h.records, offset, limit)...)
+}
+
+// pageOf returns up to limit of the records starting at offset.
+func pageOf(records []*TransferRecord, offset, limit int) []*TransferRecord {
+	total := len(records)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return records[offset:end]
+}
+
+// listRecords writes out a page of the records selected by the limit and offset
+// query parameters. Limits above maxPageLimit are lowered to it.
+func listRecords(writer http.ResponseWriter, req *http.Request, records *HistoricalRecords) {
+	offset, limit, err := parsePagination(req, defaultPageLimit, maxPageLimit)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	total, page := records.Page(offset, limit)
+	render(writer, req, http.StatusOK, &RecordPage{Total: total, Records: page})
+}
+
+// ListDownloads handles requests to list the download records.
+func (a *App) ListDownloads(writer http.ResponseWriter, req *http.Request) {
+	listRecords(writer, req, a.downloadRecords)
+}
+
+// ListUploads handles requests to list the upload records.
+func (a *App) ListUploads(writer http.ResponseWriter, req *http.Request) {
+	listRecords(writer, req, a.uploadRecords)
+}
+
+// transferFilter selects records by kind, status, and the time they were
+// requested. Empty fields and zero times match every record, and the time range
+// includes both of its ends.
+type transferFilter struct {
+	kind   string
+	status string
+	from   time.Time
+	to     time.Time
+}
+
+// matches returns true if the record is selected by the filter.
+func (f *transferFilter) matches(r *TransferRecord) bool {
+	snapshot := r.Snapshot()
+	switch {
+	case f.kind != "" && snapshot.Kind != f.kind:
+		return false
+	case f.status != "" && snapshot.Status != f.status:
+		return false
+	case !f.from.IsZero() && snapshot.StartTime.Before(f.from):
+		return false
+	case !f.to.IsZero() && snapshot.StartTime.After(f.to):
+		return false
+	}
+	return true
+}
+
+// timeParam returns the value of the query parameter as an RFC 3339 time, or
+// the zero time if it wasn't provided.
+func timeParam(req *http.Request, name string) (time.Time, error) {
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid value for %s: %q is not an RFC 3339 time", name, value)
+	}
+	return t, nil
+}
+
+// parseTransferFilter returns the filter described by the kind, status, from,
+// and to query parameters. The kind and status must be known ones, and from
+// may not be after to.
+func parseTransferFilter(req *http.Request) (*transferFilter, error) {
+	query := req.URL.Query()
+	f := &transferFilter{
+		kind:   query.Get("kind"),
+		status: query.Get("status"),
+	}
+
+	switch f.kind {
+	case "", DownloadKind, UploadKind:
+	default:
+		return nil, fmt.Errorf("invalid value for kind: %q", f.kind)
+	}
+
+	switch f.status {
+	case "", RequestedStatus, DownloadingStatus, UploadingStatus, FailedStatus, CompletedStatus, CancelledStatus:
+	default:
+		return nil, fmt.Errorf("invalid value for status: %q", f.status)
+	}
+
+	var err error
+	if f.from, err = timeParam(req, "from"); err != nil {
+		return nil, err
+	}
+	if f.to, err = timeParam(req, "to"); err != nil {
+		return nil, err
+	}
+	if !f.from.IsZero() && !f.to.IsZero() && f.from.After(f.to) {
+		return nil, fmt.Errorf("from %s is after to %s", formatTime(f.from), formatTime(f.to))
+	}
+
+	return f, nil
+}
+
+// findTransfers returns the download and upload records selected by the
+// filter, ordered by the time they were requested. Only the records of the
+// filter's kind are searched if it has one.
+func (a *App) findTransfers(f *transferFilter) []*TransferRecord {
+	stores := []*HistoricalRecords{a.downloadRecords, a.uploadRecords}
+	if f.kind != "" {
+		stores = []*HistoricalRecords{a.recordsFor(f.kind)}
+	}
+
+	var found []*TransferRecord
+	for _, records := range stores {
+		for _, r := range records.all() {
+			if f.matches(r) {
+				found = append(found, r)
+			}
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].StartTime.Before(found[j].StartTime)
+	})
+	return found
+}
+
+// ListTransfers handles requests to list the download and upload records
+// together, selected by the kind, status, from, and to query parameters and
+// ordered by the time they were requested. The results are paged with the limit
+// and offset query parameters in the same way as the per-kind listings.
+func (a *App) ListTransfers(writer http.ResponseWriter, req *http.Request) {
+	f, err := parseTransferFilter(req)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	offset, limit, err := parsePagination(req, defaultPageLimit, maxPageLimit)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	found := a.findTransfers(f)
+	render(writer, req, http.StatusOK, &RecordPage{Total: len(found), Records: pageOf(found, offset, limit)})
+}
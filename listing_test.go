@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListRecordsPaging(t *testing.T) {
+	app := &App{downloadRecords: &HistoricalRecords{}, uploadRecords: &HistoricalRecords{}}
+
+	var uuids []string
+	for i := 0; i < 5; i++ {
+		r := NewDownloadRecord()
+		app.downloadRecords.Append(r)
+		uuids = append(uuids, r.UUID.String())
+	}
+
+	for _, tc := range []struct {
+		query    string
+		expected []string
+	}{
+		{"", uuids},
+		{"?limit=2", uuids[:2]},
+		{"?limit=2&offset=2", uuids[2:4]},
+		{"?limit=2&offset=4", uuids[4:]},
+		{"?offset=5", nil},
+		{"?offset=50", nil},
+		{"?limit=0", nil},
+		{"?limit=5000", uuids},
+	} {
+		rec := httptest.NewRecorder()
+		app.ListDownloads(rec, httptest.NewRequest(http.MethodGet, "/downloads"+tc.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q returned %d", tc.query, rec.Code)
+			continue
+		}
+
+		var page struct {
+			Total   int `json:"total"`
+			Records []struct {
+				UUID string `json:"uuid"`
+			} `json:"records"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+
+		if page.Total != len(uuids) {
+			t.Errorf("total for %q was %d", tc.query, page.Total)
+		}
+		if page.Records == nil {
+			t.Errorf("records for %q weren't a list", tc.query)
+		}
+		if len(page.Records) != len(tc.expected) {
+			t.Errorf("%q listed %d records, not %d", tc.query, len(page.Records), len(tc.expected))
+			continue
+		}
+		for i, r := range page.Records {
+			if r.UUID != tc.expected[i] {
+				t.Errorf("record %d for %q was %s, not %s", i, tc.query, r.UUID, tc.expected[i])
+			}
+		}
+	}
+}
+
+func TestListRecordsInvalidParameters(t *testing.T) {
+	app := &App{downloadRecords: &HistoricalRecords{}, uploadRecords: &HistoricalRecords{}}
+
+	for _, query := range []string{"?limit=-1", "?offset=-1", "?limit=ten", "?offset=1.5"} {
+		rec := httptest.NewRecorder()
+		app.ListUploads(rec, httptest.NewRequest(http.MethodGet, "/uploads"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q returned %d", query, rec.Code)
+		}
+	}
+}
+
+func TestListTransfers(t *testing.T) {
+	app := &App{downloadRecords: &HistoricalRecords{}, uploadRecords: &HistoricalRecords{}}
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	newRecord := func(kind, status string, hours int) string {
+		r := NewDownloadRecord()
+		records := app.downloadRecords
+		if kind == UploadKind {
+			r = NewUploadRecord()
+			records = app.uploadRecords
+		}
+		r.StartTime = base.Add(time.Duration(hours) * time.Hour)
+		r.Status = status
+		records.Append(r)
+		return r.UUID.String()
+	}
+
+	// The records are added out of order to check that they're sorted.
+	upload2 := newRecord(UploadKind, FailedStatus, 2)
+	download0 := newRecord(DownloadKind, CompletedStatus, 0)
+	upload1 := newRecord(UploadKind, CompletedStatus, 1)
+	download3 := newRecord(DownloadKind, CompletedStatus, 3)
+	upload4 := newRecord(UploadKind, CompletedStatus, 4)
+
+	for _, tc := range []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{download0, upload1, upload2, download3, upload4}},
+		{"?kind=upload", []string{upload1, upload2, upload4}},
+		{"?kind=download&status=completed", []string{download0, download3}},
+		{"?status=failed", []string{upload2}},
+		{"?from=2026-03-01T13:00:00Z", []string{upload1, upload2, download3, upload4}},
+		{"?to=2026-03-01T13:00:00Z", []string{download0, upload1}},
+		{"?kind=upload&from=2026-03-01T13:00:00Z&to=2026-03-01T15:00:00Z", []string{upload1, upload2}},
+		{"?kind=upload&status=completed&from=2026-03-01T13:30:00%2B01:00", []string{upload1, upload4}},
+		{"?from=2026-03-01T13:00:00Z&to=2026-03-01T13:00:00Z", []string{upload1}},
+		{"?kind=upload&limit=1&offset=1", []string{upload2}},
+		{"?status=cancelled", nil},
+	} {
+		rec := httptest.NewRecorder()
+		app.ListTransfers(rec, httptest.NewRequest(http.MethodGet, "/transfers"+tc.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q returned %d: %s", tc.query, rec.Code, rec.Body.String())
+			continue
+		}
+
+		var page struct {
+			Records []struct {
+				UUID string `json:"uuid"`
+			} `json:"records"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, r := range page.Records {
+			got = append(got, r.UUID)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.expected, ",") {
+			t.Errorf("%q listed %v, not %v", tc.query, got, tc.expected)
+		}
+	}
+}
+
+func TestListTransfersInvalid(t *testing.T) {
+	app := &App{downloadRecords: &HistoricalRecords{}, uploadRecords: &HistoricalRecords{}}
+
+	for _, query := range []string{
+		"?kind=uploads",
+		"?status=done",
+		"?from=yesterday",
+		"?to=2026-03-01",
+		"?from=2026-03-02T00:00:00Z&to=2026-03-01T00:00:00Z",
+		"?limit=-1",
+	} {
+		rec := httptest.NewRecorder()
+		app.ListTransfers(rec, httptest.NewRequest(http.MethodGet, "/transfers"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q returned %d", query, rec.Code)
+		}
+	}
+}
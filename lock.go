@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// dirLock is an advisory lock on a directory. The lock is taken on the
+// directory itself rather than on a file in it, so there's no lock file to be
+// uploaded or removed along with the transferred files.
+type dirLock struct {
+	dir *os.File
+}
+
+// acquireDirLock takes an exclusive advisory lock on the directory. It returns
+// an error rather than waiting if another process, or another open of the
+// directory, already holds the lock. The lock is released by Release or when
+// the process exits.
+func acquireDirLock(dir string) (*dirLock, error) {
+	f, err := os.OpenFile(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s to lock it", dir)
+	}
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%s is in use by another vice-file-transfers instance", dir)
+		}
+		return nil, errors.Wrapf(err, "failed to lock %s", dir)
+	}
+
+	return &dirLock{dir: f}, nil
+}
+
+// Release unlocks and closes the directory.
+func (l *dirLock) Release() error {
+	defer l.dir.Close()
+
+	if err := syscall.Flock(int(l.dir.Fd()), syscall.LOCK_UN); err != nil {
+		return errors.Wrapf(err, "failed to unlock %s", l.dir.Name())
+	}
+	return nil
+}
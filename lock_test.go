@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDirLockExclusive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	acquired := make(chan *dirLock)
+	go func() {
+		lock, err := acquireDirLock(dir)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- lock
+	}()
+
+	first := <-acquired
+	if first == nil {
+		t.FailNow()
+	}
+
+	if _, err = acquireDirLock(dir); err == nil || !strings.Contains(err.Error(), "another vice-file-transfers instance") {
+		t.Errorf("second acquisition returned %v", err)
+	}
+
+	if err = first.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := acquireDirLock(dir)
+	if err != nil {
+		t.Fatalf("acquisition after release failed: %s", err)
+	}
+	second.Release()
+
+	// Nothing is created in the directory, so there's nothing to be uploaded.
+	if entries, err := ioutil.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("the locked directory had %d entries: %v", len(entries), err)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// reopenableFile is a log file that can be closed and reopened at the same path,
+// so that writes land in a new file after the old one has been moved away by
+// log rotation. It's safe for concurrent use.
+type reopenableFile struct {
+	path  string
+	mode  os.FileMode
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// defaultLogFileMode is the permissions given to log files when no mode is
+// configured.
+const defaultLogFileMode os.FileMode = 0644
+
+// parseFileMode parses an octal permissions string such as 0644.
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%q is not an octal file mode between 0000 and 0777", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// openLogFile opens the file at filePath with the flags and sets its
+// permissions to the mode, regardless of the umask or the permissions of an
+// existing file.
+func openLogFile(filePath string, flag int, mode os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(filePath, flag, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = f.Chmod(mode); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// createReopenableFile creates or truncates the file at filePath with the
+// permissions in mode. A zero mode uses defaultLogFileMode.
+func createReopenableFile(filePath string, mode os.FileMode) (*reopenableFile, error) {
+	if mode == 0 {
+		mode = defaultLogFileMode
+	}
+
+	f, err := openLogFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open file %s", filePath)
+	}
+	return &reopenableFile{path: filePath, mode: mode, file: f}, nil
+}
+
+// Write writes to the currently open file.
+func (r *reopenableFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Write(p)
+}
+
+// Reopen closes the file and opens the file at the same path for appending,
+// creating it if it no longer exists.
+func (r *reopenableFile) Reopen() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f, err := openLogFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, r.mode)
+	if err != nil {
+		return errors.Wrapf(err, "failed to reopen file %s", r.path)
+	}
+
+	r.file.Close()
+	r.file = f
+	return nil
+}
+
+// Close closes the currently open file.
+func (r *reopenableFile) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Close()
+}
+
+// logFileRegistry keeps track of the log files that running transfers are
+// writing to, so that they can be reopened after log rotation.
+type logFileRegistry struct {
+	files map[*reopenableFile]struct{}
+	mutex sync.Mutex
+}
+
+// add registers the files.
+func (l *logFileRegistry) add(files ...*reopenableFile) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.files == nil {
+		l.files = make(map[*reopenableFile]struct{})
+	}
+	for _, f := range files {
+		l.files[f] = struct{}{}
+	}
+}
+
+// remove unregisters the files.
+func (l *logFileRegistry) remove(files ...*reopenableFile) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, f := range files {
+		delete(l.files, f)
+	}
+}
+
+// reopen reopens every registered file, logging the ones that fail.
+func (l *logFileRegistry) reopen() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for f := range l.files {
+		if err := f.Reopen(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// transferLogs contains the files that a transfer's porklock output is written
+// to. When logs are combined, stdout and stderr are the same file.
+type transferLogs struct {
+	stdout   *reopenableFile
+	stderr   *reopenableFile
+	registry *logFileRegistry
+
+	// stdoutOutput and stderrOutput are what porklock's stdout and stderr are
+	// connected to. stderrOutput also keeps the last lines in the record's
+	// memory. When logs are combined they're the same writer, so that exec
+	// uses a single pipe and the lines stay in order.
+	stdoutOutput io.Writer
+	stderrOutput io.Writer
+}
+
+// Close closes the log files and unregisters them.
+func (l *transferLogs) Close() {
+	l.registry.remove(l.stdout, l.stderr)
+
+	l.stdout.Close()
+	if l.stderr != l.stdout {
+		l.stderr.Close()
+	}
+}
+
+// reopenLogs reopens the log files of the running transfers at the same paths.
+// It's called when the service receives a SIGHUP after the logs are rotated.
+func (a *App) reopenLogs() {
+	log.Info("reopening transfer logs")
+	a.logFiles.reopen()
+}
+
+// logWriteFailure holds the error from the last attempt to create transfer logs
+// in the log directory, if it failed. It's safe for concurrent use.
+type logWriteFailure struct {
+	err   error
+	mutex sync.Mutex
+}
+
+// set records the result of an attempt to create transfer logs. A nil error
+// clears an earlier failure.
+func (l *logWriteFailure) set(err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.err = err
+}
+
+// get returns the error from the last attempt to create transfer logs, or nil
+// if it succeeded.
+func (l *logWriteFailure) get() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.err
+}
+
+// openTransferLogs creates the log files for a transfer in the log directory.
+// If they can't be created the failure is recorded for the readiness check,
+// and with the log fallback enabled the logs are created in the system's
+// temporary directory instead.
+func (a *App) openTransferLogs(r *TransferRecord, prefix string) (*transferLogs, error) {
+	logs, err := a.openTransferLogsIn(a.LogDirectory, r, prefix)
+	if err != nil {
+		err = errors.Wrapf(err, "the transfer logs can't be written to the log directory %s", a.LogDirectory)
+	}
+	a.logWrites.set(err)
+
+	if err != nil && a.LogFallback {
+		log.Warn(errors.Wrapf(err, "writing the %s logs to %s instead", r.Kind, os.TempDir()))
+		return a.openTransferLogsIn(os.TempDir(), r, prefix)
+	}
+	return logs, err
+}
+
+// openTransferLogsIn creates the log files for a transfer in the directory and
+// records their paths on the record. The prefix is used to name the files, e.g.
+// "downloads". With combined logs enabled, stdout and stderr share a single
+// file named after the record's UUID so that their lines are interleaved in
+// the order written. The separate stdout and stderr logs also include the UUID
+// when transfers of the record's kind may run at the same time, so that they
+// don't clobber each other. With log-rotate-on-run, they're also named after the
+// time the transfer started running, with a counter added if another transfer's
+// logs already have that name, and the oldest of them beyond the retention
+// count are deleted. The files are registered so that reopenLogs can reopen
+// them. The logs are opened and recorded while holding the logs mutex, so that
+// logs being removed can't be opened by a transfer that's starting.
+func (a *App) openTransferLogsIn(dir string, r *TransferRecord, prefix string) (*transferLogs, error) {
+	a.logsMutex.Lock()
+	defer a.logsMutex.Unlock()
+
+	if a.CombinedLogs {
+		logPath := path.Join(dir, fmt.Sprintf("%s.%s.log", prefix, r.UUID.String()))
+		logFile, err := createReopenableFile(logPath, a.LogFileMode)
+		if err != nil {
+			return nil, err
+		}
+
+		r.SetLogPaths(logPath, logPath)
+		a.logFiles.add(logFile)
+		return a.newTransferLogs(r, logFile, logFile), nil
+	}
+
+	var suffix string
+	if a.queue(r.Kind).maxWorkers > 1 {
+		suffix = "." + r.UUID.String()
+	}
+
+	name := prefix + suffix
+	if a.LogRotateOnRun {
+		name = unusedLogName(dir, fmt.Sprintf("%s.%s", prefix, r.rotationTime().Format(logRotationLayout)), suffix)
+	}
+
+	stdoutPath := path.Join(dir, name+".stdout.log")
+	stdoutFile, err := createReopenableFile(stdoutPath, a.LogFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	stderrPath := path.Join(dir, name+".stderr.log")
+	stderrFile, err := createReopenableFile(stderrPath, a.LogFileMode)
+	if err != nil {
+		stdoutFile.Close()
+		return nil, err
+	}
+
+	r.SetLogPaths(stdoutPath, stderrPath)
+	a.logFiles.add(stdoutFile, stderrFile)
+	if a.LogRotateOnRun {
+		a.pruneRotatedLogs(dir, prefix)
+	}
+	return a.newTransferLogs(r, stdoutFile, stderrFile), nil
+}
+
+// newTransferLogs returns the transferLogs for the record's log files, which
+// must already be registered.
+func (a *App) newTransferLogs(r *TransferRecord, stdout, stderr *reopenableFile) *transferLogs {
+	logs := &transferLogs{
+		stdout:       stdout,
+		stderr:       stderr,
+		registry:     &a.logFiles,
+		stdoutOutput: stdout,
+		stderrOutput: io.MultiWriter(stderr, r.stderrWriter()),
+	}
+	if stdout == stderr {
+		logs.stdoutOutput = logs.stderrOutput
+	}
+	return logs
+}
+
+// appendRecord labels the record with the invocation ID and adds it to the
+// records, removing the logs and batches of any records that were evicted to
+// make room for it.
+func (a *App) appendRecord(records *HistoricalRecords, r *TransferRecord) {
+	r.InvocationID = a.InvocationID
+	evicted := records.Append(r)
+	a.removeLogs(records, evicted...)
+	a.batches.Remove(evicted...)
+	a.audit(AuditRequested, r)
+}
+
+// removeLogs deletes the log files of records that are no longer tracked. Logs
+// that are still written to by another record, as happens when the log file
+// names are shared between transfers, are left alone. Failures are logged
+// rather than returned since they shouldn't fail the operation that removed
+// the records. Transfers can't open their logs while they're being removed.
+func (a *App) removeLogs(records *HistoricalRecords, removed ...*TransferRecord) {
+	a.logsMutex.Lock()
+	defer a.logsMutex.Unlock()
+
+	for _, r := range removed {
+		stdoutPath, stderrPath := r.LogPaths()
+		for _, logPath := range []string{stdoutPath, stderrPath} {
+			if logPath == "" || records.ReferencesLog(logPath) {
+				continue
+			}
+
+			if err := os.Remove(logPath); err != nil && !os.IsNotExist(err) {
+				log.Warn(errors.Wrapf(err, "failed to remove log file %s", logPath))
+			}
+		}
+	}
+}
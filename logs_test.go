@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const interleavedScript = `echo stdout 1
+echo stderr 1 >&2
+echo stdout 2
+echo stderr 2 >&2`
+
+func TestCombinedLogs(t *testing.T) {
+	app, cleanup := newTestApp(t, interleavedScript)
+	defer cleanup()
+	app.CombinedLogs = true
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, "")
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Fatalf("download returned %d %v", rec.Code, body)
+	}
+
+	logPath := filepath.Join(app.LogDirectory, "downloads."+body["uuid"].(string)+".log")
+	contents, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "stdout 1\nstderr 1\nstdout 2\nstderr 2\n"
+	if string(contents) != expected {
+		t.Errorf("combined log was %q, not %q", string(contents), expected)
+	}
+
+	record := app.downloadRecords.FindRecord(body["uuid"].(string))
+	if record.StderrPath() != logPath {
+		t.Errorf("stderr path was %q, not %q", record.StderrPath(), logPath)
+	}
+}
+
+func TestSeparateLogs(t *testing.T) {
+	app, cleanup := newTestApp(t, interleavedScript)
+	defer cleanup()
+
+	rec, body := postTransfer(app.UploadFilesHandler, "/upload?wait=true", nil, "")
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Fatalf("upload returned %d %v", rec.Code, body)
+	}
+
+	for name, expected := range map[string]string{
+		"uploads.stdout.log": "stdout 1\nstdout 2\n",
+		"uploads.stderr.log": "stderr 1\nstderr 2\n",
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(app.LogDirectory, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(contents) != expected {
+			t.Errorf("%s was %q, not %q", name, string(contents), expected)
+		}
+	}
+}
+
+func TestLogFileMode(t *testing.T) {
+	app, cleanup := newTestApp(t, interleavedScript)
+	defer cleanup()
+
+	app.LogFileMode = 0600
+
+	rec, body := postTransfer(app.UploadFilesHandler, "/upload?wait=true", nil, "")
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Fatalf("upload returned %d %v", rec.Code, body)
+	}
+
+	for _, name := range []string{"uploads.stdout.log", "uploads.stderr.log"} {
+		info, err := os.Stat(filepath.Join(app.LogDirectory, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("%s had mode %o, not 600", name, mode)
+		}
+	}
+}
+
+// finishedRecordWithLogs returns a completed download whose logs are written to
+// the named files in dir.
+func finishedRecordWithLogs(t *testing.T, dir, stdoutName, stderrName string) *TransferRecord {
+	r := NewDownloadRecord()
+	r.SetStatus(CompletedStatus)
+
+	stdoutPath := filepath.Join(dir, stdoutName)
+	stderrPath := filepath.Join(dir, stderrName)
+	for _, p := range []string{stdoutPath, stderrPath} {
+		if err := ioutil.WriteFile(p, []byte("log\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r.SetLogPaths(stdoutPath, stderrPath)
+	return r
+}
+
+func TestEvictionRemovesLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app := &App{downloadRecords: &HistoricalRecords{maxRecords: 1}}
+
+	first := finishedRecordWithLogs(t, dir, "first.stdout.log", "first.stderr.log")
+	app.appendRecord(app.downloadRecords, first)
+
+	second := finishedRecordWithLogs(t, dir, "second.stdout.log", "second.stderr.log")
+	app.appendRecord(app.downloadRecords, second)
+
+	if app.downloadRecords.FindRecord(first.UUID.String()) != nil {
+		t.Error("the oldest record was not evicted")
+	}
+
+	for _, name := range []string{"first.stdout.log", "first.stderr.log"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", name)
+		}
+	}
+
+	for _, name := range []string{"second.stdout.log", "second.stderr.log"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was removed", name)
+		}
+	}
+}
+
+func TestEvictionKeepsSharedLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app := &App{downloadRecords: &HistoricalRecords{maxRecords: 1}}
+
+	app.appendRecord(app.downloadRecords, finishedRecordWithLogs(t, dir, "downloads.stdout.log", "downloads.stderr.log"))
+	app.appendRecord(app.downloadRecords, finishedRecordWithLogs(t, dir, "downloads.stdout.log", "downloads.stderr.log"))
+
+	for _, name := range []string{"downloads.stdout.log", "downloads.stderr.log"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was removed while another record uses it", name)
+		}
+	}
+}
+
+func TestEvictionSkipsInFlightRecords(t *testing.T) {
+	records := &HistoricalRecords{maxRecords: 1}
+
+	running := NewDownloadRecord()
+	running.SetStatus(DownloadingStatus)
+	records.Append(running)
+
+	if evicted := records.Append(NewDownloadRecord()); len(evicted) != 0 {
+		t.Errorf("%d in-flight records were evicted", len(evicted))
+	}
+
+	if records.FindRecord(running.UUID.String()) == nil {
+		t.Error("in-flight record was evicted")
+	}
+}
+
+func TestDeleteRecordRemovesLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app := &App{downloadRecords: &HistoricalRecords{}}
+	r := finishedRecordWithLogs(t, dir, "a.stdout.log", "a.stderr.log")
+	app.appendRecord(app.downloadRecords, r)
+
+	if code := deleteDownloadRecord(app, r.UUID.String()); code != http.StatusNoContent {
+		t.Fatalf("status code was %d", code)
+	}
+
+	for _, name := range []string{"a.stdout.log", "a.stderr.log"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", name)
+		}
+	}
+}
+
+func TestReopenLogsAfterRotation(t *testing.T) {
+	app, cleanup := newTestApp(t, `echo before >&2
+while [ ! -e "$(dirname "$0")/rotated" ]; do sleep 0.05; done
+echo after >&2`)
+	defer cleanup()
+
+	record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stderrPath := filepath.Join(app.LogDirectory, "downloads.stderr.log")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if contents, _ := ioutil.ReadFile(stderrPath); string(contents) == "before\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("porklock never wrote to the log")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err = os.Rename(stderrPath, stderrPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	app.reopenLogs()
+
+	if err = ioutil.WriteFile(filepath.Join(app.LogDirectory, "rotated"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, record)
+
+	for logPath, expected := range map[string]string{
+		stderrPath + ".1": "before\n",
+		stderrPath:        "after\n",
+	} {
+		contents, err := ioutil.ReadFile(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(contents) != expected {
+			t.Errorf("%s contained %q, not %q", logPath, string(contents), expected)
+		}
+	}
+
+	if n := len(app.logFiles.files); n != 0 {
+		t.Errorf("%d log files were still registered after the transfer finished", n)
+	}
+}
+
+// breakLogDirectory points the app's log directory at a path under a regular
+// file, where no logs can be created even when the tests run as root. It
+// returns the original log directory.
+func breakLogDirectory(t *testing.T, app *App) string {
+	original := app.LogDirectory
+	notDir := filepath.Join(original, "not-a-directory")
+	if err := ioutil.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	app.LogDirectory = filepath.Join(notDir, "logs")
+	return original
+}
+
+func TestUnwritableLogDirectory(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	original := breakLogDirectory(t, app)
+
+	r, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, r); status != FailedStatus {
+		t.Fatalf("download finished with status %s", status)
+	}
+	if !strings.Contains(r.ErrorMessage, "can't be written to the log directory") {
+		t.Errorf("error message was %q", r.ErrorMessage)
+	}
+
+	code, body := readyz(t, app)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("readyz returned %d %v", code, body)
+	}
+	if checks := body["checks"].(map[string]interface{}); checks["log_writes"] == "ok" {
+		t.Error("log writes check passed after the logs couldn't be created")
+	}
+
+	app.LogDirectory = original
+	if code, body = readyz(t, app); code != http.StatusOK {
+		t.Errorf("readyz returned %d %v once the log directory was writable again", code, body)
+	}
+}
+
+func TestLogFallback(t *testing.T) {
+	app, cleanup := newTestApp(t, interleavedScript)
+	defer cleanup()
+
+	breakLogDirectory(t, app)
+	app.LogFallback = true
+	app.CombinedLogs = true
+
+	r, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, r); status != CompletedStatus {
+		t.Fatalf("download finished with status %s: %s", status, r.ErrorMessage)
+	}
+	defer os.Remove(r.StderrPath())
+
+	if filepath.Dir(r.StderrPath()) != os.TempDir() {
+		t.Errorf("logs were written to %s", r.StderrPath())
+	}
+
+	contents, err := ioutil.ReadFile(r.StderrPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "stdout 1\nstderr 1\nstdout 2\nstderr 2\n"; string(contents) != expected {
+		t.Errorf("fallback log was %q, not %q", string(contents), expected)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxTailBytes is the most data read from the end of a log file when gathering
+// its last lines.
+const maxTailBytes = 64 * 1024
+
+// tailFile returns up to the last n lines of the file at filePath.
+func tailFile(filePath string, n int) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open file %s", filePath)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to stat file %s", filePath)
+	}
+
+	offset := info.Size() - maxTailBytes
+	if offset < 0 {
+		offset = 0
+	}
+
+	buf := make([]byte, info.Size()-offset)
+	if _, err = f.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "failed to read file %s", filePath)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+
+	// The first line is probably partial if the read didn't start at the
+	// beginning of the file.
+	if offset > 0 && len(lines) > 1 {
+		lines = lines[1:]
+	}
+
+	if len(lines) == 1 && lines[0] == "" {
+		return nil, nil
+	}
+
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// includeLogTail returns true if status responses for records with the status
+// should include the tail of the stderr log.
+func (a *App) includeLogTail(status string) bool {
+	for _, s := range a.LogTailStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// setLogTail populates the StderrTail field of the record with the last lines
+// of its stderr log if the record's status is one of the configured log tail
+// statuses, and clears it otherwise. The lines are taken from the ones kept in
+// memory if there are enough of them, and read from the log file otherwise.
+func (a *App) setLogTail(r *TransferRecord) {
+	stderrPath := r.StderrPath()
+	if !a.includeLogTail(r.CurrentStatus()) || stderrPath == "" || a.LogTailLines <= 0 {
+		r.SetStderrTail(nil)
+		return
+	}
+
+	if lines := r.LastLines(); lines != nil && (a.LogTailLines <= ringLines || len(lines) < ringLines) {
+		if len(lines) > a.LogTailLines {
+			lines = lines[len(lines)-a.LogTailLines:]
+		}
+		if len(lines) == 0 {
+			lines = nil
+		}
+		r.SetStderrTail(lines)
+		return
+	}
+
+	tail, err := tailFile(stderrPath, a.LogTailLines)
+	if err != nil {
+		log.Warn(errors.Wrap(err, "unable to read the stderr log tail"))
+	}
+	r.SetStderrTail(tail)
+}
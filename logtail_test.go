@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTailFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var lines []string
+	for i := 0; i < 50; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+
+	logPath := filepath.Join(dir, "log")
+	if err = ioutil.WriteFile(logPath, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tail, err := tailFile(logPath, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(tail, ",") != "line 47,line 48,line 49" {
+		t.Errorf("tail was %v", tail)
+	}
+}
+
+func statusWithTail(t *testing.T, status string) map[string]interface{} {
+	dir, err := ioutil.TempDir("", "statustail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stderrPath := filepath.Join(dir, "downloads.stderr.log")
+	if err = ioutil.WriteFile(stderrPath, []byte("something went wrong\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{
+		LogTailStatuses: []string{FailedStatus},
+		LogTailLines:    20,
+		downloadRecords: &HistoricalRecords{},
+	}
+
+	record := NewDownloadRecord()
+	record.SetLogPaths(filepath.Join(dir, "downloads.stdout.log"), stderrPath)
+	record.SetStatus(status)
+	app.downloadRecords.Append(record)
+
+	id := record.UUID.String()
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/download/"+id, nil), map[string]string{"id": id})
+	rec := httptest.NewRecorder()
+	app.GetDownloadStatus(rec, req)
+
+	var body map[string]interface{}
+	if err = json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestStatusLogTailOnFailure(t *testing.T) {
+	body := statusWithTail(t, FailedStatus)
+
+	tail, ok := body["stderr_tail"].([]interface{})
+	if !ok || len(tail) != 1 || tail[0] != "something went wrong" {
+		t.Errorf("stderr_tail was %v", body["stderr_tail"])
+	}
+}
+
+func TestStatusLogTailOmittedOnSuccess(t *testing.T) {
+	body := statusWithTail(t, CompletedStatus)
+
+	if _, ok := body["stderr_tail"]; ok {
+		t.Errorf("stderr_tail was present: %v", body["stderr_tail"])
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
-	"path"
+	"os/signal"
+	"slices"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,23 +20,28 @@ import (
 	flags "github.com/jessevdk/go-flags"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const nonBlockingKey = "non-blocking"
 
+var errTransferRunning = errors.New("a transfer is already running")
+
+// logLevels maps the accepted --log-level values to their logrus levels.
+var logLevels = map[string]logrus.Level{
+	"debug": logrus.DebugLevel,
+	"info":  logrus.InfoLevel,
+	"warn":  logrus.WarnLevel,
+	"error": logrus.ErrorLevel,
+}
+
 var log = logrus.WithFields(logrus.Fields{
 	"service": "vice-file-transfers",
 	"art-id":  "vice-file-transfers",
 	"group":   "org.cyverse",
 })
 
-var (
-	uploadRunning        bool
-	uploadRunningMutex   sync.Mutex
-	downloadRunning      bool
-	downloadRunningMutex sync.Mutex
-)
-
 const (
 	// UploadKind represents an upload record
 	UploadKind = "upload"
@@ -54,84 +63,491 @@ const (
 
 	//CompletedStatus means that the transfer request succeeded
 	CompletedStatus = "completed"
+
+	// CancelledStatus means that the transfer was cancelled before it started
+	CancelledStatus = "cancelled"
 )
 
 // TransferRecord records info about uploads and downloads.
 type TransferRecord struct {
-	UUID           uuid.UUID `json:"uuid"`
-	StartTime      time.Time `json:"start_time"`
-	CompletionTime time.Time `json:"completion_time"`
-	Status         string    `json:"status"`
-	Kind           string    `json:"kind"`
-	mutex          sync.Mutex
+	UUID             uuid.UUID     `json:"uuid"`
+	InvocationID     string        `json:"invocation_id,omitempty"`
+	StartTime        time.Time     `json:"start_time"`
+	CompletionTime   time.Time     `json:"completion_time"`
+	Status           string        `json:"status"`
+	Kind             string        `json:"kind"`
+	Priority         int           `json:"priority"`
+	StderrTail       []string      `json:"stderr_tail,omitempty"`
+	ErrorMessage     string        `json:"error_message,omitempty"`
+	ExitCode         *int          `json:"exit_code,omitempty"`
+	ChecksumVerified bool          `json:"checksum_verified"`
+	Command          []string      `json:"command,omitempty"`
+	SkippedFiles     int           `json:"skipped_files"`
+	Attempts         int           `json:"attempts"`
+	MovedFiles       bool          `json:"moved_files"`
+	FilesTotal       int           `json:"files_total"`
+	FilesTransferred int           `json:"files_transferred"`
+	ChunksTotal      int           `json:"chunks_total"`
+	ChunksCompleted  int           `json:"chunks_completed"`
+	QueuedDuration   time.Duration `json:"-"`
+	RunningDuration  time.Duration `json:"-"`
+	SystemTimeMS     int64         `json:"system_time_ms"`
+	UserTimeMS       int64         `json:"user_time_ms"`
+	runStart         time.Time
+	version          uint64
+	events           []StatusEvent
+	stdoutPath       string
+	stderrPath       string
+	stderrRing       *lineRing
+	subscribers      map[chan struct{}]struct{}
+	params           transferParams
+	done             chan struct{}
+	mutex            sync.Mutex
 }
 
 // NewDownloadRecord returns a TransferRecord filled out with a UUID,
 // StartTime, Status of "requested", and a Kind of "download".
 func NewDownloadRecord() *TransferRecord {
+	now := time.Now()
 	return &TransferRecord{
 		UUID:      uuid.New(),
-		StartTime: time.Now(),
+		StartTime: now,
 		Status:    RequestedStatus,
 		Kind:      DownloadKind,
+		events:    []StatusEvent{{Status: RequestedStatus, Time: now}},
+		done:      make(chan struct{}),
 	}
 }
 
 // NewUploadRecord returns a TransferRecord filled out with a UUID,
 // StartTime, Status of "requested", and a Kind of "upload".
 func NewUploadRecord() *TransferRecord {
+	now := time.Now()
 	return &TransferRecord{
 		UUID:      uuid.New(),
-		StartTime: time.Now(),
+		StartTime: now,
 		Status:    RequestedStatus,
-		Kind:      DownloadKind,
+		Kind:      UploadKind,
+		events:    []StatusEvent{{Status: RequestedStatus, Time: now}},
+		done:      make(chan struct{}),
 	}
 }
 
-// MarshalAndWrite serializes the TransferRecord to json and writes it out using writer.
-func (r *TransferRecord) MarshalAndWrite(writer io.Writer) error {
+// Snapshot returns a copy of the record's exported fields and the time it
+// started running, taken while holding the record's mutex so that it's
+// consistent. The copy doesn't share any slices
+// or pointers with the record, so it can be read and serialized without
+// locking while the transfer carries on.
+func (r *TransferRecord) Snapshot() TransferRecord {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var exitCode *int
+	if r.ExitCode != nil {
+		code := *r.ExitCode
+		exitCode = &code
+	}
+
+	return TransferRecord{
+		UUID:             r.UUID,
+		InvocationID:     r.InvocationID,
+		StartTime:        r.StartTime,
+		CompletionTime:   r.CompletionTime,
+		Status:           r.Status,
+		Kind:             r.Kind,
+		Priority:         r.Priority,
+		StderrTail:       append([]string(nil), r.StderrTail...),
+		ErrorMessage:     r.ErrorMessage,
+		ExitCode:         exitCode,
+		ChecksumVerified: r.ChecksumVerified,
+		Command:          append([]string(nil), r.Command...),
+		SkippedFiles:     r.SkippedFiles,
+		Attempts:         r.Attempts,
+		MovedFiles:       r.MovedFiles,
+		FilesTotal:       r.FilesTotal,
+		FilesTransferred: r.FilesTransferred,
+		ChunksTotal:      r.ChunksTotal,
+		ChunksCompleted:  r.ChunksCompleted,
+		QueuedDuration:   r.QueuedDuration,
+		RunningDuration:  r.RunningDuration,
+		SystemTimeMS:     r.SystemTimeMS,
+		UserTimeMS:       r.UserTimeMS,
+		runStart:         r.runStart,
+	}
+}
+
+// MarshalJSON serializes a snapshot of the TransferRecord to json, so the
+// record's mutex isn't held while it's encoded. The durations and progress
+// estimates that are derived from the record are computed as it's serialized.
+func (r *TransferRecord) MarshalJSON() ([]byte, error) {
+	return r.marshalJSON(nil)
+}
+
+// marshalJSON serializes the record as MarshalJSON does, adding the events in
+// an events field if there are any.
+func (r *TransferRecord) marshalJSON(events []StatusEvent) ([]byte, error) {
+	type alias TransferRecord
+
+	snapshot := r.Snapshot()
+
 	var (
-		recordbytes []byte
-		err         error
+		duration       float64
+		completionTime *string
 	)
+	if !snapshot.CompletionTime.IsZero() {
+		duration = snapshot.CompletionTime.Sub(snapshot.StartTime).Seconds()
+		formatted := formatTime(snapshot.CompletionTime)
+		completionTime = &formatted
+	}
 
-	r.mutex.Lock()
-	if recordbytes, err = json.Marshal(r); err != nil {
-		r.mutex.Unlock()
+	percent, remaining := snapshot.estimateProgress(time.Now())
+
+	return json.Marshal(&struct {
+		*alias
+		StartTime                 string        `json:"start_time"`
+		CompletionTime            *string       `json:"completion_time"`
+		DurationSeconds           float64       `json:"duration_seconds,omitempty"`
+		QueuedSeconds             float64       `json:"queued_duration_seconds,omitempty"`
+		RunningSeconds            float64       `json:"running_duration_seconds,omitempty"`
+		PercentComplete           *float64      `json:"percent_complete,omitempty"`
+		EstimatedSecondsRemaining *float64      `json:"estimated_seconds_remaining,omitempty"`
+		Events                    []StatusEvent `json:"events,omitempty"`
+	}{
+		alias:                     (*alias)(&snapshot),
+		StartTime:                 formatTime(snapshot.StartTime),
+		CompletionTime:            completionTime,
+		DurationSeconds:           duration,
+		QueuedSeconds:             snapshot.QueuedDuration.Seconds(),
+		RunningSeconds:            snapshot.RunningDuration.Seconds(),
+		PercentComplete:           percent,
+		EstimatedSecondsRemaining: remaining,
+		Events:                    events,
+	})
+}
+
+// formatTime formats the time for JSON as an RFC 3339 timestamp in UTC.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// MarshalAndWrite serializes a snapshot of the TransferRecord to json and writes
+// it out using writer. The record isn't locked while it's written.
+func (r *TransferRecord) MarshalAndWrite(writer io.Writer) error {
+	recordbytes, err := r.MarshalJSON()
+	if err != nil {
 		return errors.Wrap(err, "error serializing download record")
 	}
-	r.mutex.Unlock()
 
 	_, err = writer.Write(recordbytes)
 	return err
 }
 
-// SetCompletionTime sets the CompletionTime field for the TransferRecord to the current time.
+// changed marks the record as changed, bumping the version that its ETag is
+// based on and notifying its subscribers. The mutex must be held by the caller.
+func (r *TransferRecord) changed() {
+	r.version++
+	r.notifySubscribers()
+}
+
+// SetCompletionTime sets the CompletionTime field for the TransferRecord to the
+// current time, and the RunningDuration if the transfer started running. The
+// channel returned by Done is closed the first time it's called.
 func (r *TransferRecord) SetCompletionTime() {
 	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	r.CompletionTime = time.Now()
-	r.mutex.Unlock()
+	if !r.runStart.IsZero() {
+		r.RunningDuration = r.CompletionTime.Sub(r.runStart)
+	}
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+	r.changed()
+}
+
+// Done returns a channel that's closed once the transfer has finished.
+func (r *TransferRecord) Done() <-chan struct{} {
+	return r.done
 }
 
 // SetStatus sets the Status field for the TransferRecord to the provided value.
 func (r *TransferRecord) SetStatus(status string) {
 	r.mutex.Lock()
 	r.Status = status
+	r.addEvent(status)
+	r.changed()
+	r.mutex.Unlock()
+
+	bumpStatusGeneration()
+}
+
+// SetRunning sets the Status field for the TransferRecord to the provided
+// running status, and records how long the transfer waited to start since it
+// was requested as the QueuedDuration. The QueuedDuration is also observed in
+// the queued duration histogram.
+func (r *TransferRecord) SetRunning(status string) {
+	r.mutex.Lock()
+	r.runStart = time.Now()
+	r.QueuedDuration = r.runStart.Sub(r.StartTime)
+	r.Status = status
+	r.addEvent(status)
+	r.changed()
+	kind, queued := r.Kind, r.QueuedDuration
+	r.mutex.Unlock()
+
+	queuedDuration.WithLabelValues(kind).Observe(queued.Seconds())
+
+	bumpStatusGeneration()
+}
+
+// SetFailed sets the Status field for the TransferRecord to FailedStatus and
+// records the error that caused the failure in the ErrorMessage field.
+func (r *TransferRecord) SetFailed(err error) {
+	r.mutex.Lock()
+	r.Status = FailedStatus
+	r.ErrorMessage = err.Error()
+	r.addEvent(FailedStatus)
+	r.changed()
+	r.mutex.Unlock()
+
+	bumpStatusGeneration()
+}
+
+// SetCancelled sets the Status field for the TransferRecord to CancelledStatus
+// and records why it was cancelled in the ErrorMessage field.
+func (r *TransferRecord) SetCancelled(err error) {
+	r.mutex.Lock()
+	r.Status = CancelledStatus
+	r.ErrorMessage = err.Error()
+	r.addEvent(CancelledStatus)
+	r.changed()
+	r.mutex.Unlock()
+
+	bumpStatusGeneration()
+}
+
+// SetChecksumVerified records that the checksums of the transferred files were
+// verified.
+func (r *TransferRecord) SetChecksumVerified() {
+	r.mutex.Lock()
+	r.ChecksumVerified = true
+	r.changed()
+	r.mutex.Unlock()
+}
+
+// SetCommand records the porklock command line that runs the transfer.
+func (r *TransferRecord) SetCommand(parts []string) {
+	r.mutex.Lock()
+	r.Command = append([]string(nil), parts...)
+	r.changed()
+	r.mutex.Unlock()
+}
+
+// SetSkippedFiles records the number of files that weren't downloaded because
+// they were already present.
+func (r *TransferRecord) SetSkippedFiles(n int) {
+	r.mutex.Lock()
+	r.SkippedFiles = n
+	r.changed()
+	r.mutex.Unlock()
+}
+
+// SetAttempts records the number of times porklock has been run for the
+// transfer.
+func (r *TransferRecord) SetAttempts(n int) {
+	r.mutex.Lock()
+	r.Attempts = n
+	r.changed()
+	r.mutex.Unlock()
+}
+
+// SetMovedFiles records that the uploaded files were removed from the local
+// source.
+func (r *TransferRecord) SetMovedFiles() {
+	r.mutex.Lock()
+	r.MovedFiles = true
+	r.changed()
+	r.mutex.Unlock()
+}
+
+// SetProgress records how many of the transfer's files have been transferred
+// so far, out of the total.
+func (r *TransferRecord) SetProgress(transferred, total int) {
+	r.mutex.Lock()
+	r.FilesTransferred = transferred
+	r.FilesTotal = total
+	r.changed()
+	r.mutex.Unlock()
+}
+
+// SetChunks records how many of the porklock runs that the transfer is split
+// into have completed, out of the total.
+func (r *TransferRecord) SetChunks(completed, total int) {
+	r.mutex.Lock()
+	r.ChunksCompleted = completed
+	r.ChunksTotal = total
+	r.changed()
+	r.mutex.Unlock()
+}
+
+// SetProcessState records the exit code of the porklock process and the system
+// and user CPU time it used. It does nothing if the process didn't start. The
+// exit code is -1 if the process was killed by a signal.
+func (r *TransferRecord) SetProcessState(state *os.ProcessState) {
+	if state == nil {
+		return
+	}
+
+	exitCode := state.ExitCode()
+
+	r.mutex.Lock()
+	r.ExitCode = &exitCode
+	r.SystemTimeMS = state.SystemTime().Milliseconds()
+	r.UserTimeMS = state.UserTime().Milliseconds()
+	r.changed()
+	r.mutex.Unlock()
+}
+
+// Cancel cancels the transfer's context, killing porklock if it's running. It
+// does nothing for records that weren't given a context.
+func (r *TransferRecord) Cancel() {
+	if r.params.cancel != nil {
+		r.params.cancel()
+	}
+}
+
+// CurrentStatus returns the value of the Status field for the TransferRecord.
+func (r *TransferRecord) CurrentStatus() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.Status
+}
+
+// SetLogPaths records the paths to the stdout and stderr logs for the transfer.
+func (r *TransferRecord) SetLogPaths(stdoutPath, stderrPath string) {
+	r.mutex.Lock()
+	r.stdoutPath = stdoutPath
+	r.stderrPath = stderrPath
+	r.mutex.Unlock()
+}
+
+// LogPaths returns the paths to the stdout and stderr logs for the transfer.
+func (r *TransferRecord) LogPaths() (string, string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stdoutPath, r.stderrPath
+}
+
+// StderrPath returns the path to the stderr log for the transfer. It will be
+// empty if the transfer hasn't created its logs yet.
+func (r *TransferRecord) StderrPath() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stderrPath
+}
+
+// stderrWriter returns the writer that keeps the last lines of the transfer's
+// stderr in memory, creating it the first time it's needed.
+func (r *TransferRecord) stderrWriter() *lineRing {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stderrRing == nil {
+		r.stderrRing = newLineRing(ringLines)
+	}
+	return r.stderrRing
+}
+
+// LastLines returns the last lines that porklock wrote to stderr for the
+// transfer, oldest first, or nil if porklock hasn't been run for it.
+func (r *TransferRecord) LastLines() []string {
+	r.mutex.Lock()
+	ring := r.stderrRing
+	r.mutex.Unlock()
+
+	if ring == nil {
+		return nil
+	}
+	return ring.Lines()
+}
+
+// SetStderrTail sets the StderrTail field for the TransferRecord to the provided
+// lines. The record only counts as changed if the lines are different.
+func (r *TransferRecord) SetStderrTail(lines []string) {
+	r.mutex.Lock()
+	if !slices.Equal(r.StderrTail, lines) {
+		r.changed()
+	}
+	r.StderrTail = lines
 	r.mutex.Unlock()
 }
 
+// isTerminalStatus returns true if a transfer with the status will not change
+// status again.
+func isTerminalStatus(status string) bool {
+	return status == CompletedStatus || status == FailedStatus || status == CancelledStatus
+}
+
 // HistoricalRecords maintains a list of []*TransferRecords and provides thread-safe access
-// to them.
+// to them. If maxRecords is positive, the oldest records in a terminal state are evicted
+// to keep the list from growing beyond it. The records are also indexed by UUID, and the
+// index always contains exactly the records in the list.
 type HistoricalRecords struct {
-	records []*TransferRecord
-	mutex   sync.Mutex
+	records    []*TransferRecord
+	byUUID     map[string]*TransferRecord
+	maxRecords int
+	mutex      sync.Mutex
 }
 
-// Append adds another *TransferRecord to the list.
-func (h *HistoricalRecords) Append(tr *TransferRecord) {
+// Append adds another *TransferRecord to the list, returning any records that were
+// evicted to make room for it.
+func (h *HistoricalRecords) Append(tr *TransferRecord) []*TransferRecord {
+	var evicted []*TransferRecord
+
 	h.mutex.Lock()
 	h.records = append(h.records, tr)
+	if h.byUUID == nil {
+		h.byUUID = make(map[string]*TransferRecord)
+	}
+	h.byUUID[tr.UUID.String()] = tr
+
+	if h.maxRecords > 0 {
+		kept := h.records[:0]
+		excess := len(h.records) - h.maxRecords
+		for _, r := range h.records {
+			if excess > 0 && isTerminalStatus(r.CurrentStatus()) {
+				evicted = append(evicted, r)
+				delete(h.byUUID, r.UUID.String())
+				excess--
+				continue
+			}
+			kept = append(kept, r)
+		}
+		for i := len(kept); i < len(h.records); i++ {
+			h.records[i] = nil
+		}
+		h.records = kept
+	}
 	h.mutex.Unlock()
+
+	bumpStatusGeneration()
+	return evicted
+}
+
+// ReferencesLog returns true if any of the records write to the log file at logPath.
+func (h *HistoricalRecords) ReferencesLog(logPath string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, r := range h.records {
+		if stdoutPath, stderrPath := r.LogPaths(); stdoutPath == logPath || stderrPath == logPath {
+			return true
+		}
+	}
+	return false
 }
 
 // FindRecord looks up a record by UUID and returns the pointer to it. The lookup is locked
@@ -141,265 +557,864 @@ func (h *HistoricalRecords) FindRecord(id string) *TransferRecord {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	for _, dr := range h.records {
-		if dr.UUID.String() == id {
-			return dr
+	return h.byUUID[id]
+}
+
+// FindByStatus returns the records that currently have the provided status, in
+// the order they were added.
+func (h *HistoricalRecords) FindByStatus(status string) []*TransferRecord {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var found []*TransferRecord
+	for _, r := range h.records {
+		if r.CurrentStatus() == status {
+			found = append(found, r)
 		}
 	}
+	return found
+}
 
-	return nil
+// Remove deletes the record with the provided id from the list. Returns false if
+// no records are found with the provided id.
+func (h *HistoricalRecords) Remove(id string) bool {
+	h.mutex.Lock()
+	r, ok := h.byUUID[id]
+	if !ok {
+		h.mutex.Unlock()
+		return false
+	}
+	delete(h.byUUID, id)
+
+	for i, dr := range h.records {
+		if dr == r {
+			h.records = append(h.records[:i], h.records[i+1:]...)
+			break
+		}
+	}
+	h.mutex.Unlock()
+
+	bumpStatusGeneration()
+	return true
 }
 
 // App contains application state.
 type App struct {
-	LogDirectory        string
-	User                string
-	UploadDestination   string
-	DownloadDestination string
-	InvocationID        string
-	InputPathList       string
-	ExcludesPath        string
-	ConfigPath          string
-	FileMetadata        []string
-	downloadWait        sync.WaitGroup
-	uploadWait          sync.WaitGroup
-	uploadRecords       *HistoricalRecords
-	downloadRecords     *HistoricalRecords
-}
-
-func (a *App) downloadCommand() []string {
-	retval := []string{
-		"porklock",
+	LogDirectory           string
+	PorklockPath           string
+	PorklockJar            string
+	User                   string
+	UploadDestination      string
+	DownloadDestination    string
+	NamespaceByUser        bool
+	InvocationID           string
+	InputPathList          string
+	LogTailStatuses        []string
+	LogTailLines           int
+	ExcludesPath           string
+	ConfigPath             string
+	IRODSConfigDir         string
+	FileMetadata           []string
+	MetadataFile           string
+	LineBuffered           bool
+	UnbufferCommand        string
+	TransferWrapper        string
+	PorklockEnv            []string
+	PorklockExtraArgs      []string
+	StatusCacheTTL         time.Duration
+	StatusMaxAge           time.Duration
+	ShutdownLogDestination string
+	ShutdownTimeout        time.Duration
+	GzipMinSize            int
+	LogRotateOnRun         bool
+	LogRetentionCount      int
+	CombinedLogs           bool
+	LogFileMode            os.FileMode
+	LogFallback            bool
+	logWrites              logWriteFailure
+	RateLimit              float64
+	MaxBodyBytes           int64
+	draining               int32
+	AllowedPathPrefixes    []string
+	VerifyChecksums        bool
+	ChecksumCommand        string
+	TransferTimeout        time.Duration
+	TransferRetries        int
+	RetryDelay             time.Duration
+	RetryExitCodes         []int
+	MaxTransferTimeout     time.Duration
+	UploadDebounce         time.Duration
+	uploadDebounce         debouncer
+	CORSOrigin             string
+	Resume                 bool
+	RequireNonempty        bool
+	UploadMove             bool
+	transfersOnce          sync.Once
+	transfersCtx           context.Context
+	cancelAll              context.CancelFunc
+	tracerProvider         trace.TracerProvider
+	transferrer            Transferrer
+	AuditLog               string
+	auditor                AuditSink
+	verifier               ChecksumVerifier
+	statusCache            statusSummaryCache
+	MaxConcurrentDownloads int
+	MaxConcurrentUploads   int
+	MaxTotalTransfers      int
+	MaxQueueLength         int
+	ChunkSize              int
+	queuesOnce             sync.Once
+	downloads              *transferQueue
+	uploads                *transferQueue
+	batches                batchRegistry
+	logFiles               logFileRegistry
+	logsMutex              sync.Mutex
+	downloadKeys           idempotencyKeys
+	uploadKeys             idempotencyKeys
+	uploadRecords          *HistoricalRecords
+	downloadRecords        *HistoricalRecords
+}
+
+// wrapCommand prefixes the command with the transfer wrapper if there is one,
+// and then with the unbuffer command when line buffered output is enabled, so
+// that porklock's output reaches the logs promptly. The wrapper comes straight
+// before porklock so that its arguments are the porklock command.
+func (a *App) wrapCommand(parts []string) []string {
+	if a.TransferWrapper != "" {
+		parts = append([]string{a.TransferWrapper}, parts...)
+	}
+	if !a.LineBuffered {
+		return parts
+	}
+	return append(strings.Fields(a.UnbufferCommand), parts...)
+}
+
+// commandWaitDelay is how long a killed command's output is waited for before
+// its pipes are closed, in case a child of porklock still holds them open.
+const commandWaitDelay = 10 * time.Second
+
+// newCommand returns an *exec.Cmd for the command parts that's killed if the
+// context is done before it exits. The command inherits the environment of the
+// service with the configured porklock environment variables added to it.
+func (a *App) newCommand(ctx context.Context, parts []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	cmd.WaitDelay = commandWaitDelay
+	cmd.Env = append(os.Environ(), a.PorklockEnv...)
+	return cmd
+}
+
+// newPorklockCommand returns the command for the porklock command parts as
+// newCommand does, after checking that porklock and the transfer wrapper can
+// still be found. They're looked up again on every run in case they've
+// disappeared since startup, because running a missing executable fails with an
+// unclear error.
+func (a *App) newPorklockCommand(ctx context.Context, parts []string) (*exec.Cmd, error) {
+	if err := a.checkPorklock(); err != nil {
+		return nil, err
+	}
+	return a.newCommand(ctx, parts), nil
+}
+
+// validateEnv checks that each of the entries is in KEY=VALUE form.
+func validateEnv(entries []string) error {
+	for _, entry := range entries {
+		if strings.Index(entry, "=") < 1 {
+			return fmt.Errorf("environment variable %q is not in KEY=VALUE form", entry)
+		}
+	}
+	return nil
+}
+
+// porklockCommand returns the start of a command line that runs the porklock
+// subcommand using the configured porklock binary and jar.
+func (a *App) porklockCommand(subcommand string) []string {
+	return []string{
+		a.PorklockPath,
 		"-jar",
-		"/usr/src/app/porklock-standalone.jar",
-		"get",
-		"--user", a.User,
-		"--source-list", a.InputPathList,
-		"--destination", a.DownloadDestination,
-		"-c", a.ConfigPath,
+		a.PorklockJar,
+		subcommand,
 	}
+}
+
+// downloadCommand returns the command line for downloading the paths listed in
+// the pathList file to the destination directory using the iRODS config file at
+// configPath, applying the metadata in the metadataFile if it isn't empty.
+func (a *App) downloadCommand(pathList, destination, configPath, metadataFile string) []string {
+	retval := append(
+		a.porklockCommand("get"),
+		"--user", a.User,
+		"--source-list", pathList,
+		"--destination", destination,
+		"-c", configPath,
+	)
+	retval = append(retval, a.metadataArgs(metadataFile)...)
+	retval = append(retval, a.PorklockExtraArgs...)
+	return a.wrapCommand(retval)
+}
+
+// metadataArgs returns the porklock arguments for the configured individual
+// metadata, followed by the metadata file if it isn't empty.
+func (a *App) metadataArgs(metadataFile string) []string {
+	var retval []string
 	for _, fm := range a.FileMetadata {
 		retval = append(retval, "-m", fm)
 	}
+	if metadataFile != "" {
+		retval = append(retval, "--metadata-file", metadataFile)
+	}
 	return retval
 }
 
-func (a *App) fileUseable(aPath string) bool {
-	if _, err := os.Stat(aPath); err != nil {
-		return false
+// pathListProblem returns an error describing why the path list at aPath can't
+// be used, distinguishing a missing file, one that can't be read, and a
+// directory. Returns nil if the path list can be read.
+func (a *App) pathListProblem(aPath string) error {
+	return fileProblem("input path list", aPath)
+}
+
+// fileProblem returns an error describing why the file at aPath can't be read,
+// using the description to refer to it. Returns nil if the file can be read.
+func fileProblem(description, aPath string) error {
+	info, err := os.Stat(aPath)
+	switch {
+	case os.IsNotExist(err):
+		return fmt.Errorf("%s %s does not exist", description, aPath)
+	case os.IsPermission(err):
+		return fmt.Errorf("%s %s can't be accessed: permission denied", description, aPath)
+	case err != nil:
+		return errors.Wrapf(err, "%s %s is not usable", description, aPath)
+	case info.IsDir():
+		return fmt.Errorf("%s %s is a directory, not a file", description, aPath)
 	}
-	return true
+
+	f, err := os.Open(aPath)
+	if err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("%s %s is not readable: permission denied", description, aPath)
+		}
+		return errors.Wrapf(err, "%s %s is not usable", description, aPath)
+	}
+	f.Close()
+
+	return nil
 }
 
-// DownloadFiles triggers a download and returns a *TransferRecord.
-func (a *App) DownloadFiles() *TransferRecord {
+// metadataFileProblem returns an error if the record has a metadata file that
+// can't be read.
+func metadataFileProblem(r *TransferRecord) error {
+	if r.params.metadataFile == "" {
+		return nil
+	}
+	return fileProblem("metadata file", r.params.metadataFile)
+}
+
+// DownloadFiles queues a download of the configured input path list, or of the
+// request's single path if it has one, and returns a *TransferRecord. The
+// returned error is non-nil if the download wasn't queued. The record is marked
+// as cancelled if another download is queued or running, unless the download
+// is forced, and as failed if the path list or user directory can't be used.
+// No record is created if the download destination isn't allowed or the
+// download queue is full.
+func (a *App) DownloadFiles(ctx context.Context, tr *TransferRequest) (*TransferRecord, error) {
+	if err := a.checkAllowedPaths(a.DownloadDestination); err != nil {
+		return nil, err
+	}
+
+	if err := a.checkQueueLength(DownloadKind, 1); err != nil {
+		return nil, err
+	}
+
+	destination, destinationErr := a.userDirectory(a.DownloadDestination)
+
 	downloadRecord := NewDownloadRecord()
-	a.downloadRecords.Append(downloadRecord)
+	downloadRecord.Priority = tr.Priority
+	downloadRecord.params = transferParams{
+		pathList:     a.InputPathList,
+		destination:  destination,
+		configPath:   a.configPath(tr),
+		metadataFile: a.metadataFile(tr),
+		timeout:      time.Duration(tr.Timeout),
+	}
+	downloadRecord.params.ctx, downloadRecord.params.cancel = a.newTransferContext(ctx)
+	a.appendRecord(a.downloadRecords, downloadRecord)
 
-	downloadRunningMutex.Lock()
-	shouldRun := !downloadRunning && a.fileUseable(a.InputPathList)
-	downloadRunningMutex.Unlock()
+	if destinationErr != nil {
+		a.failUnstarted(downloadRecord, destinationErr)
+		return downloadRecord, destinationErr
+	}
 
-	if shouldRun {
-		log.Info("starting download goroutine")
+	if tr.Path != "" {
+		pathList, err := writePathListFile([]string{tr.Path})
+		if err != nil {
+			a.failUnstarted(downloadRecord, err)
+			return downloadRecord, err
+		}
+		downloadRecord.params.pathList = pathList
+		downloadRecord.params.tempFiles = []string{pathList}
+	} else if err := a.pathListProblem(a.InputPathList); err != nil {
+		a.failUnstarted(downloadRecord, err)
+		return downloadRecord, err
+	}
 
-		a.downloadWait.Add(1)
+	if tr.Force {
+		log.Warnf("download %s was forced, queueing it even if other downloads are running", downloadRecord.UUID)
+		a.queue(DownloadKind).Enqueue(downloadRecord)
+	} else if !a.queue(DownloadKind).EnqueueIfIdle(downloadRecord) {
+		a.refuseUnstarted(downloadRecord)
+		return downloadRecord, errTransferRunning
+	}
 
-		go func() {
-			log.Info("running download goroutine")
+	log.Info("queued download")
 
-			var (
-				downloadLogStderrFile *os.File
-				downloadLogStdoutFile *os.File
-				downloadLogStderrPath string
-				downloadLogStdoutPath string
-				err                   error
-			)
+	return downloadRecord, nil
+}
 
-			downloadRunningMutex.Lock()
-			downloadRunning = true
-			downloadRunningMutex.Unlock()
+// failUnstarted marks the record of a transfer that couldn't be started as
+// failed with the error.
+func (a *App) failUnstarted(r *TransferRecord, err error) {
+	r.SetFailed(err)
+	r.SetCompletionTime()
+	r.Cancel()
+	a.auditFinished(r)
+}
 
-			downloadRecord.SetStatus(DownloadingStatus)
+// refuseUnstarted marks the record of a transfer that wasn't queued because
+// another transfer is queued or running as cancelled, so that it doesn't stay
+// requested, and removes its temporary files.
+func (a *App) refuseUnstarted(r *TransferRecord) {
+	r.SetCancelled(errTransferRunning)
+	r.SetCompletionTime()
+	r.Cancel()
+	removeTempFiles(r.params.tempFiles)
+	a.auditFinished(r)
+}
 
-			defer func() {
-				downloadRecord.SetCompletionTime()
+// runDownload runs the porklock download described by the record's parameters.
+// It's called by the download queue.
+func (a *App) runDownload(downloadRecord *TransferRecord) {
+	log.Infof("running download %s", downloadRecord.UUID)
 
-				downloadRunningMutex.Lock()
-				downloadRunning = false
-				downloadRunningMutex.Unlock()
+	downloadRecord.SetRunning(DownloadingStatus)
+	a.audit(AuditStarted, downloadRecord)
+	defer a.auditFinished(downloadRecord)
+	defer downloadRecord.SetCompletionTime()
+	defer downloadRecord.Cancel()
+	defer removeTempFiles(downloadRecord.params.tempFiles)
+	defer recoverTransfer(downloadRecord)
 
-				a.downloadWait.Done()
-			}()
+	ctx, cancel := a.runContext(downloadRecord)
+	defer cancel()
 
-			downloadLogStdoutPath = path.Join(a.LogDirectory, "downloads.stdout.log")
-			downloadLogStdoutFile, err = os.Create(downloadLogStdoutPath)
-			if err != nil {
-				log.Error(errors.Wrapf(err, "failed to open file %s", downloadLogStdoutPath))
-				downloadRecord.SetStatus(FailedStatus)
-				return
+	ctx, span := a.startTransferSpan(ctx, downloadRecord)
+	var err error
+	defer func() { endTransferSpan(span, downloadRecord, err) }()
 
-			}
+	logs, err := a.openTransferLogs(downloadRecord, "downloads")
+	if err != nil {
+		log.Error(err)
+		downloadRecord.SetFailed(err)
+		return
+	}
+	defer logs.Close()
+
+	if err = metadataFileProblem(downloadRecord); err != nil {
+		log.Error(err)
+		downloadRecord.SetFailed(err)
+		return
+	}
 
-			downloadLogStderrPath = path.Join(a.LogDirectory, "downloads.stderr.log")
-			downloadLogStderrFile, err = os.Create(downloadLogStderrPath)
-			if err != nil {
-				log.Error(errors.Wrapf(err, "failed to open file %s", downloadLogStderrPath))
-				downloadRecord.SetStatus(FailedStatus)
+	pathList := downloadRecord.params.pathList
+	if a.Resume {
+		var skipped int
+		if pathList, skipped, err = resumePathList(pathList, downloadRecord.params.destination); err != nil {
+			log.Error(err)
+			downloadRecord.SetFailed(err)
+			return
+		}
+		if pathList != "" && pathList != downloadRecord.params.pathList {
+			defer os.Remove(pathList)
+		}
+		downloadRecord.SetSkippedFiles(skipped)
+	}
+
+	if pathList == "" {
+		log.Infof("all of the files for download %s are already present", downloadRecord.UUID)
+	} else {
+		// The destination's files are noted before porklock runs so that a
+		// download that doesn't fetch anything can be failed afterwards.
+		var before map[string]time.Time
+		if a.RequireNonempty {
+			if before, err = snapshotFiles(downloadRecord.params.destination); err != nil {
+				log.Error(err)
+				downloadRecord.SetFailed(err)
 				return
 			}
+		}
 
-			parts := a.downloadCommand()
-			cmd := exec.Command(parts[0], parts[1:]...)
-			cmd.Stdout = downloadLogStdoutFile
-			cmd.Stderr = downloadLogStderrFile
+		if err = a.runChunks(ctx, downloadRecord, pathList, logs); err != nil {
+			log.Error(err)
+			downloadRecord.SetFailed(err)
+			return
+		}
 
-			if err = cmd.Run(); err != nil {
-				log.Error(errors.Wrap(err, "error running porklock for downloads"))
-				downloadRecord.SetStatus(FailedStatus)
+		if a.RequireNonempty {
+			if err = checkFilesLanded(downloadRecord, before); err != nil {
+				log.Error(err)
+				downloadRecord.SetFailed(err)
 				return
 			}
+		}
+	}
 
-			downloadRecord.SetStatus(CompletedStatus)
-
-			log.Info("exiting download goroutine without errors")
-		}()
+	if err = a.verifyChecksums(ctx, downloadRecord, logs); err != nil {
+		log.Error(err)
+		downloadRecord.SetFailed(err)
+		return
 	}
 
-	return downloadRecord
+	downloadRecord.SetStatus(CompletedStatus)
+
+	log.Infof("download %s finished without errors", downloadRecord.UUID)
 }
 
 // DownloadFilesHandler handles requests to download files.
 func (a *App) DownloadFilesHandler(writer http.ResponseWriter, req *http.Request) {
 	log.Info("received download request")
 
-	downloadRecord := a.DownloadFiles()
+	blocking, err := wantsBlocking(req)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
 
-	if err := downloadRecord.MarshalAndWrite(writer); err != nil {
-		log.Error(err)
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	tr, err := decodeTransferRequest(req)
+	if err != nil {
+		writeJSONError(writer, decodeErrorStatus(err), err)
+		return
+	}
+
+	if err = a.checkTimeout(tr); err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = checkMetadataFile(tr); err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = a.checkIRODSConfig(tr); err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = setForce(req, tr); err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if tr.Path, err = pathParam(req); err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
 	}
+
+	downloadRecord, replayed, err := a.downloadKeys.startOnce(req.Header.Get(idempotencyKeyHeader), a.downloadRecords, func() (*TransferRecord, error) {
+		return a.DownloadFiles(req.Context(), tr)
+	})
+	if err != nil {
+		log.Warn(err)
+	}
+
+	if replayed {
+		writeReplayedResponse(writer, req, downloadRecord, blocking)
+		return
+	}
+
+	writeTransferResponse(writer, req, downloadRecord, err, blocking)
 }
 
-// GetDownloadStatus returns the status of the possibly running download.
-func (a *App) GetDownloadStatus(writer http.ResponseWriter, request *http.Request) {
+// recordsFor returns the records of transfers of the kind, or nil if the kind
+// isn't known.
+func (a *App) recordsFor(kind string) *HistoricalRecords {
+	switch kind {
+	case DownloadKind:
+		return a.downloadRecords
+	case UploadKind:
+		return a.uploadRecords
+	default:
+		return nil
+	}
+}
+
+// transferStatus responds with the record from the records whose UUID is in the
+// request's path, or a 404 if there isn't one. Requests whose If-None-Match
+// header has the record's current ETag get a 304. The record includes its events
+// if the request's events query parameter is true.
+func (a *App) transferStatus(writer http.ResponseWriter, request *http.Request, records *HistoricalRecords) {
 	id := mux.Vars(request)["id"]
 
-	foundRecord := a.downloadRecords.FindRecord(id)
+	foundRecord := records.FindRecord(id)
 	if foundRecord == nil {
 		writer.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	if err := foundRecord.MarshalAndWrite(writer); err != nil {
-		log.Error(err)
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	events, err := wantsEvents(request)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	// The log tail is updated first so that the ETag reflects it.
+	a.setLogTail(foundRecord)
+	if a.notModified(writer, request, foundRecord, statusRepresentation(request, events)) {
+		return
+	}
+
+	if events {
+		render(writer, request, http.StatusOK, recordWithEvents{foundRecord})
+		return
 	}
+	render(writer, request, http.StatusOK, foundRecord)
+}
+
+// GetDownloadStatus returns the status of the possibly running download.
+func (a *App) GetDownloadStatus(writer http.ResponseWriter, request *http.Request) {
+	a.transferStatus(writer, request, a.downloadRecords)
 }
 
 // GetUploadStatus returns the status of the possibly running upload.
 func (a *App) GetUploadStatus(writer http.ResponseWriter, request *http.Request) {
+	a.transferStatus(writer, request, a.uploadRecords)
+}
+
+// GetTransferStatus returns the status of a transfer of the kind in the path,
+// which is either download or upload. Unknown kinds get a 404.
+func (a *App) GetTransferStatus(writer http.ResponseWriter, request *http.Request) {
+	records := a.recordsFor(mux.Vars(request)["kind"])
+	if records == nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	a.transferStatus(writer, request, records)
+}
+
+// currentTransfers responds with the records of the transfers of the kind that
+// are running, or a 204 if none are. When transfers of the kind may run at the
+// same time the response is an array of records, otherwise it's the single
+// running record.
+func (a *App) currentTransfers(writer http.ResponseWriter, request *http.Request, records *HistoricalRecords, kind, status string) {
+	running := records.FindByStatus(status)
+	if len(running) == 0 {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	for _, r := range running {
+		a.setLogTail(r)
+	}
+
+	if a.queue(kind).maxWorkers == 1 {
+		render(writer, request, http.StatusOK, running[0])
+		return
+	}
+
+	render(writer, request, http.StatusOK, transferRecords(running))
+}
+
+// GetCurrentDownload returns the record of the running download, or of every
+// running download when more than one may run at once.
+func (a *App) GetCurrentDownload(writer http.ResponseWriter, request *http.Request) {
+	a.currentTransfers(writer, request, a.downloadRecords, DownloadKind, DownloadingStatus)
+}
+
+// GetCurrentUpload returns the record of the running upload, or of every running
+// upload when more than one may run at once.
+func (a *App) GetCurrentUpload(writer http.ResponseWriter, request *http.Request) {
+	a.currentTransfers(writer, request, a.uploadRecords, UploadKind, UploadingStatus)
+}
+
+// deleteRecord removes a record in a terminal state from the records, along
+// with its logs and the batch it belongs to. Records for transfers that haven't
+// finished can't be removed.
+func (a *App) deleteRecord(writer http.ResponseWriter, request *http.Request, records *HistoricalRecords) {
 	id := mux.Vars(request)["id"]
 
-	foundRecord := a.uploadRecords.FindRecord(id)
+	foundRecord := records.FindRecord(id)
 	if foundRecord == nil {
 		writer.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	if err := foundRecord.MarshalAndWrite(writer); err != nil {
-		log.Error(err)
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	if status := foundRecord.CurrentStatus(); !isTerminalStatus(status) {
+		http.Error(writer, fmt.Sprintf("transfer %s is still %s", id, status), http.StatusConflict)
+		return
 	}
+
+	if !records.Remove(id) {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	a.removeLogs(records, foundRecord)
+	a.batches.Remove(foundRecord)
+
+	writer.WriteHeader(http.StatusNoContent)
 }
 
-func (a *App) uploadCommand() []string {
-	retval := []string{
-		"porklock",
-		"-jar",
-		"/usr/src/app/porklock-standalone.jar",
-		"put",
+// DeleteDownloadRecord removes a finished download from the download records.
+func (a *App) DeleteDownloadRecord(writer http.ResponseWriter, request *http.Request) {
+	a.deleteRecord(writer, request, a.downloadRecords)
+}
+
+// DeleteUploadRecord removes a finished upload from the upload records.
+func (a *App) DeleteUploadRecord(writer http.ResponseWriter, request *http.Request) {
+	a.deleteRecord(writer, request, a.uploadRecords)
+}
+
+// uploadCommand returns the command line for uploading the source directory
+// using the iRODS config file at configPath, leaving out the files matched by
+// the excludes file and applying the metadata in the metadataFile if it isn't
+// empty.
+func (a *App) uploadCommand(source, excludesPath, configPath, metadataFile string) []string {
+	return a.putCommand(source, a.UploadDestination, excludesPath, configPath, metadataFile)
+}
+
+// putCommand returns the porklock command that uploads source to destination.
+// The excludes are left out if excludesPath is empty.
+func (a *App) putCommand(source, destination, excludesPath, configPath, metadataFile string) []string {
+	retval := append(
+		a.porklockCommand("put"),
 		"--user", a.User,
-		"--source", a.DownloadDestination,
-		"--destination", a.UploadDestination,
-		"--exclude", a.ExcludesPath,
-		"-c", a.ConfigPath,
-	}
-	for _, fm := range a.FileMetadata {
-		retval = append(retval, "-m", fm)
+		"--source", source,
+		"--destination", destination,
+	)
+	if excludesPath != "" {
+		retval = append(retval, "--exclude", excludesPath)
 	}
-	return retval
+	retval = append(retval, "-c", configPath)
+	retval = append(retval, a.metadataArgs(metadataFile)...)
+	retval = append(retval, a.PorklockExtraArgs...)
+	return a.wrapCommand(retval)
 }
 
-// UploadFiles handles requests to upload files.
-func (a *App) UploadFiles(writer http.ResponseWriter, req *http.Request) {
-	log.Info("received upload request")
+// UploadFiles queues an upload and returns a *TransferRecord. The returned
+// error is non-nil if the upload wasn't queued, and the record is marked as
+// cancelled if that's because another upload is queued or running. Excludes in
+// the request are used instead of the configured excludes file. Uploads
+// requested within the debounce window after the previous one finishes are
+// coalesced into one, using the first request's settings. Forced uploads
+// aren't coalesced or refused. No record is created if the upload destination
+// isn't allowed or the upload queue is full.
+func (a *App) UploadFiles(ctx context.Context, tr *TransferRequest) (*TransferRecord, error) {
+	if err := a.checkAllowedPaths(a.UploadDestination); err != nil {
+		return nil, err
+	}
 
-	uploadRecord := NewUploadRecord()
-	a.uploadRecords.Append(uploadRecord)
+	if err := a.checkQueueLength(UploadKind, 1); err != nil {
+		return nil, err
+	}
 
-	uploadRunningMutex.Lock()
-	shouldRun := !uploadRunning
-	uploadRunning = true
-	uploadRunningMutex.Unlock()
+	newRecord := func() *TransferRecord {
+		r := NewUploadRecord()
+		r.Priority = tr.Priority
+		r.params = transferParams{
+			excludes:     tr.Excludes,
+			configPath:   a.configPath(tr),
+			metadataFile: a.metadataFile(tr),
+			timeout:      time.Duration(tr.Timeout),
+		}
+		r.params.ctx, r.params.cancel = a.newTransferContext(ctx)
+		a.appendRecord(a.uploadRecords, r)
+		return r
+	}
 
-	if shouldRun {
-		log.Info("starting upload goroutine")
+	if tr.Force {
+		uploadRecord := newRecord()
+		log.Warnf("upload %s was forced, queueing it even if other uploads are running", uploadRecord.UUID)
+		a.queue(UploadKind).Enqueue(uploadRecord)
+		return uploadRecord, nil
+	}
 
-		a.uploadWait.Add(1)
+	if a.UploadDebounce > 0 {
+		if r := a.uploadDebounce.coalesce(a.UploadDebounce, newRecord, a.queue(UploadKind).Enqueue); r != nil {
+			log.Infof("upload %s will be queued once the debounce window has passed", r.UUID)
+			return r, nil
+		}
+	}
 
-		go func() {
-			log.Info("running upload goroutine")
+	uploadRecord := newRecord()
+	if !a.queue(UploadKind).EnqueueIfIdle(uploadRecord) {
+		a.refuseUnstarted(uploadRecord)
+		return uploadRecord, errTransferRunning
+	}
 
-			uploadRecord.SetStatus(UploadingStatus)
+	log.Info("queued upload")
 
-			defer func() {
-				uploadRecord.SetCompletionTime()
+	return uploadRecord, nil
+}
 
-				uploadRunningMutex.Lock()
-				uploadRunning = false
-				uploadRunningMutex.Unlock()
+// runUpload runs the porklock upload described by the record's parameters. It's
+// called by the upload queue.
+func (a *App) runUpload(uploadRecord *TransferRecord) {
+	log.Infof("running upload %s", uploadRecord.UUID)
 
-				a.uploadWait.Done()
-			}()
+	uploadRecord.SetRunning(UploadingStatus)
+	a.audit(AuditStarted, uploadRecord)
+	defer a.auditFinished(uploadRecord)
+	defer uploadRecord.SetCompletionTime()
+	defer a.uploadDebounce.finished()
+	defer uploadRecord.Cancel()
+	defer removeTempFiles(uploadRecord.params.tempFiles)
+	defer recoverTransfer(uploadRecord)
 
-			uploadLogStdoutPath := path.Join(a.LogDirectory, "uploads.stdout.log")
-			uploadLogStdoutFile, err := os.Create(uploadLogStdoutPath)
-			if err != nil {
-				log.Error(errors.Wrapf(err, "failed to open file %s", uploadLogStdoutPath))
-				uploadRecord.SetStatus(FailedStatus)
-				return
-			}
+	ctx, cancel := a.runContext(uploadRecord)
+	defer cancel()
 
-			uploadLogStderrPath := path.Join(a.LogDirectory, "uploads.stderr.log")
-			uploadLogStderrFile, err := os.Create(uploadLogStderrPath)
-			if err != nil {
-				log.Error(errors.Wrapf(err, "failed to open file %s", uploadLogStderrPath))
-				uploadRecord.SetStatus(FailedStatus)
-				return
-			}
+	ctx, span := a.startTransferSpan(ctx, uploadRecord)
+	var err error
+	defer func() { endTransferSpan(span, uploadRecord, err) }()
+
+	logs, err := a.openTransferLogs(uploadRecord, "uploads")
+	if err != nil {
+		log.Error(err)
+		uploadRecord.SetFailed(err)
+		return
+	}
+	defer logs.Close()
 
-			parts := a.uploadCommand()
-			cmd := exec.Command(parts[0], parts[1:]...)
-			cmd.Stdout = uploadLogStdoutFile
-			cmd.Stderr = uploadLogStderrFile
+	if err = metadataFileProblem(uploadRecord); err != nil {
+		log.Error(err)
+		uploadRecord.SetFailed(err)
+		return
+	}
 
-			if err = cmd.Run(); err != nil {
-				log.Error(errors.Wrap(err, "error running porklock for uploads"))
-				uploadRecord.SetStatus(FailedStatus)
-				return
-			}
+	source, err := a.userDirectory(a.DownloadDestination)
+	if err != nil {
+		log.Error(err)
+		uploadRecord.SetFailed(err)
+		return
+	}
 
-			uploadRecord.SetStatus(CompletedStatus)
+	excludesPath := a.ExcludesPath
+	if uploadRecord.params.excludes != nil {
+		if excludesPath, err = writeExcludesFile(uploadRecord.params.excludes); err != nil {
+			log.Error(err)
+			uploadRecord.SetFailed(err)
+			return
+		}
+		defer os.Remove(excludesPath)
+	}
 
-			log.Info("exiting upload goroutine without errors")
-		}()
+	// The files to move are listed before porklock runs so that files added
+	// to the source during the upload, which might not be uploaded, are kept.
+	// Of those, only the ones that porklock reports uploading are removed.
+	var report *uploadReport
+	porklockLogs := logs
+	if a.UploadMove {
+		if report, err = a.newUploadReport(source); err != nil {
+			log.Error(err)
+			uploadRecord.SetFailed(err)
+			return
+		}
+		porklockLogs = logs.reportingTo(report)
 	}
 
-	if err := uploadRecord.MarshalAndWrite(writer); err != nil {
+	parts := a.uploadCommand(source, excludesPath, uploadRecord.params.configPath, uploadRecord.params.metadataFile)
+	if err = a.runPorklock(ctx, uploadRecord, parts, porklockLogs); err != nil {
+		err = errors.Wrap(err, "error running porklock for uploads")
 		log.Error(err)
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		uploadRecord.SetFailed(err)
+		return
+	}
+
+	uploadRecord.SetStatus(CompletedStatus)
+
+	if a.UploadMove {
+		moving := report.Reported()
+		if err = removeUploadedFiles(moving); err != nil {
+			log.Error(errors.Wrapf(err, "upload %s finished but the local files weren't all removed", uploadRecord.UUID))
+		} else if len(moving) > 0 {
+			uploadRecord.SetMovedFiles()
+			log.Infof("removed the %d files uploaded by %s", len(moving), uploadRecord.UUID)
+		}
+	}
+
+	log.Infof("upload %s finished without errors", uploadRecord.UUID)
+}
+
+// UploadFilesHandler handles requests to upload files.
+func (a *App) UploadFilesHandler(writer http.ResponseWriter, req *http.Request) {
+	log.Info("received upload request")
+
+	blocking, err := wantsBlocking(req)
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	tr, err := decodeTransferRequest(req)
+	if err != nil {
+		writeJSONError(writer, decodeErrorStatus(err), err)
+		return
+	}
+
+	if err = a.checkTimeout(tr); err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = checkMetadataFile(tr); err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = a.checkIRODSConfig(tr); err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = setForce(req, tr); err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	uploadRecord, replayed, err := a.uploadKeys.startOnce(req.Header.Get(idempotencyKeyHeader), a.uploadRecords, func() (*TransferRecord, error) {
+		return a.UploadFiles(req.Context(), tr)
+	})
+	if err != nil {
+		log.Warn(err)
+	}
+
+	if replayed {
+		writeReplayedResponse(writer, req, uploadRecord, blocking)
+		return
+	}
+
+	writeTransferResponse(writer, req, uploadRecord, err, blocking)
+}
+
+// configureLogging sets the level and output format of the logrus logger. The
+// level must be one of debug, info, warn, or error and the format must be either
+// text or json.
+func configureLogging(level, format string) error {
+	lvl, ok := logLevels[level]
+	if !ok {
+		return fmt.Errorf("invalid log level %q", level)
 	}
+
+	switch format {
+	case "text":
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+
+	logrus.SetLevel(lvl)
+	return nil
 }
 
 // Hello is an HTTP handler that simply says hello.
@@ -407,65 +1422,240 @@ func (a *App) Hello(writer http.ResponseWriter, request *http.Request) {
 	fmt.Fprintln(writer, "Hello from vice-file-transfers")
 }
 
+// newRouter returns a router with all of the service's endpoints registered.
+func (a *App) newRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(otelhttp.NewMiddleware("vice-file-transfers"))
+	router.Use(gzipMiddleware(a.GzipMinSize))
+	// Panics are recovered inside of the gzip middleware so that the error
+	// response is written before it finishes the response.
+	router.Use(recoverPanics)
+	router.HandleFunc("/", a.Hello).Methods(http.MethodGet)
+	router.HandleFunc("/status", a.GetStatusSummary).Methods(http.MethodGet)
+	router.HandleFunc("/config", a.GetConfig).Methods(http.MethodGet)
+	router.Handle("/metrics", metricsHandler()).Methods(http.MethodGet)
+	router.HandleFunc("/livez", a.Livez).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", a.Readyz).Methods(http.MethodGet)
+	router.HandleFunc("/healthz", a.Readyz).Methods(http.MethodGet)
+	router.HandleFunc("/drain", a.Drain).Methods(http.MethodPost)
+	router.HandleFunc("/drain", a.GetDrainStatus).Methods(http.MethodGet)
+	router.HandleFunc("/queue", a.GetQueueStatus).Methods(http.MethodGet)
+	router.HandleFunc("/queue/pause", a.PauseQueue).Methods(http.MethodPost)
+	router.HandleFunc("/queue/resume", a.ResumeQueue).Methods(http.MethodPost)
+	router.HandleFunc("/logs", a.ClearLogs).Methods(http.MethodDelete)
+
+	downloadFiles := a.rejectWhenDraining(rateLimit(newLimiter(a.RateLimit), limitBody(a.MaxBodyBytes, a.DownloadFilesHandler)))
+	router.HandleFunc("/download", downloadFiles).Queries(nonBlockingKey, "").Methods(http.MethodPost)
+	router.HandleFunc("/download", downloadFiles).Methods(http.MethodPost)
+	router.HandleFunc("/downloads", a.ListDownloads).Methods(http.MethodGet)
+	router.HandleFunc("/downloads/batch", a.rejectWhenDraining(rateLimit(newLimiter(a.RateLimit), limitBody(a.MaxBodyBytes, a.BatchDownloadHandler)))).Methods(http.MethodPost)
+	router.HandleFunc("/downloads/batch/{batchID}", a.GetBatchStatus).Methods(http.MethodGet)
+	router.HandleFunc("/download/cancel-all", a.CancelAllDownloads).Methods(http.MethodPost)
+	router.HandleFunc("/download/current", a.GetCurrentDownload).Methods(http.MethodGet)
+	router.HandleFunc("/download/last-error", a.GetLastDownloadError).Methods(http.MethodGet)
+	router.HandleFunc("/download/{id}", a.GetDownloadStatus).Methods(http.MethodGet)
+	router.HandleFunc("/download/{id}/stream", a.StreamDownloadLog).Methods(http.MethodGet)
+	router.HandleFunc("/download/{id}/ws", a.WatchDownload).Methods(http.MethodGet)
+	router.HandleFunc("/download/{id}/record", a.DeleteDownloadRecord).Methods(http.MethodDelete)
+
+	uploadFiles := a.rejectWhenDraining(rateLimit(newLimiter(a.RateLimit), limitBody(a.MaxBodyBytes, a.UploadFilesHandler)))
+	router.HandleFunc("/upload", uploadFiles).Queries(nonBlockingKey, "").Methods(http.MethodPost)
+	router.HandleFunc("/upload", uploadFiles).Methods(http.MethodPost)
+	router.HandleFunc("/uploads", a.ListUploads).Methods(http.MethodGet)
+	router.HandleFunc("/upload/preview", a.UploadPreview).Methods(http.MethodGet)
+	router.HandleFunc("/upload/cancel-all", a.CancelAllUploads).Methods(http.MethodPost)
+	router.HandleFunc("/upload/current", a.GetCurrentUpload).Methods(http.MethodGet)
+	router.HandleFunc("/upload/last-error", a.GetLastUploadError).Methods(http.MethodGet)
+	router.HandleFunc("/upload/{id}", a.GetUploadStatus).Methods(http.MethodGet)
+	router.HandleFunc("/upload/{id}/stream", a.StreamUploadLog).Methods(http.MethodGet)
+	router.HandleFunc("/upload/{id}/ws", a.WatchUpload).Methods(http.MethodGet)
+	router.HandleFunc("/upload/{id}/record", a.DeleteUploadRecord).Methods(http.MethodDelete)
+
+	router.HandleFunc("/transfers", a.ListTransfers).Methods(http.MethodGet)
+	router.HandleFunc("/transfers/status", limitBody(a.MaxBodyBytes, a.GetTransferStatuses)).Methods(http.MethodPost)
+	router.HandleFunc("/transfers/{id}/events", a.GetTransferEvents).Methods(http.MethodGet)
+	router.HandleFunc("/transfers/{kind}/{id}", a.GetTransferStatus).Methods(http.MethodGet)
+
+	for _, route := range []struct {
+		path    string
+		methods []string
+	}{
+		{"/", []string{http.MethodGet}},
+		{"/status", []string{http.MethodGet}},
+		{"/config", []string{http.MethodGet}},
+		{"/metrics", []string{http.MethodGet}},
+		{"/livez", []string{http.MethodGet}},
+		{"/readyz", []string{http.MethodGet}},
+		{"/healthz", []string{http.MethodGet}},
+		{"/drain", []string{http.MethodGet, http.MethodPost}},
+		{"/queue", []string{http.MethodGet}},
+		{"/queue/pause", []string{http.MethodPost}},
+		{"/queue/resume", []string{http.MethodPost}},
+		{"/logs", []string{http.MethodDelete}},
+		{"/download", []string{http.MethodPost}},
+		{"/downloads", []string{http.MethodGet}},
+		{"/downloads/batch", []string{http.MethodPost}},
+		{"/downloads/batch/{batchID}", []string{http.MethodGet}},
+		{"/download/cancel-all", []string{http.MethodPost}},
+		{"/download/current", []string{http.MethodGet}},
+		{"/download/last-error", []string{http.MethodGet}},
+		{"/download/{id}", []string{http.MethodGet}},
+		{"/download/{id}/stream", []string{http.MethodGet}},
+		{"/download/{id}/ws", []string{http.MethodGet}},
+		{"/download/{id}/record", []string{http.MethodDelete}},
+		{"/upload", []string{http.MethodPost}},
+		{"/uploads", []string{http.MethodGet}},
+		{"/upload/preview", []string{http.MethodGet}},
+		{"/upload/cancel-all", []string{http.MethodPost}},
+		{"/upload/current", []string{http.MethodGet}},
+		{"/upload/last-error", []string{http.MethodGet}},
+		{"/upload/{id}", []string{http.MethodGet}},
+		{"/upload/{id}/stream", []string{http.MethodGet}},
+		{"/upload/{id}/ws", []string{http.MethodGet}},
+		{"/upload/{id}/record", []string{http.MethodDelete}},
+		{"/transfers", []string{http.MethodGet}},
+		{"/transfers/status", []string{http.MethodPost}},
+		{"/transfers/{id}/events", []string{http.MethodGet}},
+		{"/transfers/{kind}/{id}", []string{http.MethodGet}},
+	} {
+		router.HandleFunc(route.path, a.preflight(route.methods...)).Methods(http.MethodOptions)
+	}
+
+	return router
+}
+
 func main() {
-	var options struct {
-		ListenPort          int      `short:"l" long:"listen-port" default:"60001" description:"The port to listen on for requests"`
-		LogDirectory        string   `long:"log-dir" default:"/input-files" description:"The directory in which to write log files"`
-		User                string   `long:"user" required:"true" description:"The user to run the transfers for"`
-		UploadDestination   string   `long:"upload-destination" required:"true" description:"The destination directory for uploads"`
-		DownloadDestination string   `long:"download-destination" default:"/input-files" description:"The destination directory for downloads"`
-		ExcludesFile        string   `long:"excludes-file" default:"/excludes/excludes-file" description:"The path to the excludes file"`
-		PathListFile        string   `long:"path-list-file" default:"/input-paths/input-path-list" description:"The path to the input paths list file"`
-		IRODSConfig         string   `long:"irods-config" default:"/etc/porklock/irods-config.properties" description:"The path to the porklock iRODS config file"`
-		InvocationID        string   `long:"invocation-id" required:"true" description:"The invocation UUID"`
-		FileMetadata        []string `short:"m" description:"Metadata to apply to files"`
-		NoService           bool     `short:"n" long:"no-service" description:"Disables running as a continuous process. Effectively becomes a download tool"`
-	}
-
-	if _, err := flags.Parse(&options); err != nil {
+	options, err := parseOptions(os.Args[1:])
+	if err != nil {
 		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
 			os.Exit(0)
 		}
 		log.Fatal(err)
 	}
 
-	_, err := exec.LookPath("porklock")
+	if err := configureLogging(options.LogLevel, options.LogFormat); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateEnv(options.PorklockEnv); err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = exec.LookPath(options.PorklockPath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	app := &App{
-		LogDirectory:        options.LogDirectory,
-		InvocationID:        options.InvocationID,
-		ConfigPath:          options.IRODSConfig,
-		User:                options.User,
-		UploadDestination:   options.UploadDestination,
-		DownloadDestination: options.DownloadDestination,
-		ExcludesPath:        options.ExcludesFile,
-		InputPathList:       options.PathListFile,
-		FileMetadata:        options.FileMetadata,
-		downloadWait:        sync.WaitGroup{},
-		uploadWait:          sync.WaitGroup{},
-		uploadRecords:       &HistoricalRecords{},
-		downloadRecords:     &HistoricalRecords{},
+	if options.TransferWrapper != "" {
+		if _, err = exec.LookPath(options.TransferWrapper); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	router := mux.NewRouter()
-	router.HandleFunc("/", app.Hello).Methods(http.MethodGet)
-	router.HandleFunc("/download", app.DownloadFilesHandler).Queries(nonBlockingKey, "").Methods(http.MethodPost)
-	router.HandleFunc("/download", app.DownloadFilesHandler).Methods(http.MethodPost)
-	router.HandleFunc("/download/{id}", app.GetDownloadStatus).Methods(http.MethodGet)
+	if options.VerifyChecksums {
+		if _, err = exec.LookPath(strings.Fields(options.ChecksumCommand)[0]); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The self-test runs before the log directory is locked so that it can
+	// be used alongside a running service.
+	if options.SelfTest {
+		if err := newApp(options).selfTest(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
 
-	router.HandleFunc("/upload", app.UploadFiles).Queries(nonBlockingKey, "").Methods(http.MethodPost)
-	router.HandleFunc("/upload", app.UploadFiles).Methods(http.MethodPost)
-	router.HandleFunc("/upload/{id}", app.GetUploadStatus).Methods(http.MethodGet)
+	if err := run(options); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	if !options.NoService {
-		log.Warn("Starting web server")
-		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", options.ListenPort), router))
-	} else {
+// run locks the log directory and runs the service until it receives a signal
+// to stop, or until the web server fails, returning the error. With no service,
+// it runs a single download instead. The lock is released and the audit log and
+// tracing are shut down before it returns.
+func run(options *Options) error {
+	lock, err := acquireDirLock(options.LogDirectory)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	shutdownTracing, err := setupTracing(context.Background(), options.OTelEndpoint)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error(errors.Wrap(err, "error shutting down tracing"))
+		}
+	}()
+
+	app := newApp(options)
+
+	if app.AuditLog != "" {
+		auditLog, err := openAuditLog(app.AuditLog, app.LogFileMode)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+		app.auditor = auditSinks{app.auditor, auditLog}
+	}
+
+	router := app.newRouter()
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			app.reopenLogs()
+		}
+	}()
+
+	if options.NoService {
 		log.Warn("Waiting for downloads to complete")
-		app.DownloadFiles()
-		app.downloadWait.Wait()
+		if _, err = app.DownloadFiles(context.Background(), &TransferRequest{}); err != nil {
+			log.Warn(err)
+		}
+		app.queue(DownloadKind).Wait()
+		return nil
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", options.ListenPort),
+		Handler: router,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	serverErrs := make(chan error, 1)
+	go func() {
+		log.Warn("Starting web server")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErrs <- errors.Wrap(err, "the web server failed")
+		}
+	}()
+
+	var serverErr error
+	select {
+	case sig := <-signals:
+		log.Warnf("received %s, shutting down", sig)
+	case serverErr = <-serverErrs:
+		log.Warn("the web server stopped, shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.ShutdownTimeout)
+	defer cancel()
+
+	if err := app.shutdown(ctx, server); err != nil {
+		log.Error(err)
 	}
+	return serverErr
 }
@@ -1,7 +1,1434 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
 
 func TestNothing(t *testing.T) {
 
 }
+
+func TestConfigureLogging(t *testing.T) {
+	var buf bytes.Buffer
+
+	origLevel := logrus.GetLevel()
+	logrus.SetOutput(&buf)
+	defer func() {
+		logrus.SetOutput(os.Stderr)
+		logrus.SetLevel(origLevel)
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	}()
+
+	if err := configureLogging("debug", "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	log.Debug("debug message")
+
+	if !strings.Contains(buf.String(), "debug message") {
+		t.Errorf("debug line was not emitted: %q", buf.String())
+	}
+
+	if !strings.Contains(buf.String(), `"level":"debug"`) {
+		t.Errorf("log line was not JSON formatted: %q", buf.String())
+	}
+}
+
+func TestConfigureLoggingInvalid(t *testing.T) {
+	if err := configureLogging("verbose", "text"); err == nil {
+		t.Error("expected an error for an invalid log level")
+	}
+
+	if err := configureLogging("info", "xml"); err == nil {
+		t.Error("expected an error for an invalid log format")
+	}
+}
+
+func TestWrapCommand(t *testing.T) {
+	app := &App{UnbufferCommand: "stdbuf -oL -eL"}
+	parts := []string{"porklock", "get"}
+
+	if wrapped := app.wrapCommand(parts); strings.Join(wrapped, " ") != "porklock get" {
+		t.Errorf("wrapped command was %v", wrapped)
+	}
+
+	app.LineBuffered = true
+	if wrapped := app.wrapCommand(parts); strings.Join(wrapped, " ") != "stdbuf -oL -eL porklock get" {
+		t.Errorf("wrapped command was %v", wrapped)
+	}
+}
+
+func TestTransferWrapper(t *testing.T) {
+	app := &App{
+		PorklockPath:    "porklock",
+		PorklockJar:     "porklock.jar",
+		TransferWrapper: "/opt/wrapper",
+		UnbufferCommand: "stdbuf -oL -eL",
+	}
+
+	for name, parts := range map[string][]string{
+		"download": app.downloadCommand("input-path-list", "input-files", app.ConfigPath, ""),
+		"upload":   app.uploadCommand(app.DownloadDestination, "excludes", app.ConfigPath, ""),
+	} {
+		if len(parts) < 5 || parts[0] != "/opt/wrapper" || parts[1] != "porklock" || parts[2] != "-jar" {
+			t.Errorf("%s command wasn't prefixed with the wrapper: %v", name, parts)
+		}
+	}
+
+	app.LineBuffered = true
+	if wrapped := app.wrapCommand([]string{"porklock", "get"}); strings.Join(wrapped, " ") != "stdbuf -oL -eL /opt/wrapper porklock get" {
+		t.Errorf("line buffered wrapped command was %v", wrapped)
+	}
+}
+
+func TestTransferWrapperRuns(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	wrapper := filepath.Join(app.LogDirectory, "wrapper")
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(app.LogDirectory, "wrapped") + "\nexec \"$@\"\n"
+	if err := ioutil.WriteFile(wrapper, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	app.TransferWrapper = wrapper
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, "")
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Fatalf("download returned %d %v", rec.Code, body)
+	}
+
+	wrapped, err := ioutil.ReadFile(filepath.Join(app.LogDirectory, "wrapped"))
+	if err != nil {
+		t.Fatalf("the wrapper wasn't run: %s", err)
+	}
+	if !strings.HasPrefix(string(wrapped), app.PorklockPath+" -jar fake-porklock.jar get ") {
+		t.Errorf("the wrapper was run with %q", wrapped)
+	}
+
+	app.TransferWrapper = filepath.Join(app.LogDirectory, "nonexistent-wrapper")
+	if err = app.checkPorklock(); err == nil {
+		t.Error("a missing wrapper passed the porklock check")
+	}
+}
+
+func TestLineBufferedOutput(t *testing.T) {
+	if _, err := exec.LookPath("stdbuf"); err != nil {
+		t.Skip("stdbuf is not available")
+	}
+
+	dir, err := ioutil.TempDir("", "linebuffered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// grep block buffers its output when it isn't writing to a terminal, so the
+	// first line only shows up promptly if the output is line buffered.
+	script := filepath.Join(dir, "fake-porklock")
+	contents := "#!/bin/sh\n(echo 'progress 1'; sleep 5; echo 'progress 2') | grep progress\n"
+	if err = ioutil.WriteFile(script, []byte(contents), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, err := os.Create(filepath.Join(dir, "stdout.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	app := &App{LineBuffered: true, UnbufferCommand: "stdbuf -oL -eL"}
+	parts := app.wrapCommand([]string{script})
+	cmd := exec.Command(parts[0], parts[1:]...)
+	cmd.Stdout = stdout
+
+	// The command runs in its own process group so that the shell's children
+	// are killed along with it when the test ends.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		contents, err := ioutil.ReadFile(stdout.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(contents), "progress 1") {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Error("progress line did not arrive while the command was running")
+}
+
+func TestMarshalDuration(t *testing.T) {
+	record := NewDownloadRecord()
+	record.SetStatus(DownloadingStatus)
+
+	var buf bytes.Buffer
+	if err := record.MarshalAndWrite(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := body["duration_seconds"]; ok {
+		t.Errorf("running record had a duration: %v", body["duration_seconds"])
+	}
+
+	record.StartTime = time.Now().Add(-90 * time.Second)
+	record.SetCompletionTime()
+	record.SetStatus(CompletedStatus)
+
+	buf.Reset()
+	if err := record.MarshalAndWrite(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	body = map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	duration, ok := body["duration_seconds"].(float64)
+	if !ok {
+		t.Fatalf("completed record had no duration: %v", body)
+	}
+
+	if duration < 90 || duration > 91 {
+		t.Errorf("duration was %f, not about 90", duration)
+	}
+
+	if body["status"] != CompletedStatus {
+		t.Errorf("status was %v", body["status"])
+	}
+}
+
+func TestMarshalTimes(t *testing.T) {
+	record := NewDownloadRecord()
+	record.StartTime = time.Date(2019, 5, 1, 8, 0, 0, 0, time.FixedZone("MST", -7*60*60))
+	record.SetStatus(DownloadingStatus)
+
+	marshal := func() map[string]interface{} {
+		var buf bytes.Buffer
+		if err := record.MarshalAndWrite(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(buf.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	body := marshal()
+	if body["start_time"] != "2019-05-01T15:00:00Z" {
+		t.Errorf("start time was %v", body["start_time"])
+	}
+	if completion, ok := body["completion_time"]; !ok || completion != nil {
+		t.Errorf("in-flight record had a completion time of %v", completion)
+	}
+
+	record.SetCompletionTime()
+	body = marshal()
+
+	completion, ok := body["completion_time"].(string)
+	if !ok {
+		t.Fatalf("completed record had a completion time of %v", body["completion_time"])
+	}
+	parsed, err := time.Parse(time.RFC3339, completion)
+	if err != nil {
+		t.Errorf("completion time %q isn't RFC 3339: %s", completion, err)
+	}
+	if !parsed.Equal(record.CompletionTime) {
+		t.Errorf("completion time was %s, not %s", parsed, record.CompletionTime)
+	}
+}
+
+func TestNewCommandEnv(t *testing.T) {
+	if _, err := exec.LookPath("env"); err != nil {
+		t.Skip("env is not available")
+	}
+
+	app := &App{PorklockEnv: []string{"JAVA_OPTS=-Xmx1g"}}
+
+	output, err := app.newCommand(context.Background(), []string{"env"}).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := strings.Split(string(output), "\n")
+
+	var found, inherited bool
+	for _, entry := range env {
+		if entry == "JAVA_OPTS=-Xmx1g" {
+			found = true
+		}
+		if strings.HasPrefix(entry, "PATH=") {
+			inherited = true
+		}
+	}
+
+	if !found {
+		t.Error("JAVA_OPTS was not set in the command's environment")
+	}
+
+	if !inherited {
+		t.Error("PATH was not inherited by the command's environment")
+	}
+}
+
+func TestValidateEnv(t *testing.T) {
+	if err := validateEnv([]string{"A=1", "B="}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	for _, entry := range []string{"A", "=1", ""} {
+		if err := validateEnv([]string{entry}); err == nil {
+			t.Errorf("expected an error for %q", entry)
+		}
+	}
+}
+
+func TestCommandBuildersHonorPorklockPaths(t *testing.T) {
+	app := &App{
+		PorklockPath: "/opt/bin/fake-porklock",
+		PorklockJar:  "/opt/lib/fake-porklock.jar",
+	}
+
+	for name, parts := range map[string][]string{
+		"download": app.downloadCommand("input-path-list", "input-files", app.ConfigPath, ""),
+		"upload":   app.uploadCommand(app.DownloadDestination, "", app.ConfigPath, ""),
+	} {
+		if parts[0] != "/opt/bin/fake-porklock" {
+			t.Errorf("%s command ran %q", name, parts[0])
+		}
+		if parts[1] != "-jar" || parts[2] != "/opt/lib/fake-porklock.jar" {
+			t.Errorf("%s command used the jar %v", name, parts[1:3])
+		}
+	}
+
+	if parts := app.downloadCommand("input-path-list", "input-files", app.ConfigPath, ""); parts[3] != "get" {
+		t.Errorf("download subcommand was %q", parts[3])
+	}
+
+	if parts := app.uploadCommand(app.DownloadDestination, "", app.ConfigPath, ""); parts[3] != "put" {
+		t.Errorf("upload subcommand was %q", parts[3])
+	}
+}
+
+func deleteDownloadRecord(app *App, id string) int {
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/download/"+id+"/record", nil), map[string]string{"id": id})
+	rec := httptest.NewRecorder()
+	app.DeleteDownloadRecord(rec, req)
+	return rec.Code
+}
+
+func TestDeleteCompletedRecord(t *testing.T) {
+	app := &App{downloadRecords: &HistoricalRecords{}}
+
+	record := NewDownloadRecord()
+	record.SetStatus(CompletedStatus)
+	app.downloadRecords.Append(record)
+
+	id := record.UUID.String()
+	if code := deleteDownloadRecord(app, id); code != http.StatusNoContent {
+		t.Errorf("status was %d, not %d", code, http.StatusNoContent)
+	}
+
+	if app.downloadRecords.FindRecord(id) != nil {
+		t.Error("record was not removed")
+	}
+
+	if code := deleteDownloadRecord(app, id); code != http.StatusNotFound {
+		t.Errorf("status was %d, not %d", code, http.StatusNotFound)
+	}
+}
+
+func TestDeleteInFlightRecord(t *testing.T) {
+	app := &App{downloadRecords: &HistoricalRecords{}}
+
+	record := NewDownloadRecord()
+	record.SetStatus(DownloadingStatus)
+	app.downloadRecords.Append(record)
+
+	id := record.UUID.String()
+	if code := deleteDownloadRecord(app, id); code != http.StatusConflict {
+		t.Errorf("status was %d, not %d", code, http.StatusConflict)
+	}
+
+	if app.downloadRecords.FindRecord(id) == nil {
+		t.Error("in-flight record was removed")
+	}
+}
+
+// newTestApp returns an *App that runs the script as its porklock executable,
+// along with a function that removes the files it created.
+func newTestApp(t *testing.T, script string) (*App, func()) {
+	dir, err := ioutil.TempDir("", "transfers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	porklockPath := filepath.Join(dir, "fake-porklock")
+	if err = ioutil.WriteFile(porklockPath, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pathList := filepath.Join(dir, "input-path-list")
+	if err = ioutil.WriteFile(pathList, []byte("/iplant/home/test-user/file.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	destination := filepath.Join(dir, "input-files")
+	if err = os.Mkdir(destination, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{
+		LogDirectory:        dir,
+		PorklockPath:        porklockPath,
+		PorklockJar:         "fake-porklock.jar",
+		User:                "test-user",
+		UploadDestination:   "/iplant/home/test-user/outputs",
+		DownloadDestination: destination,
+		InputPathList:       pathList,
+		ExcludesPath:        filepath.Join(dir, "excludes"),
+		ConfigPath:          filepath.Join(dir, "irods-config"),
+		uploadRecords:       &HistoricalRecords{},
+		downloadRecords:     &HistoricalRecords{},
+	}
+
+	return app, func() {
+		app.queue(DownloadKind).Wait()
+		app.queue(UploadKind).Wait()
+		os.RemoveAll(dir)
+	}
+}
+
+func postTransfer(handler http.HandlerFunc, target string, header http.Header, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	decoded := map[string]interface{}{}
+	json.Unmarshal(rec.Body.Bytes(), &decoded)
+	return rec, decoded
+}
+
+func TestDownloadBlocking(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.2")
+	defer cleanup()
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code was %d", rec.Code)
+	}
+
+	if body["status"] != CompletedStatus {
+		t.Errorf("blocking download returned status %v, not %s", body["status"], CompletedStatus)
+	}
+}
+
+func TestUploadNonBlocking(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.2")
+	defer cleanup()
+
+	rec, body := postTransfer(app.UploadFilesHandler, "/upload?non-blocking", http.Header{"Prefer": {"respond-async"}}, "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status code was %d", rec.Code)
+	}
+
+	if body["status"] == CompletedStatus || body["status"] == FailedStatus {
+		t.Errorf("non-blocking upload returned status %v", body["status"])
+	}
+}
+
+func TestTransferContradictoryBlocking(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	for _, tc := range []struct {
+		target string
+		header http.Header
+	}{
+		{"/download?wait=true&non-blocking", nil},
+		{"/download?wait=true", http.Header{"Prefer": {"respond-async"}}},
+		{"/download?wait=maybe", nil},
+	} {
+		rec, body := postTransfer(app.DownloadFilesHandler, tc.target, tc.header, "")
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status code for %s %v was %d, not %d", tc.target, tc.header, rec.Code, http.StatusBadRequest)
+		}
+		if body["error"] == nil {
+			t.Errorf("no error message for %s %v", tc.target, tc.header)
+		}
+	}
+
+	if n := len(app.downloadRecords.records); n != 0 {
+		t.Errorf("%d records were created for rejected requests", n)
+	}
+}
+
+// excludesScript is a fake porklock that copies the file passed to --exclude
+// and records its path so that tests can inspect what it was given.
+const excludesScript = `while [ $# -gt 0 ]; do
+  if [ "$1" = "--exclude" ]; then
+    echo "$2" > "$(dirname "$0")/excludes-path"
+    cp "$2" "$(dirname "$0")/excludes-contents" 2>/dev/null
+  fi
+  shift
+done`
+
+func TestUploadExcludesOverride(t *testing.T) {
+	app, cleanup := newTestApp(t, excludesScript)
+	defer cleanup()
+
+	rec, body := postTransfer(app.UploadFilesHandler, "/upload?wait=true", nil, `{"excludes": ["*.tmp", "scratch/"]}`)
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Fatalf("upload returned %d %v", rec.Code, body)
+	}
+
+	excludesPath, err := ioutil.ReadFile(filepath.Join(app.LogDirectory, "excludes-path"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(string(excludesPath)) == app.ExcludesPath {
+		t.Error("the configured excludes file was used instead of the override")
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(app.LogDirectory, "excludes-contents"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(contents) != "*.tmp\nscratch/\n" {
+		t.Errorf("excludes file contained %q", string(contents))
+	}
+
+	if _, err = os.Stat(strings.TrimSpace(string(excludesPath))); !os.IsNotExist(err) {
+		t.Error("the temporary excludes file was not removed")
+	}
+}
+
+func TestUploadExcludesDefault(t *testing.T) {
+	app, cleanup := newTestApp(t, excludesScript)
+	defer cleanup()
+
+	rec, body := postTransfer(app.UploadFilesHandler, "/upload?wait=true", nil, "")
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Fatalf("upload returned %d %v", rec.Code, body)
+	}
+
+	excludesPath, err := ioutil.ReadFile(filepath.Join(app.LogDirectory, "excludes-path"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(string(excludesPath)) != app.ExcludesPath {
+		t.Errorf("excludes path was %q, not %q", string(excludesPath), app.ExcludesPath)
+	}
+}
+
+func TestUploadInvalidBody(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	rec, body := postTransfer(app.UploadFilesHandler, "/upload", nil, `{"excludes": `)
+	if rec.Code != http.StatusBadRequest || body["error"] == nil {
+		t.Errorf("upload returned %d %v", rec.Code, body)
+	}
+}
+
+func TestTransferAccepted(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.2")
+	defer cleanup()
+
+	for _, tc := range []struct {
+		handler http.HandlerFunc
+		kind    string
+	}{
+		{app.DownloadFilesHandler, DownloadKind},
+		{app.UploadFilesHandler, UploadKind},
+	} {
+		rec, body := postTransfer(tc.handler, "/"+tc.kind, nil, "")
+		if rec.Code != http.StatusAccepted {
+			t.Errorf("%s status code was %d, not %d", tc.kind, rec.Code, http.StatusAccepted)
+		}
+
+		expected := "/" + tc.kind + "/" + body["uuid"].(string)
+		if location := rec.Header().Get("Location"); location != expected {
+			t.Errorf("%s Location was %q, not %q", tc.kind, location, expected)
+		}
+
+		if body["kind"] != tc.kind {
+			t.Errorf("record kind was %v, not %s", body["kind"], tc.kind)
+		}
+	}
+}
+
+func TestTransferAlreadyRunning(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.5")
+	defer cleanup()
+
+	rec, _ := postTransfer(app.DownloadFilesHandler, "/download", nil, "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status code was %d, not %d", rec.Code, http.StatusAccepted)
+	}
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download", nil, "")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status code was %d, not %d", rec.Code, http.StatusConflict)
+	}
+
+	if rec.Header().Get("Location") != "" {
+		t.Errorf("Location was set for a transfer that wasn't started")
+	}
+
+	if body["uuid"] == nil {
+		t.Errorf("record was not included in the body: %v", body)
+	}
+}
+
+func TestRefusedTransferIsFinished(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.5")
+	defer cleanup()
+
+	for _, tc := range []struct {
+		kind    string
+		handler http.HandlerFunc
+		records *HistoricalRecords
+	}{
+		{"download", app.DownloadFilesHandler, app.downloadRecords},
+		{"upload", app.UploadFilesHandler, app.uploadRecords},
+	} {
+		if rec, body := postTransfer(tc.handler, "/"+tc.kind, nil, ""); rec.Code != http.StatusAccepted {
+			t.Fatalf("the first %s returned %d %v", tc.kind, rec.Code, body)
+		}
+
+		rec, body := postTransfer(tc.handler, "/"+tc.kind, nil, "")
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("the second %s returned %d %v", tc.kind, rec.Code, body)
+		}
+		if body["status"] != CancelledStatus || body["error_message"] != errTransferRunning.Error() {
+			t.Errorf("the refused %s was %v", tc.kind, body)
+		}
+
+		unfinished := 0
+		for _, r := range tc.records.all() {
+			if !isTerminalStatus(r.CurrentStatus()) {
+				unfinished++
+			}
+		}
+		if unfinished != 1 {
+			t.Errorf("there are %d unfinished %ss, not just the running one", unfinished, tc.kind)
+		}
+
+		refused := tc.records.FindRecord(body["uuid"].(string))
+		select {
+		case <-refused.Done():
+		default:
+			t.Errorf("the refused %s isn't done", tc.kind)
+		}
+	}
+}
+
+func TestPorklockExtraArgs(t *testing.T) {
+	app := &App{
+		PorklockPath:      "porklock",
+		PorklockJar:       "porklock.jar",
+		FileMetadata:      []string{"attr,value,unit"},
+		PorklockExtraArgs: []string{"--new-flag", "value"},
+	}
+
+	for name, parts := range map[string][]string{
+		"download": app.downloadCommand("input-path-list", "input-files", app.ConfigPath, ""),
+		"upload":   app.uploadCommand(app.DownloadDestination, "excludes", app.ConfigPath, ""),
+	} {
+		n := len(parts)
+		if n < 4 {
+			t.Fatalf("%s command was too short: %v", name, parts)
+		}
+
+		if parts[n-2] != "--new-flag" || parts[n-1] != "value" {
+			t.Errorf("%s command did not end with the extra args: %v", name, parts)
+		}
+
+		if parts[n-4] != "-m" || parts[n-3] != "attr,value,unit" {
+			t.Errorf("%s command did not have the metadata args before the extra args: %v", name, parts)
+		}
+	}
+}
+
+func TestMetadataFileArgs(t *testing.T) {
+	app := &App{
+		PorklockPath:      "porklock",
+		PorklockJar:       "porklock.jar",
+		FileMetadata:      []string{"attr,value,unit"},
+		PorklockExtraArgs: []string{"--new-flag", "value"},
+	}
+
+	for name, parts := range map[string][]string{
+		"download": app.downloadCommand("input-path-list", "input-files", app.ConfigPath, "/metadata/avus.csv"),
+		"upload":   app.uploadCommand(app.DownloadDestination, "excludes", app.ConfigPath, "/metadata/avus.csv"),
+	} {
+		joined := strings.Join(parts, " ")
+		if !strings.Contains(joined, "-m attr,value,unit --metadata-file /metadata/avus.csv --new-flag value") {
+			t.Errorf("%s command did not have the metadata file after the metadata args: %v", name, parts)
+		}
+	}
+
+	for name, parts := range map[string][]string{
+		"download": app.downloadCommand("input-path-list", "input-files", app.ConfigPath, ""),
+		"upload":   app.uploadCommand(app.DownloadDestination, "excludes", app.ConfigPath, ""),
+	} {
+		if strings.Contains(strings.Join(parts, " "), "--metadata-file") {
+			t.Errorf("%s command had a metadata file without one being configured: %v", name, parts)
+		}
+	}
+}
+
+func TestMetadataFileOverride(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	app.MetadataFile = filepath.Join(app.LogDirectory, "configured-avus")
+	override := filepath.Join(app.LogDirectory, "requested-avus")
+	for _, p := range []string{app.MetadataFile, override} {
+		if err := ioutil.WriteFile(p, []byte("attr,value,unit\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, "")
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Fatalf("download returned %d %v", rec.Code, body)
+	}
+	if command := fmt.Sprint(body["command"]); !strings.Contains(command, "--metadata-file "+app.MetadataFile) {
+		t.Errorf("the configured metadata file wasn't used: %s", command)
+	}
+
+	rec, body = postTransfer(app.UploadFilesHandler, "/upload?wait=true", nil, `{"metadata_file": "`+override+`"}`)
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Fatalf("upload returned %d %v", rec.Code, body)
+	}
+	if command := fmt.Sprint(body["command"]); !strings.Contains(command, "--metadata-file "+override) {
+		t.Errorf("the requested metadata file wasn't used: %s", command)
+	}
+}
+
+func TestMetadataFileInvalid(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	rec, body := postTransfer(app.UploadFilesHandler, "/upload?wait=true", nil, `{"metadata_file": "avus.csv"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("a relative metadata file returned %d %v", rec.Code, body)
+	}
+
+	missing := filepath.Join(app.LogDirectory, "missing-avus")
+	rec, body = postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, `{"metadata_file": "`+missing+`"}`)
+	if body["status"] != FailedStatus {
+		t.Fatalf("a download with a missing metadata file returned %d %v", rec.Code, body)
+	}
+	if msg, _ := body["error_message"].(string); !strings.Contains(msg, "metadata file "+missing+" does not exist") {
+		t.Errorf("the error didn't describe the missing metadata file: %v", body["error_message"])
+	}
+	if body["command"] != nil {
+		t.Errorf("porklock was run without the metadata file: %v", body["command"])
+	}
+}
+
+func TestForcedTransfers(t *testing.T) {
+	app, cleanup := newTestApp(t, "exec sleep 10")
+	defer cleanup()
+	defer app.cancelTransfers()
+
+	app.MaxConcurrentDownloads = 2
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download", nil, "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("the first download returned %d %v", rec.Code, body)
+	}
+	waitForRunning(t, app.downloadRecords, DownloadingStatus, 1)
+
+	rec, body = postTransfer(app.DownloadFilesHandler, "/download", nil, "")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("an unforced download while one was running returned %d %v", rec.Code, body)
+	}
+
+	rec, body = postTransfer(app.DownloadFilesHandler, "/download?force=true", nil, "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("a forced download returned %d %v", rec.Code, body)
+	}
+	waitForRunning(t, app.downloadRecords, DownloadingStatus, 2)
+
+	rec, body = postTransfer(app.UploadFilesHandler, "/upload", nil, "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("the first upload returned %d %v", rec.Code, body)
+	}
+
+	rec, body = postTransfer(app.UploadFilesHandler, "/upload", nil, `{"force": true}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("an upload forced in the body returned %d %v", rec.Code, body)
+	}
+
+	// Forced uploads are still limited to one running at a time.
+	waitForRunning(t, app.uploadRecords, UploadingStatus, 1)
+	if n := app.queue(UploadKind).Pending(); n != 1 {
+		t.Errorf("%d forced uploads were waiting for the running one", n)
+	}
+}
+
+func TestForceInvalid(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download?force=yes", nil, "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("an invalid force returned %d %v", rec.Code, body)
+	}
+}
+
+func TestQueuedAndRunningDurations(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.2")
+	defer cleanup()
+
+	first, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForRunning(t, app.downloadRecords, DownloadingStatus, 1)
+
+	second, err := app.DownloadFiles(context.Background(), &TransferRequest{Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForStatus(t, first)
+	waitForStatus(t, second)
+
+	recordJSON, err := second.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed map[string]interface{}
+	if err = json.Unmarshal(recordJSON, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, _ := parsed["queued_duration_seconds"].(float64)
+	running, _ := parsed["running_duration_seconds"].(float64)
+	if queued < 0.1 {
+		t.Errorf("the download queued behind another had a queued duration of %v", parsed["queued_duration_seconds"])
+	}
+	if running < 0.1 {
+		t.Errorf("the download had a running duration of %v", parsed["running_duration_seconds"])
+	}
+	if total, _ := parsed["duration_seconds"].(float64); queued+running > total+0.01 {
+		t.Errorf("the queued and running durations %.3f and %.3f add up to more than the total %.3f", queued, running, total)
+	}
+
+	cancelled := NewDownloadRecord()
+	cancelled.SetCompletionTime()
+	if s := cancelled.Snapshot(); s.QueuedDuration != 0 || s.RunningDuration != 0 {
+		t.Errorf("a transfer that never ran had durations %s and %s", s.QueuedDuration, s.RunningDuration)
+	}
+}
+
+func TestIRODSConfigOverride(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	app.IRODSConfigDir = filepath.Join(app.LogDirectory, "zones")
+	if err := os.Mkdir(app.IRODSConfigDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	zoneConfig := filepath.Join(app.IRODSConfigDir, "other-zone.properties")
+	if err := ioutil.WriteFile(zoneConfig, []byte("porklock.irods-zone=other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, `{"irods_config": "`+zoneConfig+`"}`)
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Fatalf("download returned %d %v", rec.Code, body)
+	}
+	if command := fmt.Sprint(body["command"]); !strings.Contains(command, "-c "+zoneConfig) {
+		t.Errorf("the requested iRODS config wasn't used: %s", command)
+	}
+
+	rec, body = postTransfer(app.UploadFilesHandler, "/upload?wait=true", nil, "")
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Fatalf("upload returned %d %v", rec.Code, body)
+	}
+	if command := fmt.Sprint(body["command"]); !strings.Contains(command, "-c "+app.ConfigPath) {
+		t.Errorf("the configured iRODS config wasn't used without an override: %s", command)
+	}
+}
+
+func TestIRODSConfigOutOfBounds(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	outside := filepath.Join(app.LogDirectory, "outside.properties")
+	if err := ioutil.WriteFile(outside, []byte("porklock.irods-zone=other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, body := postTransfer(app.UploadFilesHandler, "/upload", nil, `{"irods_config": "`+outside+`"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("an override without a config directory returned %d %v", rec.Code, body)
+	}
+
+	app.IRODSConfigDir = filepath.Join(app.LogDirectory, "zones")
+	if err := os.Mkdir(app.IRODSConfigDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(app.IRODSConfigDir, "link.properties")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, configPath := range []string{
+		outside,
+		filepath.Join(app.IRODSConfigDir, "..", "outside.properties"),
+		link,
+		app.IRODSConfigDir,
+		filepath.Join(app.IRODSConfigDir, "missing.properties"),
+		"zones/other-zone.properties",
+	} {
+		rec, body = postTransfer(app.DownloadFilesHandler, "/download", nil, `{"irods_config": "`+configPath+`"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("the iRODS config %s returned %d %v", configPath, rec.Code, body)
+		}
+	}
+
+	if n := len(app.downloadRecords.records) + len(app.uploadRecords.records); n != 0 {
+		t.Errorf("rejected requests created %d records", n)
+	}
+}
+
+// waitForRunning polls until n of the records have the status.
+func waitForRunning(t *testing.T, records *HistoricalRecords, status string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(records.FindByStatus(status)) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d transfers never reached %s", n, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func getCurrent(handler http.HandlerFunc) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/download/current", nil))
+	return rec
+}
+
+func TestCurrentTransferNone(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"download": app.GetCurrentDownload,
+		"upload":   app.GetCurrentUpload,
+	} {
+		if rec := getCurrent(handler); rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+			t.Errorf("current %s returned %d %q", name, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestCurrentTransferOne(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.5")
+	defer cleanup()
+
+	record, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForRunning(t, app.uploadRecords, UploadingStatus, 1)
+
+	rec := getCurrent(app.GetCurrentUpload)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code was %d", rec.Code)
+	}
+
+	body := map[string]interface{}{}
+	if err = json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response wasn't a single record: %s", err)
+	}
+
+	if body["uuid"] != record.UUID.String() || body["status"] != UploadingStatus {
+		t.Errorf("unexpected record %v", body)
+	}
+}
+
+func TestCurrentTransferMultiple(t *testing.T) {
+	app, cleanup := newTestApp(t, "sleep 0.5")
+	defer cleanup()
+
+	app.MaxConcurrentDownloads = 2
+
+	_, records, err := app.DownloadBatch(context.Background(), []BatchEntry{
+		{Paths: []string{"/a"}},
+		{Paths: []string{"/b"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForRunning(t, app.downloadRecords, DownloadingStatus, 2)
+
+	rec := getCurrent(app.GetCurrentDownload)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code was %d", rec.Code)
+	}
+
+	var body []map[string]interface{}
+	if err = json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response wasn't an array of records: %s", err)
+	}
+
+	if len(body) != 2 || body[0]["uuid"] != records[0].UUID.String() || body[1]["uuid"] != records[1].UUID.String() {
+		t.Errorf("unexpected records %v", body)
+	}
+}
+
+func TestTransferPriorityInBody(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	_, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, `{"priority": 7}`)
+	if body["priority"] != float64(7) {
+		t.Errorf("download record had priority %v", body["priority"])
+	}
+
+	rec, _ := postTransfer(app.DownloadFilesHandler, "/download", nil, `{"priority": "high"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid priority returned %d", rec.Code)
+	}
+}
+
+func TestRecordedCommand(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.FileMetadata = []string{"attr,value,unit"}
+
+	download, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, download)
+
+	upload, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, upload)
+
+	for _, tc := range []struct {
+		record   *TransferRecord
+		expected []string
+	}{
+		{download, app.downloadCommand(app.InputPathList, app.DownloadDestination, app.ConfigPath, "")},
+		{upload, app.uploadCommand(app.DownloadDestination, app.ExcludesPath, app.ConfigPath, "")},
+	} {
+		var buf bytes.Buffer
+		if err = tc.record.MarshalAndWrite(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		var body struct {
+			Command []string `json:"command"`
+		}
+		if err = json.Unmarshal(buf.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(body.Command, tc.expected) {
+			t.Errorf("%s recorded the command %v, not %v", tc.record.Kind, body.Command, tc.expected)
+		}
+	}
+}
+
+func TestUnusablePathList(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		setup    func(t *testing.T, pathList string) error
+		expected string
+	}{
+		{"missing", func(t *testing.T, p string) error { return os.Remove(p) }, "does not exist"},
+		{"directory", func(t *testing.T, p string) error {
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+			return os.Mkdir(p, 0755)
+		}, "is a directory"},
+		{"unreadable", func(t *testing.T, p string) error {
+			if os.Geteuid() == 0 {
+				t.Skip("file permissions don't apply to root")
+			}
+			return os.Chmod(p, 0)
+		}, "is not readable"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app, cleanup := newTestApp(t, "true")
+			defer cleanup()
+
+			if err := tc.setup(t, app.InputPathList); err != nil {
+				t.Fatal(err)
+			}
+
+			record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("error was %v", err)
+			}
+
+			if status := waitForStatus(t, record); status != FailedStatus {
+				t.Errorf("status was %s, not %s", status, FailedStatus)
+			}
+			if !strings.Contains(record.ErrorMessage, tc.expected) {
+				t.Errorf("error message was %q", record.ErrorMessage)
+			}
+		})
+	}
+}
+
+func TestGenericTransferStatus(t *testing.T) {
+	app := &App{
+		GzipMinSize:     1024,
+		downloadRecords: &HistoricalRecords{},
+		uploadRecords:   &HistoricalRecords{},
+	}
+	router := app.newRouter()
+
+	download := NewDownloadRecord()
+	app.downloadRecords.Append(download)
+	upload := NewUploadRecord()
+	app.uploadRecords.Append(upload)
+
+	get := func(target string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		body := map[string]interface{}{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec, body
+	}
+
+	for _, r := range []*TransferRecord{download, upload} {
+		rec, body := get("/transfers/" + r.Kind + "/" + r.UUID.String())
+		if rec.Code != http.StatusOK || body["uuid"] != r.UUID.String() || body["kind"] != r.Kind {
+			t.Errorf("%s through the generic route returned %d %v", r.Kind, rec.Code, body)
+		}
+
+		if rec, _ := get("/" + r.Kind + "/" + r.UUID.String()); rec.Code != http.StatusOK {
+			t.Errorf("%s through the existing route returned %d", r.Kind, rec.Code)
+		}
+	}
+
+	for _, target := range []string{
+		"/transfers/download/" + upload.UUID.String(),
+		"/transfers/upload/" + download.UUID.String(),
+		"/transfers/sync/" + download.UUID.String(),
+	} {
+		if rec, _ := get(target); rec.Code != http.StatusNotFound {
+			t.Errorf("%s returned %d", target, rec.Code)
+		}
+	}
+}
+
+func TestRecordedProcessTimes(t *testing.T) {
+	app, cleanup := newTestApp(t, "i=0; while [ $i -lt 100000 ]; do i=$((i+1)); done")
+	defer cleanup()
+
+	record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, record); status != CompletedStatus {
+		t.Fatalf("download finished with status %s", status)
+	}
+
+	var buf bytes.Buffer
+	if err = record.MarshalAndWrite(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var body map[string]interface{}
+	if err = json.Unmarshal(buf.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	var total float64
+	for _, field := range []string{"system_time_ms", "user_time_ms"} {
+		ms, ok := body[field].(float64)
+		if !ok || ms < 0 {
+			t.Errorf("%s was %v", field, body[field])
+		}
+		total += ms
+	}
+	if total == 0 {
+		t.Error("no CPU time was recorded for the busy command")
+	}
+	if body["exit_code"] != float64(0) {
+		t.Errorf("exit code was %v", body["exit_code"])
+	}
+}
+
+func TestRecordsCarryInvocationID(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.InvocationID = "3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0"
+
+	_, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true", nil, "")
+	if body["invocation_id"] != app.InvocationID {
+		t.Errorf("download invocation ID was %v", body["invocation_id"])
+	}
+
+	_, records, err := app.DownloadBatch(context.Background(), []BatchEntry{{Paths: []string{"/a"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[0].InvocationID != app.InvocationID {
+		t.Errorf("batch download invocation ID was %q", records[0].InvocationID)
+	}
+}
+
+func TestFindRecordAfterEviction(t *testing.T) {
+	records := &HistoricalRecords{maxRecords: 3}
+
+	var all []*TransferRecord
+	for i := 0; i < 6; i++ {
+		r := NewDownloadRecord()
+		if i != 1 {
+			r.SetStatus(CompletedStatus)
+		}
+		records.Append(r)
+		all = append(all, r)
+	}
+
+	// The unfinished record is never evicted, so the oldest finished ones go.
+	for i, r := range all {
+		expected := i == 1 || i >= 4
+		if found := records.FindRecord(r.UUID.String()); (found == r) != expected {
+			t.Errorf("record %d was found: %t", i, found == r)
+		}
+	}
+
+	if !records.Remove(all[4].UUID.String()) {
+		t.Fatal("record 4 wasn't removed")
+	}
+	if records.FindRecord(all[4].UUID.String()) != nil {
+		t.Error("a removed record was still found")
+	}
+	if records.Remove(all[4].UUID.String()) {
+		t.Error("a removed record was removed again")
+	}
+
+	if len(records.records) != len(records.byUUID) {
+		t.Errorf("%d records are listed but %d are indexed", len(records.records), len(records.byUUID))
+	}
+	for _, r := range records.records {
+		if records.byUUID[r.UUID.String()] != r {
+			t.Errorf("record %s isn't indexed", r.UUID)
+		}
+	}
+}
+
+func benchmarkRecords(n int) (*HistoricalRecords, string) {
+	records := &HistoricalRecords{}
+	for i := 0; i < n; i++ {
+		records.Append(NewDownloadRecord())
+	}
+	return records, records.records[n-1].UUID.String()
+}
+
+func BenchmarkFindRecord(b *testing.B) {
+	records, id := benchmarkRecords(10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		records.FindRecord(id)
+	}
+}
+
+// BenchmarkFindRecordLinear measures the linear scan that FindRecord used to do,
+// for comparison with BenchmarkFindRecord.
+func BenchmarkFindRecordLinear(b *testing.B) {
+	records, id := benchmarkRecords(10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		records.mutex.Lock()
+		for _, r := range records.records {
+			if r.UUID.String() == id {
+				break
+			}
+		}
+		records.mutex.Unlock()
+	}
+}
+
+func TestDownloadSinglePath(t *testing.T) {
+	app, cleanup := newTestApp(t, fakeGet)
+	defer cleanup()
+
+	// The configured path list is bypassed, so it doesn't need to exist.
+	os.Remove(app.InputPathList)
+
+	rec, body := postTransfer(app.DownloadFilesHandler, "/download?wait=true&path="+url.QueryEscape("/iplant/home/test-user/one file.txt"), nil, "")
+	if rec.Code != http.StatusOK || body["status"] != CompletedStatus {
+		t.Fatalf("single path download returned %d %v", rec.Code, body)
+	}
+
+	received, err := ioutil.ReadFile(filepath.Join(app.LogDirectory, "received"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(received) != "/iplant/home/test-user/one file.txt\n" {
+		t.Errorf("porklock was asked to download %q", string(received))
+	}
+
+	r := app.downloadRecords.FindRecord(body["uuid"].(string))
+	for _, p := range r.params.tempFiles {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("temporary path list %s wasn't removed", p)
+		}
+	}
+}
+
+func TestDownloadSinglePathInvalid(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	for _, query := range []string{"path=", "path=relative/file.txt", "path=/a&path=/b"} {
+		rec, body := postTransfer(app.DownloadFilesHandler, "/download?"+query, nil, "")
+		if rec.Code != http.StatusBadRequest || body["error"] == nil {
+			t.Errorf("%s returned %d %v", query, rec.Code, body)
+		}
+	}
+
+	if n := len(app.downloadRecords.records); n != 0 {
+		t.Errorf("%d records were created for invalid paths", n)
+	}
+}
+
+func TestSnapshotIsIndependent(t *testing.T) {
+	r := NewDownloadRecord()
+	r.SetStderrTail([]string{"line"})
+	r.SetCommand([]string{"porklock", "get"})
+
+	snapshot := r.Snapshot()
+	snapshot.StderrTail[0] = "changed"
+	snapshot.Command[0] = "changed"
+
+	if r.StderrTail[0] != "line" || r.Command[0] != "porklock" {
+		t.Errorf("changing the snapshot changed the record: %v %v", r.StderrTail, r.Command)
+	}
+}
+
+// TestConcurrentRecordReads updates a record while it's serialized in all of the
+// ways the handlers do. Run with -race to check the record's fields are only
+// read through snapshots.
+func TestConcurrentRecordReads(t *testing.T) {
+	app := &App{downloadRecords: &HistoricalRecords{}}
+	r := NewDownloadRecord()
+	app.downloadRecords.Append(r)
+
+	stop := make(chan struct{})
+	var writers sync.WaitGroup
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			r.SetStatus(DownloadingStatus)
+			r.SetStderrTail([]string{fmt.Sprintf("line %d", i)})
+			r.SetCommand([]string{"porklock", fmt.Sprint(i)})
+			r.SetFailed(fmt.Errorf("failure %d", i))
+		}
+	}()
+
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for j := 0; j < 200; j++ {
+				encoded, err := json.Marshal(r)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				body := map[string]interface{}{}
+				if err = json.Unmarshal(encoded, &body); err != nil {
+					t.Error(err)
+					return
+				}
+				if body["status"] == FailedStatus && body["error_message"] == nil {
+					t.Error("a failed record was serialized without its error message")
+				}
+
+				r.TextSummary()
+				var buf bytes.Buffer
+				if err := r.MarshalAndWrite(&buf); err != nil {
+					t.Error(err)
+				}
+
+				rec := httptest.NewRecorder()
+				app.ListDownloads(rec, httptest.NewRequest(http.MethodGet, "/downloads", nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("listing returned %d", rec.Code)
+				}
+			}
+		}()
+	}
+
+	readers.Wait()
+	close(stop)
+	writers.Wait()
+}
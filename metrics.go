@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// queuedDuration records how long transfers waited in the queue before they
+// started running, labelled by the kind of transfer.
+var queuedDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "transfer_queued_duration_seconds",
+	Help:    "How long transfers waited in the queue before they started running.",
+	Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+}, []string{"kind"})
+
+// metricsHandler serves the service's Prometheus metrics.
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// queuedDurationCount scrapes /metrics and returns the number of queued
+// durations observed for downloads.
+func queuedDurationCount(t *testing.T, app *App) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	app.newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/metrics returned %d", rec.Code)
+	}
+
+	const prefix = `transfer_queued_duration_seconds_count{kind="download"} `
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, prefix) {
+			count, err := strconv.Atoi(strings.TrimPrefix(line, prefix))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return count
+		}
+	}
+	return 0
+}
+
+func TestQueuedDurationHistogram(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	before := queuedDurationCount(t, app)
+
+	download, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, download)
+
+	if after := queuedDurationCount(t, app); after != before+1 {
+		t.Errorf("the queued duration count went from %d to %d", before, after)
+	}
+}
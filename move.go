@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// newUploadReport returns an uploadReport whose candidates are the files in
+// source that an upload with upload-move may remove. The log directory, the
+// audit log and the transfer logs that records still refer to are left out,
+// since the service still needs them.
+func (a *App) newUploadReport(source string) (*uploadReport, error) {
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing upload source %s", source)
+	}
+
+	kept := make(map[string]bool)
+	if a.AuditLog != "" {
+		if abs, err := filepath.Abs(a.AuditLog); err == nil {
+			kept[abs] = true
+		}
+	}
+	logDirectory, err := filepath.Abs(a.LogDirectory)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve the log directory %s", a.LogDirectory)
+	}
+
+	prefix := strings.TrimSuffix(source, "/") + "/"
+
+	var candidates []string
+	err = walkUploadSource(source, nil, func(relPath string) error {
+		fullPath := filepath.Join(source, relPath)
+		if kept[fullPath] || underPrefix(fullPath, logDirectory) {
+			return nil
+		}
+		if a.uploadRecords.ReferencesLog(fullPath) || a.downloadRecords.ReferencesLog(fullPath) {
+			return nil
+		}
+		candidates = append(candidates, strings.TrimPrefix(fullPath, prefix))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing upload source %s", source)
+	}
+	sort.Strings(candidates)
+	return &uploadReport{
+		prefix:     prefix,
+		candidates: candidates,
+		reported:   make(map[string]bool),
+	}, nil
+}
+
+const (
+	// maxReportLineBytes caps the length of the lines of porklock's output
+	// that are searched for uploaded paths.
+	maxReportLineBytes = 64 * 1024
+
+	// reportBoundaries are the characters that may follow a path named in
+	// porklock's output.
+	reportBoundaries = " \t\"',;:)]"
+)
+
+// uploadReport is an io.Writer that notes which of the candidate files are
+// named in porklock's output, so that upload-move only removes the files that
+// porklock reported uploading rather than guessing which ones its excludes
+// left out. A path counts as named when it's followed by the end of the line,
+// whitespace or punctuation that can't continue it. The candidates are kept
+// sorted and relative to the prefix. It's safe for concurrent use.
+type uploadReport struct {
+	prefix     string
+	candidates []string
+	reported   map[string]bool
+	partial    []byte
+	mutex      sync.Mutex
+}
+
+// Write notes the candidates named in the complete lines in p. A trailing
+// partial line is held until the rest of it is written.
+func (u *uploadReport) Write(p []byte) (int, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			u.appendPartial(p)
+			break
+		}
+
+		u.appendPartial(p[:i])
+		u.note(string(u.partial))
+		u.partial = u.partial[:0]
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// appendPartial adds as much of b to the partial line as fits.
+func (u *uploadReport) appendPartial(b []byte) {
+	if room := maxReportLineBytes - len(u.partial); len(b) > room {
+		b = b[:room]
+	}
+	u.partial = append(u.partial, b...)
+}
+
+// note records the candidates named in the line. Each place that the upload
+// source is named is followed along the sorted candidates one byte at a time,
+// so that paths containing spaces are found too without searching the rest of
+// the line again at every place that a path could end.
+func (u *uploadReport) note(line string) {
+	for {
+		start := strings.Index(line, u.prefix)
+		if start < 0 {
+			return
+		}
+		u.noteFrom(line[start+len(u.prefix):])
+		line = line[start+1:]
+	}
+}
+
+// noteFrom records the candidates that rest starts with, where they're followed
+// by a boundary or the end of rest. A candidate followed by dots, like the end
+// of a sentence, counts too. lo and hi bound the candidates that start with the
+// bytes of rest seen so far, which are always next to each other since the
+// candidates are sorted.
+func (u *uploadReport) noteFrom(rest string) {
+	lo, hi := 0, len(u.candidates)
+	trimmed := ""
+	for i := 0; ; i++ {
+		exact := ""
+		if lo < hi && len(u.candidates[lo]) == i {
+			exact = u.candidates[lo]
+		}
+		if i == 0 || rest[i-1] != '.' {
+			trimmed = exact
+		}
+
+		if i == len(rest) || strings.IndexByte(reportBoundaries, rest[i]) >= 0 {
+			for _, p := range []string{exact, trimmed} {
+				if p != "" {
+					u.reported[u.prefix+p] = true
+				}
+			}
+		}
+
+		if i == len(rest) || (lo >= hi && (trimmed == "" || rest[i] != '.')) {
+			return
+		}
+
+		c := rest[i]
+		candidates := u.candidates[lo:hi]
+		first := sort.Search(len(candidates), func(k int) bool {
+			return len(candidates[k]) > i && candidates[k][i] >= c
+		})
+		last := sort.Search(len(candidates), func(k int) bool {
+			return len(candidates[k]) > i && candidates[k][i] > c
+		})
+		lo, hi = lo+first, lo+last
+	}
+}
+
+// Reported returns the candidates that porklock's output named, sorted.
+func (u *uploadReport) Reported() []string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if len(u.partial) > 0 {
+		u.note(string(u.partial))
+		u.partial = u.partial[:0]
+	}
+
+	files := make([]string, 0, len(u.reported))
+	for p := range u.reported {
+		files = append(files, p)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// reportingTo returns a copy of the logs whose porklock output is also written
+// to the report. Combined logs keep sharing a single writer.
+func (l *transferLogs) reportingTo(report io.Writer) *transferLogs {
+	reporting := *l
+	reporting.stdoutOutput = io.MultiWriter(l.stdoutOutput, report)
+	if l.stderrOutput == l.stdoutOutput {
+		reporting.stderrOutput = reporting.stdoutOutput
+	} else {
+		reporting.stderrOutput = io.MultiWriter(l.stderrOutput, report)
+	}
+	return &reporting
+}
+
+// removeUploadedFiles deletes the files that were uploaded, leaving the
+// directories in place. It tries to remove all of them, returning the first
+// error. Files that have already gone don't count as errors.
+func removeUploadedFiles(files []string) error {
+	var first error
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) && first == nil {
+			first = errors.Wrapf(err, "failed to remove uploaded file %s", f)
+		}
+	}
+	return first
+}
+
+// checkUploadMove returns an error if uploads would move the files out of the
+// shared download destination without that being confirmed. Moving files from a
+// user's own subdirectory doesn't need to be confirmed. The log directory can't
+// contain the download destination, since nothing under it is ever moved.
+func (o *Options) checkUploadMove() error {
+	if !o.UploadMove {
+		return nil
+	}
+	if !o.NamespaceByUser && !o.UploadMoveConfirm {
+		return errors.New("upload-move deletes the uploaded files from the download destination, so it must be confirmed with upload-move-confirm unless transfers are namespaced by user")
+	}
+	if underPrefix(o.DownloadDestination, o.LogDirectory) {
+		return fmt.Errorf("upload-move never removes the files under the log-dir %s, so it must not contain the download-destination %s", o.LogDirectory, o.DownloadDestination)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeUploadSource creates the named files in the upload source.
+func writeUploadSource(t *testing.T, app *App, names ...string) {
+	for _, name := range names {
+		p := filepath.Join(app.DownloadDestination, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// reportingScript is a fake porklock that reports uploading the files in the
+// upload source named by its arguments.
+func reportingScript(names ...string) string {
+	script := `[ "$3" = put ] || exit 0` + "\n"
+	for _, name := range names {
+		script += `echo "Uploading '$7/` + name + `' to /iplant/home/test-user/outputs."` + "\n"
+	}
+	return script
+}
+
+// newMoveTestApp returns a test app that moves uploaded files, with its logs
+// in a directory of their own, since files under the log directory are never
+// moved.
+func newMoveTestApp(t *testing.T, script string) (*App, func()) {
+	app, cleanup := newTestApp(t, script)
+
+	app.UploadMove = true
+	app.LogDirectory = filepath.Join(app.LogDirectory, "logs")
+	if err := os.Mkdir(app.LogDirectory, 0755); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return app, cleanup
+}
+
+func TestUploadMove(t *testing.T) {
+	app, cleanup := newMoveTestApp(t, reportingScript("a.txt", "sub/b c.txt"))
+	defer cleanup()
+
+	writeUploadSource(t, app, "a.txt", "a.txt.bak", "sub/b c.txt", "keep.tmp")
+
+	r, err := app.UploadFiles(context.Background(), &TransferRequest{Excludes: []string{"*.tmp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, r); status != CompletedStatus {
+		t.Fatalf("upload finished with status %s: %s", status, r.ErrorMessage)
+	}
+
+	if !r.Snapshot().MovedFiles {
+		t.Error("the record doesn't show that the files were moved")
+	}
+	for _, name := range []string{"a.txt", "sub/b c.txt"} {
+		if exists(filepath.Join(app.DownloadDestination, name)) {
+			t.Errorf("uploaded file %s wasn't removed", name)
+		}
+	}
+	for _, name := range []string{"a.txt.bak", "keep.tmp"} {
+		if !exists(filepath.Join(app.DownloadDestination, name)) {
+			t.Errorf("file %s was removed, but porklock didn't report uploading it", name)
+		}
+	}
+	if !exists(filepath.Join(app.DownloadDestination, "sub")) {
+		t.Error("a directory was removed")
+	}
+}
+
+func TestUploadMoveKeepsServiceFiles(t *testing.T) {
+	app, cleanup := newMoveTestApp(t, reportingScript("data.txt", "logs/old.log", "logs/uploads.stdout.log", "audit.log"))
+	defer cleanup()
+
+	app.LogDirectory = filepath.Join(app.DownloadDestination, "logs")
+	app.AuditLog = filepath.Join(app.DownloadDestination, "audit.log")
+	writeUploadSource(t, app, "data.txt", "logs/old.log", "audit.log")
+
+	r, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, r); status != CompletedStatus {
+		t.Fatalf("upload finished with status %s: %s", status, r.ErrorMessage)
+	}
+
+	if exists(filepath.Join(app.DownloadDestination, "data.txt")) {
+		t.Error("the uploaded data file wasn't removed")
+	}
+	for _, name := range []string{"logs/old.log", "logs/uploads.stdout.log", "audit.log"} {
+		if !exists(filepath.Join(app.DownloadDestination, name)) {
+			t.Errorf("the service's file %s was removed", name)
+		}
+	}
+}
+
+func TestUploadMoveFailure(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 1")
+	defer cleanup()
+
+	app.UploadMove = true
+	writeUploadSource(t, app, "a.txt")
+
+	r, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, r); status != FailedStatus {
+		t.Fatalf("upload finished with status %s", status)
+	}
+
+	if r.Snapshot().MovedFiles {
+		t.Error("a failed upload was recorded as moving its files")
+	}
+	if !exists(filepath.Join(app.DownloadDestination, "a.txt")) {
+		t.Error("the files of a failed upload were removed")
+	}
+}
+
+func TestUploadMoveNothingReported(t *testing.T) {
+	app, cleanup := newMoveTestApp(t, "true")
+	defer cleanup()
+
+	writeUploadSource(t, app, "a.txt")
+
+	r, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, r); status != CompletedStatus {
+		t.Fatalf("upload finished with status %s: %s", status, r.ErrorMessage)
+	}
+
+	if r.Snapshot().MovedFiles {
+		t.Error("an upload that removed nothing was recorded as moving its files")
+	}
+	if !exists(filepath.Join(app.DownloadDestination, "a.txt")) {
+		t.Error("a file that porklock didn't report uploading was removed")
+	}
+}
+
+func TestUploadReportNote(t *testing.T) {
+	report := &uploadReport{
+		prefix:     "/src/",
+		candidates: []string{"a b.txt", "a.txt", "a.txt.bak", "dir/c.txt"},
+		reported:   make(map[string]bool),
+	}
+
+	report.Write([]byte("Uploading '/src/a.txt' and /src/a b.txt.\n"))
+	report.Write([]byte("done with /src/dir/c.txt... skipped /src/a.txt.ba /src/missing\n"))
+
+	got := strings.Join(report.Reported(), ",")
+	if want := "/src/a b.txt,/src/a.txt,/src/dir/c.txt"; got != want {
+		t.Errorf("the report named %s, not %s", got, want)
+	}
+}
+
+func TestUploadReportLongLine(t *testing.T) {
+	var candidates []string
+	for i := 0; i < 1000; i++ {
+		candidates = append(candidates, fmt.Sprintf("file %d.txt", i))
+	}
+	sort.Strings(candidates)
+	report := &uploadReport{
+		prefix:     "/src/",
+		candidates: candidates,
+		reported:   make(map[string]bool),
+	}
+
+	// A line of the upload source named over and over, up to the line limit.
+	line := strings.Repeat("/src/file 1", maxReportLineBytes/len("/src/file 1"))
+
+	start := time.Now()
+	report.Write([]byte(line + "\n"))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("noting the line took %s", elapsed)
+	}
+	if reported := report.Reported(); len(reported) != 0 {
+		t.Errorf("the report named %v", reported)
+	}
+}
+
+func TestUploadCopiesByDefault(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	writeUploadSource(t, app, "a.txt")
+
+	r, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, r)
+
+	if r.Snapshot().MovedFiles || !exists(filepath.Join(app.DownloadDestination, "a.txt")) {
+		t.Error("files were moved without --upload-move")
+	}
+}
+
+func TestUploadMoveConfirmation(t *testing.T) {
+	args := []string{"--user", "u", "--upload-destination", "/dest", "--invocation-id", "3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0", "--upload-move", "--log-dir", "/var/log/vice"}
+
+	if _, err := parseOptions(args); err == nil || !strings.Contains(err.Error(), "upload-move-confirm") {
+		t.Errorf("unconfirmed upload-move returned %v", err)
+	}
+
+	for _, extra := range []string{"--upload-move-confirm", "--namespace-by-user"} {
+		if _, err := parseOptions(append(args, extra)); err != nil {
+			t.Errorf("upload-move with %s returned %s", extra, err)
+		}
+	}
+
+	if _, err := parseOptions(append(args, "--upload-move-confirm", "--log-dir", "/input-files")); err == nil || !strings.Contains(err.Error(), "log-dir") {
+		t.Errorf("upload-move with the logs in the download destination returned %v", err)
+	}
+}
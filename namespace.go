@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// userDirectoryPattern matches the user names that may be used as directory
+// names when transfers are namespaced by user.
+var userDirectoryPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+
+// checkUserDirectoryName returns an error if the user name can't safely be used
+// as the name of a subdirectory, e.g. because it contains a path separator or
+// refers to a parent directory.
+func checkUserDirectoryName(user string) error {
+	if !userDirectoryPattern.MatchString(user) || user == "." || user == ".." {
+		return fmt.Errorf("the user name %q can't be used as a directory name", user)
+	}
+	return nil
+}
+
+// userDirectory returns the directory that transfers use in place of base. It's
+// base itself unless transfers are namespaced by user, in which case it's the
+// user's subdirectory of base, which is created if it doesn't exist.
+func (a *App) userDirectory(base string) (string, error) {
+	if !a.NamespaceByUser {
+		return base, nil
+	}
+
+	if err := checkUserDirectoryName(a.User); err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(base, a.User)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create the user directory %s", dir)
+	}
+	return dir, nil
+}
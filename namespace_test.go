@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckUserDirectoryName(t *testing.T) {
+	for user, valid := range map[string]bool{
+		"test-user":          true,
+		"first.last@cyverse": true,
+		"":                   false,
+		".":                  false,
+		"..":                 false,
+		"../etc":             false,
+		"a/b":                false,
+		"/root":              false,
+		`a\b`:                false,
+		"a b":                false,
+	} {
+		if err := checkUserDirectoryName(user); (err == nil) != valid {
+			t.Errorf("checkUserDirectoryName(%q) returned %v", user, err)
+		}
+	}
+}
+
+func TestNamespacedTransfers(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.NamespaceByUser = true
+	userDir := filepath.Join(app.DownloadDestination, "test-user")
+
+	download, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, download)
+
+	if info, err := os.Stat(userDir); err != nil || !info.IsDir() {
+		t.Fatalf("the user directory wasn't created: %v", err)
+	}
+	if download.params.destination != userDir {
+		t.Errorf("download destination was %s, not %s", download.params.destination, userDir)
+	}
+	if !strings.Contains(strings.Join(download.Command, " "), "--destination "+userDir) {
+		t.Errorf("download command was %v", download.Command)
+	}
+
+	upload, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, upload)
+
+	if !strings.Contains(strings.Join(upload.Command, " "), "--source "+userDir) {
+		t.Errorf("upload command was %v", upload.Command)
+	}
+}
+
+func TestNamespacedTraversalRejected(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.NamespaceByUser = true
+	app.User = "../../etc"
+
+	download, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err == nil {
+		t.Fatal("a user name with a traversal was accepted")
+	}
+	if status := waitForStatus(t, download); status != FailedStatus {
+		t.Errorf("download status was %s", status)
+	}
+	if len(download.Command) != 0 {
+		t.Errorf("porklock was run as %v", download.Command)
+	}
+
+	upload, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, upload); status != FailedStatus {
+		t.Errorf("upload status was %s", status)
+	}
+
+	if _, err := parseOptions([]string{
+		"--user", "../../etc",
+		"--upload-destination", "/dest",
+		"--invocation-id", "3d1c6b5e-0b33-4d2a-9a51-7d33f1f4c2a0",
+		"--namespace-by-user",
+	}); err == nil || !strings.Contains(err.Error(), "can't be used as a directory name") {
+		t.Errorf("startup with a traversal in the user name returned %v", err)
+	}
+}
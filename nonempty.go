@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotFiles returns the modification times of the regular files under dir,
+// keyed by path. A dir that doesn't exist has no files.
+func snapshotFiles(dir string) (map[string]time.Time, error) {
+	files := make(map[string]time.Time)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files[p] = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list the files in %s", dir)
+	}
+	return files, nil
+}
+
+// landedFiles returns the number of regular files under dir that aren't in the
+// snapshot or have been modified since it was taken, leaving out the ignored
+// paths.
+func landedFiles(dir string, before map[string]time.Time, ignored ...string) (int, error) {
+	after, err := snapshotFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range ignored {
+		delete(after, p)
+	}
+
+	landed := 0
+	for p, modTime := range after {
+		if previous, ok := before[p]; !ok || !previous.Equal(modTime) {
+			landed++
+		}
+	}
+	return landed, nil
+}
+
+// checkFilesLanded returns an error if porklock didn't add or update any files
+// in the destination since the snapshot was taken. The record's own logs are
+// ignored, since they may be written to the destination while porklock runs.
+func checkFilesLanded(r *TransferRecord, before map[string]time.Time) error {
+	destination := r.params.destination
+	stdoutPath, stderrPath := r.LogPaths()
+
+	landed, err := landedFiles(destination, before, filepath.Clean(stdoutPath), filepath.Clean(stderrPath))
+	if err != nil {
+		return err
+	}
+	if landed == 0 {
+		return fmt.Errorf("the download finished without adding or updating any files in %s", destination)
+	}
+	return nil
+}
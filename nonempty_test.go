@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runNonemptyDownload(t *testing.T, app *App) *TransferRecord {
+	if err := ioutil.WriteFile(app.InputPathList, []byte("/iplant/home/test-user/a.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, r)
+	return r
+}
+
+func TestRequireNonempty(t *testing.T) {
+	app, cleanup := newTestApp(t, fakeGet)
+	defer cleanup()
+
+	app.RequireNonempty = true
+
+	if r := runNonemptyDownload(t, app); r.Status != CompletedStatus {
+		t.Errorf("download that fetched a file finished with status %s: %s", r.Status, r.ErrorMessage)
+	}
+}
+
+func TestRequireNonemptyNothingDownloaded(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	// A file that was already in the destination doesn't count.
+	if err := ioutil.WriteFile(filepath.Join(app.DownloadDestination, "old.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := runNonemptyDownload(t, app); r.Status != CompletedStatus {
+		t.Errorf("download finished with status %s without --require-nonempty", r.Status)
+	}
+
+	app.RequireNonempty = true
+
+	r := runNonemptyDownload(t, app)
+	if r.Status != FailedStatus {
+		t.Fatalf("download that fetched nothing finished with status %s", r.Status)
+	}
+	if !strings.Contains(r.ErrorMessage, "without adding or updating any files") {
+		t.Errorf("error message was %q", r.ErrorMessage)
+	}
+}
+
+func TestRequireNonemptyIgnoresLogs(t *testing.T) {
+	app, cleanup := newTestApp(t, "echo progress; echo warning >&2")
+	defer cleanup()
+
+	app.RequireNonempty = true
+	app.DownloadDestination = app.LogDirectory
+
+	if r := runNonemptyDownload(t, app); r.Status != FailedStatus {
+		t.Errorf("download that only wrote its logs to the destination finished with status %s", r.Status)
+	}
+}
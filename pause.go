@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+)
+
+// QueueStatus reports whether the transfer queues are paused and how many
+// transfers are waiting in each of them.
+type QueueStatus struct {
+	Paused          bool `json:"paused"`
+	QueuedDownloads int  `json:"queued_downloads"`
+	QueuedUploads   int  `json:"queued_uploads"`
+}
+
+// queueStatus returns the current status of the download and upload queues.
+func (a *App) queueStatus() *QueueStatus {
+	return &QueueStatus{
+		Paused:          a.queue(DownloadKind).Paused(),
+		QueuedDownloads: a.queue(DownloadKind).Pending(),
+		QueuedUploads:   a.queue(UploadKind).Pending(),
+	}
+}
+
+// setQueuesPaused pauses or resumes both the download and upload queues.
+func (a *App) setQueuesPaused(paused bool) {
+	a.queue(DownloadKind).SetPaused(paused)
+	a.queue(UploadKind).SetPaused(paused)
+}
+
+// PauseQueue stops queued transfers from starting. New requests are still
+// accepted and stay in the requested status until the queue is resumed.
+// Transfers that are already running carry on.
+func (a *App) PauseQueue(writer http.ResponseWriter, req *http.Request) {
+	a.setQueuesPaused(true)
+	log.Warn("paused the transfer queues")
+	render(writer, req, http.StatusOK, a.queueStatus())
+}
+
+// ResumeQueue lets queued transfers start again after the queue was paused.
+func (a *App) ResumeQueue(writer http.ResponseWriter, req *http.Request) {
+	a.setQueuesPaused(false)
+	log.Warn("resumed the transfer queues")
+	render(writer, req, http.StatusOK, a.queueStatus())
+}
+
+// GetQueueStatus reports whether the queue is paused and how many transfers
+// are waiting to start.
+func (a *App) GetQueueStatus(writer http.ResponseWriter, req *http.Request) {
+	render(writer, req, http.StatusOK, a.queueStatus())
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPauseQueue(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	rec := httptest.NewRecorder()
+	app.PauseQueue(rec, httptest.NewRequest(http.MethodPost, "/queue/pause", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("pause returned %d", rec.Code)
+	}
+
+	r, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if status := r.CurrentStatus(); status != RequestedStatus {
+		t.Fatalf("download started while the queue was paused, status %s", status)
+	}
+
+	rec = httptest.NewRecorder()
+	app.GetQueueStatus(rec, httptest.NewRequest(http.MethodGet, "/queue", nil))
+	if expected := `{"paused":true,"queued_downloads":1,"queued_uploads":0}`; rec.Body.String() != expected {
+		t.Errorf("queue status was %s, not %s", rec.Body.String(), expected)
+	}
+
+	rec = httptest.NewRecorder()
+	app.ResumeQueue(rec, httptest.NewRequest(http.MethodPost, "/queue/resume", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("resume returned %d", rec.Code)
+	}
+
+	if status := waitForStatus(t, r); status != CompletedStatus {
+		t.Errorf("resumed download finished with status %s", status)
+	}
+}
+
+func TestPauseQueueRunningTransfer(t *testing.T) {
+	app, cleanup := newTestApp(t, `while [ ! -e "$(dirname "$0")/release" ]; do sleep 0.05; done`)
+	defer cleanup()
+
+	r, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForRunning(t, app.downloadRecords, DownloadingStatus, 1)
+
+	app.setQueuesPaused(true)
+
+	if err = ioutil.WriteFile(filepath.Join(app.LogDirectory, "release"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, r); status != CompletedStatus {
+		t.Errorf("download running when the queue was paused finished with status %s", status)
+	}
+}
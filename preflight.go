@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsAllowedHeaders are the request headers that cross-origin clients may send
+// to the transfer endpoints, including the ones that revalidate statuses with
+// their ETags and ask for transfers to be run asynchronously.
+var corsAllowedHeaders = []string{"Accept", "Content-Type", idempotencyKeyHeader, "If-None-Match", "Prefer"}
+
+// preflight returns a handler for OPTIONS requests to a route that supports the
+// methods. It responds with a 204 and an Allow header listing the methods. The
+// CORS headers are included too if a CORS origin is configured.
+func (a *App) preflight(methods ...string) http.HandlerFunc {
+	allow := strings.Join(append(methods, http.MethodOptions), ", ")
+
+	return func(writer http.ResponseWriter, req *http.Request) {
+		header := writer.Header()
+		header.Set("Allow", allow)
+
+		if a.CORSOrigin != "" {
+			header.Set("Access-Control-Allow-Origin", a.CORSOrigin)
+			header.Set("Access-Control-Allow-Methods", allow)
+			header.Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+			if a.CORSOrigin != "*" {
+				header.Add("Vary", "Origin")
+			}
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
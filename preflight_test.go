@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOptionsAllowHeader(t *testing.T) {
+	app := &App{
+		GzipMinSize:     1024,
+		downloadRecords: &HistoricalRecords{},
+		uploadRecords:   &HistoricalRecords{},
+	}
+	router := app.newRouter()
+
+	for target, expected := range map[string]string{
+		"/status":                   "GET, OPTIONS",
+		"/config":                   "GET, OPTIONS",
+		"/healthz":                  "GET, OPTIONS",
+		"/drain":                    "GET, POST, OPTIONS",
+		"/download":                 "POST, OPTIONS",
+		"/downloads":                "GET, OPTIONS",
+		"/downloads/batch":          "POST, OPTIONS",
+		"/downloads/batch/some-id":  "GET, OPTIONS",
+		"/queue":                    "GET, OPTIONS",
+		"/queue/pause":              "POST, OPTIONS",
+		"/queue/resume":             "POST, OPTIONS",
+		"/logs":                     "DELETE, OPTIONS",
+		"/download/cancel-all":      "POST, OPTIONS",
+		"/download/current":         "GET, OPTIONS",
+		"/download/last-error":      "GET, OPTIONS",
+		"/download/some-id":         "GET, OPTIONS",
+		"/download/some-id/stream":  "GET, OPTIONS",
+		"/download/some-id/ws":      "GET, OPTIONS",
+		"/download/some-id/record":  "DELETE, OPTIONS",
+		"/upload":                   "POST, OPTIONS",
+		"/uploads":                  "GET, OPTIONS",
+		"/upload/preview":           "GET, OPTIONS",
+		"/upload/cancel-all":        "POST, OPTIONS",
+		"/upload/current":           "GET, OPTIONS",
+		"/upload/last-error":        "GET, OPTIONS",
+		"/upload/some-id":           "GET, OPTIONS",
+		"/upload/some-id/stream":    "GET, OPTIONS",
+		"/upload/some-id/ws":        "GET, OPTIONS",
+		"/upload/some-id/record":    "DELETE, OPTIONS",
+		"/transfers":                "GET, OPTIONS",
+		"/transfers/status":         "POST, OPTIONS",
+		"/transfers/some-id/events": "GET, OPTIONS",
+		"/transfers/upload/some-id": "GET, OPTIONS",
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, target, nil))
+
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("OPTIONS %s returned %d", target, rec.Code)
+		}
+		if allow := rec.Header().Get("Allow"); allow != expected {
+			t.Errorf("Allow for %s was %q, not %q", target, allow, expected)
+		}
+		if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("CORS headers were sent for %s without a CORS origin", target)
+		}
+	}
+}
+
+func TestOptionsCORSHeaders(t *testing.T) {
+	app := &App{
+		GzipMinSize:     1024,
+		CORSOrigin:      "https://de.example.org",
+		downloadRecords: &HistoricalRecords{},
+		uploadRecords:   &HistoricalRecords{},
+	}
+
+	req := httptest.NewRequest(http.MethodOptions, "/download", nil)
+	req.Header.Set("Origin", "https://de.example.org")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec := httptest.NewRecorder()
+	app.newRouter().ServeHTTP(rec, req)
+
+	for name, expected := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://de.example.org",
+		"Access-Control-Allow-Methods": "POST, OPTIONS",
+		"Access-Control-Allow-Headers": "Accept, Content-Type, Idempotency-Key, If-None-Match, Prefer",
+	} {
+		if value := rec.Header().Get(name); value != expected {
+			t.Errorf("%s was %q, not %q", name, value, expected)
+		}
+	}
+
+	varied := false
+	for _, v := range rec.Header()["Vary"] {
+		varied = varied || v == "Origin"
+	}
+	if !varied {
+		t.Errorf("Vary was %q", rec.Header()["Vary"])
+	}
+}
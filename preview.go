@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultPreviewLimit is the number of paths listed by the upload preview
+	// when the request doesn't include a limit.
+	defaultPreviewLimit = 1000
+
+	// maxPreviewLimit is the largest page size the upload preview will honor.
+	maxPreviewLimit = 10000
+)
+
+var errStopWalk = errors.New("stop walking")
+
+// parsePagination returns the offset and limit query parameters of the request.
+// The limit defaults to defaultLimit and is capped at maxLimit. An error is
+// returned if either value is present but isn't a non-negative integer.
+func parsePagination(req *http.Request, defaultLimit, maxLimit int) (int, int, error) {
+	var err error
+
+	offset := 0
+	limit := defaultLimit
+	query := req.URL.Query()
+
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer: %q", v)
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return 0, 0, fmt.Errorf("limit must be a non-negative integer: %q", v)
+		}
+	}
+
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	return offset, limit, nil
+}
+
+// excludes is a list of patterns read from a porklock excludes file.
+type excludes []string
+
+// readExcludes reads the patterns from the excludes file, one per line. Blank
+// lines are ignored. A missing excludes file is treated as an empty list.
+func readExcludes(excludesPath string) (excludes, error) {
+	f, err := os.Open(excludesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to open excludes file %s", excludesPath)
+	}
+	defer f.Close()
+
+	var retval excludes
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			retval = append(retval, line)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read excludes file %s", excludesPath)
+	}
+
+	return retval, nil
+}
+
+// matches returns true if the file at fullPath (relPath relative to the upload
+// source) is excluded. Patterns match the full path, the relative path, or the
+// base name of the file, either exactly, as a glob, or as a parent directory.
+func (e excludes) matches(fullPath, relPath string) bool {
+	for _, pattern := range e {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if pattern == "" {
+			continue
+		}
+
+		for _, candidate := range []string{fullPath, relPath, filepath.Base(relPath)} {
+			if candidate == pattern || strings.HasPrefix(candidate, pattern+"/") {
+				return true
+			}
+			if ok, _ := filepath.Match(pattern, candidate); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// walkUploadSource calls fn with the path, relative to source, of every regular
+// file that would be uploaded from source once the excludes are applied. Walking
+// stops early if fn returns errStopWalk.
+func walkUploadSource(source string, ex excludes, fn func(relPath string) error) error {
+	err := filepath.Walk(source, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fullPath == source {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(source, fullPath)
+		if err != nil {
+			return err
+		}
+
+		if ex.matches(fullPath, relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		return fn(relPath)
+	})
+
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+// UploadPreview streams the list of files that an upload would transfer, one
+// relative path per line. The listing is paginated with the offset and limit
+// query parameters; a page with fewer than limit lines is the last one.
+func (a *App) UploadPreview(writer http.ResponseWriter, req *http.Request) {
+	offset, limit, err := parsePagination(req, defaultPreviewLimit, maxPreviewLimit)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ex, err := readExcludes(a.ExcludesPath)
+	if err != nil {
+		log.Error(err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	source, err := a.userDirectory(a.DownloadDestination)
+	if err != nil {
+		log.Error(err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	flusher, _ := writer.(http.Flusher)
+
+	seen := 0
+	written := 0
+	err = walkUploadSource(source, ex, func(relPath string) error {
+		if written >= limit {
+			return errStopWalk
+		}
+
+		seen++
+		if seen <= offset {
+			return nil
+		}
+
+		if _, err := fmt.Fprintln(writer, relPath); err != nil {
+			return err
+		}
+		written++
+
+		if flusher != nil && written%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.Error(errors.Wrapf(err, "error listing upload source %s", source))
+		if written == 0 {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func previewFixture(t *testing.T) *App {
+	dir, err := ioutil.TempDir("", "preview")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := filepath.Join(dir, "source")
+	files := []string{
+		"a.txt",
+		"b.tmp",
+		"keep/c.txt",
+		"scratch/d.txt",
+		"scratch/nested/e.txt",
+	}
+	for _, f := range files {
+		p := filepath.Join(source, f)
+		if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	excludesPath := filepath.Join(dir, "excludes")
+	if err = ioutil.WriteFile(excludesPath, []byte("*.tmp\nscratch/\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return &App{
+		DownloadDestination: source,
+		ExcludesPath:        excludesPath,
+	}
+}
+
+func previewLines(t *testing.T, app *App, query string) []string {
+	req := httptest.NewRequest(http.MethodGet, "/upload/preview"+query, nil)
+	rec := httptest.NewRecorder()
+	app.UploadPreview(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status was %d, not %d", rec.Code, http.StatusOK)
+	}
+
+	body := strings.TrimSpace(rec.Body.String())
+	if body == "" {
+		return nil
+	}
+	return strings.Split(body, "\n")
+}
+
+func TestUploadPreview(t *testing.T) {
+	app := previewFixture(t)
+	defer os.RemoveAll(filepath.Dir(app.DownloadDestination))
+
+	lines := previewLines(t, app, "")
+	expected := []string{"a.txt", "keep/c.txt"}
+
+	if len(lines) != len(expected) {
+		t.Fatalf("lines were %v, not %v", lines, expected)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("line %d was %q, not %q", i, lines[i], expected[i])
+		}
+	}
+}
+
+func TestUploadPreviewPagination(t *testing.T) {
+	app := previewFixture(t)
+	defer os.RemoveAll(filepath.Dir(app.DownloadDestination))
+
+	lines := previewLines(t, app, "?limit=1")
+	if len(lines) != 1 || lines[0] != "a.txt" {
+		t.Errorf("first page was %v", lines)
+	}
+
+	lines = previewLines(t, app, "?limit=1&offset=1")
+	if len(lines) != 1 || lines[0] != "keep/c.txt" {
+		t.Errorf("second page was %v", lines)
+	}
+
+	lines = previewLines(t, app, "?limit=1&offset=2")
+	if len(lines) != 0 {
+		t.Errorf("third page was %v", lines)
+	}
+}
+
+func TestUploadPreviewBadPagination(t *testing.T) {
+	app := previewFixture(t)
+	defer os.RemoveAll(filepath.Dir(app.DownloadDestination))
+
+	for _, query := range []string{"?limit=-1", "?offset=abc"} {
+		req := httptest.NewRequest(http.MethodGet, "/upload/preview"+query, nil)
+		rec := httptest.NewRecorder()
+		app.UploadPreview(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status for %s was %d, not %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "time"
+
+// estimateProgress returns the percentage of the record's files that have been
+// transferred and an estimate of the seconds left, assuming the remaining
+// files take as long on average as the ones transferred since porklock started.
+// The time spent waiting in the queue doesn't count.
+// Either is nil when it isn't known yet: the percentage needs the total number
+// of files, and the estimate also needs at least one file to have been
+// transferred. Neither is given for transfers that aren't running, or for
+// transfers without a progress source, which only chunked downloads have.
+func (r *TransferRecord) estimateProgress(now time.Time) (percent, remaining *float64) {
+	if r.Status != DownloadingStatus && r.Status != UploadingStatus {
+		return nil, nil
+	}
+	if r.FilesTotal <= 0 {
+		return nil, nil
+	}
+
+	transferred := r.FilesTransferred
+	if transferred > r.FilesTotal {
+		transferred = r.FilesTotal
+	}
+
+	p := float64(transferred) / float64(r.FilesTotal) * 100
+	percent = &p
+
+	elapsed := now.Sub(r.runStart).Seconds()
+	if transferred <= 0 || r.runStart.IsZero() || elapsed <= 0 {
+		return percent, nil
+	}
+
+	left := elapsed / float64(transferred) * float64(r.FilesTotal-transferred)
+	return percent, &left
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEstimateProgress(t *testing.T) {
+	r := NewDownloadRecord()
+	r.StartTime = time.Now().Add(-time.Hour)
+	r.SetRunning(DownloadingStatus)
+	r.runStart = time.Now().Add(-10 * time.Second)
+	r.SetProgress(1, 4)
+
+	recordJSON, err := r.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed map[string]interface{}
+	if err = json.Unmarshal(recordJSON, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed["percent_complete"] != 25.0 {
+		t.Errorf("percent_complete was %v, not 25", parsed["percent_complete"])
+	}
+
+	remaining, ok := parsed["estimated_seconds_remaining"].(float64)
+	if !ok {
+		t.Fatalf("estimated_seconds_remaining was %v", parsed["estimated_seconds_remaining"])
+	}
+	// The hour spent in the queue doesn't count towards the estimate.
+	if math.Abs(remaining-30) > 1 {
+		t.Errorf("estimated_seconds_remaining was %.2f, not about 30", remaining)
+	}
+}
+
+func TestEstimateProgressUnknown(t *testing.T) {
+	started := time.Now().Add(-10 * time.Second)
+	now := time.Now()
+
+	cases := []struct {
+		name               string
+		status             string
+		transferred, total int
+		percent            bool
+		remaining          bool
+	}{
+		{"no total", DownloadingStatus, 0, 0, false, false},
+		{"nothing transferred", DownloadingStatus, 0, 4, true, false},
+		{"not running", RequestedStatus, 1, 4, false, false},
+		{"finished", CompletedStatus, 4, 4, false, false},
+		{"more than total", UploadingStatus, 5, 4, true, true},
+	}
+
+	for _, c := range cases {
+		r := &TransferRecord{
+			runStart:         started,
+			Status:           c.status,
+			FilesTransferred: c.transferred,
+			FilesTotal:       c.total,
+		}
+		percent, remaining := r.estimateProgress(now)
+		if (percent != nil) != c.percent {
+			t.Errorf("%s: percent was %v", c.name, percent)
+		}
+		if (remaining != nil) != c.remaining {
+			t.Errorf("%s: remaining was %v", c.name, remaining)
+		}
+		if percent != nil && *percent > 100 {
+			t.Errorf("%s: percent was %.2f", c.name, *percent)
+		}
+	}
+
+	// There's no estimate until porklock has started.
+	r := &TransferRecord{Status: DownloadingStatus, FilesTransferred: 1, FilesTotal: 4}
+	if _, remaining := r.estimateProgress(now); remaining != nil {
+		t.Errorf("a transfer that hadn't started had %.2f seconds remaining", *remaining)
+	}
+}
+
+func TestProgressOmittedWithoutSource(t *testing.T) {
+	app, cleanup := newTestApp(t, "exec sleep 10")
+	defer cleanup()
+	defer app.cancelTransfers()
+
+	download, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForRunning(t, app.downloadRecords, DownloadingStatus, 1)
+	waitForRunning(t, app.uploadRecords, UploadingStatus, 1)
+
+	for _, r := range []*TransferRecord{download, upload} {
+		recordJSON, err := r.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed map[string]interface{}
+		if err = json.Unmarshal(recordJSON, &parsed); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, field := range []string{"percent_complete", "estimated_seconds_remaining"} {
+			if value, ok := parsed[field]; ok {
+				t.Errorf("the running %s had %s %v", r.Kind, field, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// transferHeap is a container/heap of queued records, ordered by priority,
+// highest first, and then by start time, earliest first.
+type transferHeap []*TransferRecord
+
+func (h transferHeap) Len() int { return len(h) }
+
+func (h transferHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	return h[i].StartTime.Before(h[j].StartTime)
+}
+
+func (h transferHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *transferHeap) Push(x interface{}) {
+	*h = append(*h, x.(*TransferRecord))
+}
+
+func (h *transferHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	r := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return r
+}
+
+// transferSlots limits the number of transfers that run at once across every
+// queue that shares it. A nil transferSlots doesn't limit them.
+type transferSlots chan struct{}
+
+// newTransferSlots returns slots for up to n transfers, or nil if n is below
+// one.
+func newTransferSlots(n int) transferSlots {
+	if n < 1 {
+		return nil
+	}
+	return make(transferSlots, n)
+}
+
+// acquire blocks until a slot is free and takes it.
+func (s transferSlots) acquire() {
+	if s != nil {
+		s <- struct{}{}
+	}
+}
+
+// release frees a slot taken by acquire.
+func (s transferSlots) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// transferQueue runs transfers in priority order, with no more than a fixed
+// number of them running at once. Transfers with the same priority run in the
+// order they were requested. Worker goroutines are started as transfers are
+// queued and exit once the queue is empty. While the queue is paused, transfers
+// are queued but don't start. If the queue has slots, each transfer also needs
+// one of them to run, so queues sharing slots are limited together.
+type transferQueue struct {
+	maxWorkers int
+	run        func(*TransferRecord)
+	slots      transferSlots
+	pending    transferHeap
+	workers    int
+	active     int
+	paused     bool
+	wait       sync.WaitGroup
+	mutex      sync.Mutex
+}
+
+// newTransferQueue returns a queue that passes each queued record to run, with
+// up to maxWorkers calls to run in progress at once. A maxWorkers below one is
+// treated as one.
+func newTransferQueue(maxWorkers int, run func(*TransferRecord)) *transferQueue {
+	if maxWorkers < 1 {
+		maxWorkers = 1
+	}
+	return &transferQueue{
+		maxWorkers: maxWorkers,
+		run:        run,
+	}
+}
+
+// Enqueue adds the record to the queue.
+func (q *transferQueue) Enqueue(r *TransferRecord) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.push(r)
+}
+
+// EnqueueIfIdle adds the record to the queue if no other transfers are queued
+// or running. Returns false if the record wasn't queued.
+func (q *transferQueue) EnqueueIfIdle(r *TransferRecord) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.active > 0 {
+		return false
+	}
+
+	q.push(r)
+	return true
+}
+
+// push adds the record to the queue, starting a worker for it if fewer than
+// maxWorkers are running. The mutex must be held by the caller.
+func (q *transferQueue) push(r *TransferRecord) {
+	q.wait.Add(1)
+	q.active++
+	heap.Push(&q.pending, r)
+	q.startWorkers()
+}
+
+// startWorkers starts workers until maxWorkers are running, unless the queue
+// is paused or empty. Workers that find nothing to run exit straight away. The
+// mutex must be held by the caller.
+func (q *transferQueue) startWorkers() {
+	for !q.paused && len(q.pending) > 0 && q.workers < q.maxWorkers {
+		q.workers++
+		go q.work()
+	}
+}
+
+// idle returns true, counting the worker as exited, if the queue is paused or
+// empty. The mutex must be held by the caller.
+func (q *transferQueue) idle() bool {
+	if q.paused || len(q.pending) == 0 {
+		q.workers--
+		return true
+	}
+	return false
+}
+
+// work runs queued transfers until there aren't any left or the queue is
+// paused. Transfers stay queued while the worker waits for a slot, so that the
+// highest priority one runs once it has one.
+func (q *transferQueue) work() {
+	for {
+		q.mutex.Lock()
+		if q.idle() {
+			q.mutex.Unlock()
+			return
+		}
+		q.mutex.Unlock()
+
+		q.slots.acquire()
+
+		q.mutex.Lock()
+		if q.idle() {
+			q.mutex.Unlock()
+			q.slots.release()
+			return
+		}
+		r := heap.Pop(&q.pending).(*TransferRecord)
+		q.mutex.Unlock()
+
+		q.runRecovered(r)
+		q.slots.release()
+
+		q.mutex.Lock()
+		q.active--
+		q.mutex.Unlock()
+		q.wait.Done()
+	}
+}
+
+// runRecovered passes the record to the queue's run function. If that panics,
+// a record that hasn't reached a terminal status is marked as failed, and one
+// that hasn't finished is finished, so that it isn't left looking like it's
+// still running. The worker then carries on with the rest of the queue.
+// Transfers that recover from their own panics, as recoverTransfer does, are
+// left as they are.
+func (q *transferQueue) runRecovered(r *TransferRecord) {
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+
+		if isTerminalStatus(r.CurrentStatus()) {
+			log.WithField("stack", string(debug.Stack())).Errorf("recovered from a panic after %s %s finished: %v", r.Kind, r.UUID, p)
+		} else {
+			failPanicked(r, p)
+		}
+
+		select {
+		case <-r.Done():
+		default:
+			r.SetCompletionTime()
+		}
+		r.Cancel()
+	}()
+
+	q.run(r)
+}
+
+// RemovePending removes the transfers that haven't started from the queue and
+// returns them, in the order they would have run. Running transfers aren't
+// affected.
+func (q *transferQueue) RemovePending() []*TransferRecord {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var removed []*TransferRecord
+	for len(q.pending) > 0 {
+		removed = append(removed, heap.Pop(&q.pending).(*TransferRecord))
+		q.active--
+		q.wait.Done()
+	}
+	return removed
+}
+
+// SetPaused pauses or resumes the queue. Pausing stops queued transfers from
+// starting, but transfers that are already running carry on. Resuming starts
+// the queued transfers again.
+func (q *transferQueue) SetPaused(paused bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.paused = paused
+	q.startWorkers()
+}
+
+// Paused returns true if the queue is paused.
+func (q *transferQueue) Paused() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.paused
+}
+
+// Pending returns the number of queued transfers that haven't started.
+func (q *transferQueue) Pending() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.pending)
+}
+
+// Wait blocks until every queued transfer has finished.
+func (q *transferQueue) Wait() {
+	q.wait.Wait()
+}
+
+// transferParams contains the settings for a single transfer. They're set when
+// the record is created and don't change afterwards.
+type transferParams struct {
+	pathList     string
+	destination  string
+	excludes     []string
+	configPath   string
+	metadataFile string
+	tempFiles    []string
+	timeout      time.Duration
+	ctx          context.Context
+	cancel       context.CancelFunc
+}
+
+// removeTempFiles removes temporary files created for a transfer once it has
+// finished with them.
+func removeTempFiles(paths []string) {
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Warn(errors.Wrapf(err, "failed to remove temporary file %s", p))
+		}
+	}
+}
+
+// queue returns the queue that transfers of the kind run from. The queues are
+// created the first time one of them is needed, sharing slots for the
+// configured total number of transfers.
+func (a *App) queue(kind string) *transferQueue {
+	a.queuesOnce.Do(func() {
+		slots := newTransferSlots(a.MaxTotalTransfers)
+		a.downloads = newTransferQueue(a.MaxConcurrentDownloads, a.runDownload)
+		a.downloads.slots = slots
+		a.uploads = newTransferQueue(a.MaxConcurrentUploads, a.runUpload)
+		a.uploads.slots = slots
+	})
+
+	if kind == UploadKind {
+		return a.uploads
+	}
+	return a.downloads
+}
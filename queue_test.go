@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransferQueueConcurrencyLimit(t *testing.T) {
+	var (
+		mutex         sync.Mutex
+		running, peak int
+	)
+
+	q := newTransferQueue(2, func(r *TransferRecord) {
+		mutex.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+	})
+
+	for i := 0; i < 5; i++ {
+		q.Enqueue(NewDownloadRecord())
+	}
+
+	if q.EnqueueIfIdle(NewDownloadRecord()) {
+		t.Error("a record was queued while the queue was busy")
+	}
+
+	q.Wait()
+
+	if peak != 2 {
+		t.Errorf("%d transfers ran at once, not 2", peak)
+	}
+
+	if !q.EnqueueIfIdle(NewDownloadRecord()) {
+		t.Error("a record wasn't queued while the queue was idle")
+	}
+	q.Wait()
+}
+
+func TestTransferQueuePriority(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		order []*TransferRecord
+	)
+
+	first := NewDownloadRecord()
+	started := make(chan struct{})
+	gate := make(chan struct{})
+	q := newTransferQueue(1, func(r *TransferRecord) {
+		mutex.Lock()
+		order = append(order, r)
+		mutex.Unlock()
+
+		if r == first {
+			close(started)
+			<-gate
+		}
+	})
+
+	// The first transfer occupies the only worker while the rest are queued.
+	q.Enqueue(first)
+	<-started
+
+	low := NewDownloadRecord()
+	q.Enqueue(low)
+
+	high := NewDownloadRecord()
+	high.Priority = 10
+	q.Enqueue(high)
+
+	earlierTie := NewDownloadRecord()
+	earlierTie.Priority = 5
+	laterTie := NewDownloadRecord()
+	laterTie.Priority = 5
+	laterTie.StartTime = earlierTie.StartTime.Add(time.Second)
+	q.Enqueue(laterTie)
+	q.Enqueue(earlierTie)
+
+	close(gate)
+	q.Wait()
+
+	expected := []*TransferRecord{first, high, earlierTie, laterTie, low}
+	for i, r := range expected {
+		if order[i] != r {
+			t.Errorf("transfer %d had priority %d, expected the one with priority %d", i, order[i].Priority, r.Priority)
+		}
+	}
+}
+
+func TestTransferQueueRecoversPanics(t *testing.T) {
+	q := newTransferQueue(1, func(r *TransferRecord) {
+		r.SetRunning(DownloadingStatus)
+		if r.Priority == 1 {
+			var missing map[string]*TransferRecord
+			missing["x"].SetStatus(CompletedStatus)
+		}
+		r.SetStatus(CompletedStatus)
+		r.SetCompletionTime()
+	})
+
+	panicking := NewDownloadRecord()
+	panicking.Priority = 1
+	q.Enqueue(panicking)
+	q.Wait()
+
+	select {
+	case <-panicking.Done():
+	default:
+		t.Error("the record that panicked wasn't finished")
+	}
+	if s := panicking.Snapshot(); s.Status != FailedStatus || !strings.Contains(s.ErrorMessage, "nil pointer") {
+		t.Errorf("the record that panicked had status %s and error %q", s.Status, s.ErrorMessage)
+	}
+
+	// The queue is idle once the panic has been recovered from.
+	next := NewDownloadRecord()
+	if !q.EnqueueIfIdle(next) {
+		t.Fatal("the queue was still busy after the panic")
+	}
+	q.Wait()
+	if status := next.CurrentStatus(); status != CompletedStatus {
+		t.Errorf("the record after the panic had status %s", status)
+	}
+}
+
+// panickingAuditSink is an AuditSink that panics on one kind of event.
+type panickingAuditSink struct {
+	event string
+}
+
+func (s *panickingAuditSink) Audit(event *AuditEvent) error {
+	if event.Event == s.event {
+		panic("audit sink exploded on " + event.Event)
+	}
+	return nil
+}
+
+func TestTransferPanicsDontBlockQueue(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	// A panic while the transfer runs fails it.
+	app.auditor = &panickingAuditSink{event: AuditStarted}
+	record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, record); status != FailedStatus {
+		t.Errorf("the download that panicked had status %s", status)
+	}
+	if msg := record.Snapshot().ErrorMessage; !strings.Contains(msg, "audit sink exploded on started") {
+		t.Errorf("the error message was %q", msg)
+	}
+	app.queue(DownloadKind).Wait()
+
+	// A panic after the transfer has finished leaves its status alone.
+	app.auditor = &panickingAuditSink{event: CompletedStatus}
+	record, err = app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatalf("the queue was still busy after the panic: %s", err)
+	}
+	if status := waitForStatus(t, record); status != CompletedStatus {
+		t.Errorf("the download that panicked after finishing had status %s", status)
+	}
+	app.queue(DownloadKind).Wait()
+
+	app.auditor = nil
+	record, err = app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatalf("the queue was still busy after the panic: %s", err)
+	}
+	if status := waitForStatus(t, record); status != CompletedStatus {
+		t.Errorf("the download after the panics had status %s", status)
+	}
+}
+
+func TestMaxTotalTransfers(t *testing.T) {
+	// Each run of porklock fails if another one is running, so both transfers
+	// only complete if they run one after the other.
+	app, cleanup := newTestApp(t, `lock="$(dirname "$0")/running"
+mkdir "$lock" || exit 1
+sleep 0.2
+rmdir "$lock"`)
+	defer cleanup()
+
+	app.MaxTotalTransfers = 1
+	app.MaxConcurrentDownloads = 2
+	app.MaxConcurrentUploads = 2
+
+	download, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := waitForStatus(t, download); status != CompletedStatus {
+		t.Errorf("the download had status %s", status)
+	}
+	if status := waitForStatus(t, upload); status != CompletedStatus {
+		t.Errorf("the upload had status %s", status)
+	}
+}
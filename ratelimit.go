@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+// maxRetryAfter caps the Retry-After header sent with rate limited responses.
+const maxRetryAfter = time.Hour
+
+// newLimiter returns a token bucket rate limiter that allows perSecond requests
+// per second, with bursts of up to one second's worth of requests. Returns nil,
+// meaning unlimited, if perSecond isn't positive.
+func newLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), int(math.Max(1, math.Ceil(perSecond))))
+}
+
+// rateLimit wraps the handler so that requests beyond the limiter's rate are
+// rejected with a 429 and a Retry-After header. A nil limiter allows every
+// request through.
+func rateLimit(limiter *rate.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return next
+	}
+
+	return func(writer http.ResponseWriter, req *http.Request) {
+		reservation := limiter.Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+
+			if delay > maxRetryAfter {
+				delay = maxRetryAfter
+			}
+
+			writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeJSONError(writer, http.StatusTooManyRequests, errors.New("rate limit exceeded"))
+			return
+		}
+
+		next(writer, req)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	var calls int
+	handler := rateLimit(newLimiter(2), func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	})
+
+	codes := make([]int, 0)
+	var retryAfter string
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/download", nil))
+		codes = append(codes, rec.Code)
+		if rec.Code == http.StatusTooManyRequests {
+			retryAfter = rec.Header().Get("Retry-After")
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("handler was called %d times, not 2: %v", calls, codes)
+	}
+
+	if codes[len(codes)-1] != http.StatusTooManyRequests {
+		t.Errorf("final request was not rate limited: %v", codes)
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err != nil || seconds < 1 {
+		t.Errorf("Retry-After was %q", retryAfter)
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	if newLimiter(0) != nil {
+		t.Error("a zero rate limit should disable rate limiting")
+	}
+
+	var calls int
+	handler := rateLimit(nil, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	})
+
+	for i := 0; i < 10; i++ {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/download", nil))
+	}
+
+	if calls != 10 {
+		t.Errorf("handler was called %d times, not 10", calls)
+	}
+}
+
+func TestRateLimitOnlyTransferEndpoints(t *testing.T) {
+	app := &App{
+		RateLimit:       1,
+		GzipMinSize:     1024,
+		downloadRecords: &HistoricalRecords{},
+		uploadRecords:   &HistoricalRecords{},
+	}
+	router := app.newRouter()
+
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/download/unknown", nil))
+		if rec.Code == http.StatusTooManyRequests {
+			t.Fatal("status reads were rate limited")
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader is the header that clients and proxies may use to identify a
+// request in the logs.
+const requestIDHeader = "X-Request-Id"
+
+// requestID returns the ID of the request for the logs, which is the value of
+// the X-Request-Id header if there is one and otherwise the ID of the request's
+// trace. It's empty if the request has neither.
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+	if sc := trace.SpanContextFromContext(req.Context()); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// recoverPanics wraps handlers so that a panic while handling a request is
+// logged with the request ID and the stack trace, and the client gets a 500,
+// instead of the panic taking down the service and the transfers it's running.
+// http.ErrAbortHandler is passed on, since it's used to abort a response on
+// purpose. Panics in the transfers themselves happen outside of the handlers;
+// those are handled by recoverTransfer.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			log.WithFields(logrus.Fields{
+				"request_id": requestID(req),
+				"method":     req.Method,
+				"path":       req.URL.Path,
+				"stack":      string(debug.Stack()),
+			}).Errorf("recovered from a panic while handling %s %s: %v", req.Method, req.URL.Path, p)
+
+			writeJSONError(writer, http.StatusInternalServerError, errors.New("internal server error"))
+		}()
+
+		next.ServeHTTP(writer, req)
+	})
+}
+
+// recoverTransfer marks the record as failed if running the transfer panicked,
+// logging the panic with its stack trace, so that the queue carries on with
+// the other transfers. It must be deferred by the function that runs the
+// transfer, after the deferred calls that finish the record so that the
+// failure is recorded before them.
+func recoverTransfer(r *TransferRecord) {
+	if p := recover(); p != nil {
+		failPanicked(r, p)
+	}
+}
+
+// failPanicked logs the panic that happened while running the transfer with
+// its stack trace, and marks the record as failed with the recovered value in
+// its error message. It must be called from the deferred function that
+// recovered from the panic for the stack trace to include where it happened.
+func failPanicked(r *TransferRecord, p interface{}) {
+	log.WithField("stack", string(debug.Stack())).Errorf("recovered from a panic while running %s %s: %v", r.Kind, r.UUID, p)
+	r.SetFailed(fmt.Errorf("the %s failed unexpectedly: %v", r.Kind, p))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	router := app.newRouter()
+	router.HandleFunc("/panic", func(http.ResponseWriter, *http.Request) {
+		var r *TransferRecord
+		r.SetStatus(FailedStatus)
+	}).Methods(http.MethodGet)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/panic", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(requestIDHeader, "test-request")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("a panicking handler returned %d", resp.StatusCode)
+	}
+	var decoded map[string]string
+	if err = json.Unmarshal(body, &decoded); err != nil || decoded["error"] == "" {
+		t.Errorf("the response wasn't a JSON error: %s", body)
+	}
+
+	resp, err = http.Get(server.URL + "/livez")
+	if err != nil {
+		t.Fatalf("the server went down after the panic: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("livez returned %d after the panic", resp.StatusCode)
+	}
+}
+
+func TestRecoverTransfer(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	app.auditor = &panickingAuditSink{event: AuditStarted}
+
+	record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, record); status != FailedStatus {
+		t.Errorf("the download that panicked had status %s", status)
+	}
+	if msg := record.Snapshot().ErrorMessage; !strings.Contains(msg, "audit sink exploded") {
+		t.Errorf("the error message was %q", msg)
+	}
+	if record.Snapshot().CompletionTime.IsZero() {
+		t.Error("the download that panicked wasn't given a completion time")
+	}
+
+	// The queue carries on with the next transfer.
+	app.auditor = nil
+	app.queue(DownloadKind).Wait()
+	record, err = app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, record); status != CompletedStatus {
+		t.Errorf("the download after the panic had status %s", status)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// textSummarizer is implemented by the values that can be rendered as a plain
+// text summary.
+type textSummarizer interface {
+	TextSummary() string
+}
+
+// acceptQuality returns the quality that the request's Accept header gives the
+// media type, using the most specific of the ranges that match it. A type that
+// isn't matched by any of the ranges has a quality of zero.
+func acceptQuality(req *http.Request, mediaType string) float64 {
+	mainType := strings.SplitN(mediaType, "/", 2)[0]
+
+	quality, specificity := 0.0, -1
+	for _, header := range req.Header["Accept"] {
+		for _, part := range strings.Split(header, ",") {
+			fields := strings.Split(part, ";")
+
+			var s int
+			switch r := strings.ToLower(strings.TrimSpace(fields[0])); r {
+			case mediaType:
+				s = 2
+			case mainType + "/*":
+				s = 1
+			case "*/*":
+				s = 0
+			default:
+				continue
+			}
+
+			if s < specificity {
+				continue
+			}
+
+			q := 1.0
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); strings.HasPrefix(param, "q=") && err == nil {
+					q = v
+				}
+			}
+			quality, specificity = q, s
+		}
+	}
+	return quality
+}
+
+// wantsText returns true if the request's Accept header prefers text/plain to
+// application/json. Requests without an Accept header get JSON.
+func wantsText(req *http.Request) bool {
+	return acceptQuality(req, "text/plain") > acceptQuality(req, "application/json")
+}
+
+// render writes the value out with the status code, as a plain text summary if
+// the request prefers one and the value has one, or as JSON otherwise.
+func render(writer http.ResponseWriter, req *http.Request, status int, v interface{}) {
+	if s, ok := v.(textSummarizer); ok && wantsText(req) {
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.WriteHeader(status)
+		fmt.Fprint(writer, s.TextSummary())
+		return
+	}
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Error(errors.Wrap(err, "error serializing the response"))
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(body)
+}
+
+// TextSummary returns a line with the record's UUID, kind, status, and
+// duration. The duration of a transfer that hasn't finished is the time it has
+// taken so far.
+func (r *TransferRecord) TextSummary() string {
+	snapshot := r.Snapshot()
+
+	end := snapshot.CompletionTime
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	return fmt.Sprintf("%s %s %s %s\n", snapshot.UUID, snapshot.Kind, snapshot.Status, end.Sub(snapshot.StartTime).Round(time.Millisecond))
+}
+
+// transferRecords is a list of records that's rendered as a JSON array or as a
+// line per record.
+type transferRecords []*TransferRecord
+
+// TextSummary returns the summaries of the records, one per line.
+func (t transferRecords) TextSummary() string {
+	var b strings.Builder
+	for _, r := range t {
+		b.WriteString(r.TextSummary())
+	}
+	return b.String()
+}
+
+// TextSummary returns a line with the batch's ID and aggregate status followed
+// by the summaries of its records.
+func (b *BatchStatus) TextSummary() string {
+	return fmt.Sprintf("batch %s %s\n", b.BatchID, b.Status) + transferRecords(b.Records).TextSummary()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWantsText(t *testing.T) {
+	for accept, expected := range map[string]bool{
+		"":                                   false,
+		"*/*":                                false,
+		"application/json":                   false,
+		"text/plain":                         true,
+		"text/*":                             true,
+		"text/plain, application/json":       false,
+		"text/plain, application/json;q=0.5": true,
+		"text/plain;q=0.2, */*":              false,
+		"text/html, */*;q=0.1":               false,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		if wantsText(req) != expected {
+			t.Errorf("wantsText for %q was %t", accept, !expected)
+		}
+	}
+}
+
+func TestRecordRepresentations(t *testing.T) {
+	start := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	record := NewDownloadRecord()
+	record.StartTime = start
+	record.CompletionTime = start.Add(1500 * time.Millisecond)
+	record.Status = CompletedStatus
+
+	app := &App{downloadRecords: &HistoricalRecords{}}
+	app.downloadRecords.Append(record)
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/download/"+record.UUID.String(), nil)
+		req = mux.SetURLVars(req, map[string]string{"id": record.UUID.String()})
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		app.GetDownloadStatus(rec, req)
+		return rec
+	}
+
+	for _, accept := range []string{"", "*/*", "application/json"} {
+		rec := get(accept)
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type for %q was %q", accept, ct)
+		}
+
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("response for %q wasn't JSON: %s", accept, err)
+		}
+		if body["uuid"] != record.UUID.String() || body["status"] != CompletedStatus {
+			t.Errorf("unexpected JSON for %q: %v", accept, body)
+		}
+	}
+
+	rec := get("text/plain")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type for text/plain was %q", ct)
+	}
+
+	expected := record.UUID.String() + " download completed 1.5s\n"
+	if rec.Body.String() != expected {
+		t.Errorf("text summary was %q, not %q", rec.Body.String(), expected)
+	}
+}
+
+func TestBatchStatusText(t *testing.T) {
+	records := transferRecords{NewDownloadRecord(), NewDownloadRecord()}
+	status := &BatchStatus{BatchID: "batch-id", Status: RequestedStatus, Records: records}
+
+	lines := strings.Split(strings.TrimSuffix(status.TextSummary(), "\n"), "\n")
+	if len(lines) != 3 || lines[0] != "batch batch-id requested" {
+		t.Fatalf("unexpected summary %q", lines)
+	}
+
+	for i, r := range records {
+		if !strings.HasPrefix(lines[i+1], r.UUID.String()+" download requested ") {
+			t.Errorf("line %d was %q", i+1, lines[i+1])
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TransferRequest contains the optional settings that may be included in the
+// body of a transfer request. Excludes only apply to uploads. Transfers with a
+// higher Priority run before queued transfers with a lower one. Timeout
+// overrides the configured transfer timeout. MetadataFile overrides the
+// configured metadata file, and IRODSConfig the configured iRODS config file.
+// Force starts the transfer even if another transfer
+// of the same kind is queued or running, and may also be set with the force
+// query parameter. Path is only set from the path query parameter, and replaces
+// the input path list of a download with the single iRODS path.
+type TransferRequest struct {
+	Excludes     []string        `json:"excludes"`
+	Priority     int             `json:"priority"`
+	Timeout      requestDuration `json:"timeout"`
+	MetadataFile string          `json:"metadata_file"`
+	IRODSConfig  string          `json:"irods_config"`
+	Force        bool            `json:"force"`
+	Path         string          `json:"-"`
+}
+
+// pathParam returns the value of the request's path query parameter, which
+// must be a single absolute path if it's present. It returns an empty string if
+// the parameter isn't given.
+func pathParam(req *http.Request) (string, error) {
+	values, ok := req.URL.Query()["path"]
+	if !ok {
+		return "", nil
+	}
+
+	switch {
+	case len(values) > 1:
+		return "", errors.New("only one path may be given")
+	case values[0] == "":
+		return "", errors.New("the path must not be empty")
+	case !path.IsAbs(values[0]):
+		return "", fmt.Errorf("the path %s must be absolute", values[0])
+	}
+	return values[0], nil
+}
+
+// setForce sets the request's Force field if the request has a force query
+// parameter of true. A force parameter of false leaves the field alone, so it
+// doesn't undo a force given in the body.
+func setForce(req *http.Request, tr *TransferRequest) error {
+	switch force := req.URL.Query().Get("force"); force {
+	case "", "false":
+	case "true":
+		tr.Force = true
+	default:
+		return fmt.Errorf("invalid value for force: %q", force)
+	}
+	return nil
+}
+
+// requestDuration is a time.Duration that's given in JSON as a string in the
+// format accepted by time.ParseDuration, e.g. "90m".
+type requestDuration time.Duration
+
+// UnmarshalJSON parses the duration from a JSON string.
+func (d *requestDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("durations must be strings such as \"90m\"")
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = requestDuration(parsed)
+	return nil
+}
+
+// checkTimeout returns an error if the request's timeout isn't positive or is
+// longer than the maximum transfer timeout. Requests without a timeout are
+// fine.
+func (a *App) checkTimeout(tr *TransferRequest) error {
+	timeout := time.Duration(tr.Timeout)
+	switch {
+	case tr.Timeout == 0:
+		return nil
+	case timeout < 0:
+		return fmt.Errorf("the timeout %s must be positive", timeout)
+	case a.MaxTransferTimeout > 0 && timeout > a.MaxTransferTimeout:
+		return fmt.Errorf("the timeout %s is longer than the maximum of %s", timeout, a.MaxTransferTimeout)
+	}
+	return nil
+}
+
+// checkMetadataFile returns an error if the request's metadata file isn't an
+// absolute path. Requests without a metadata file are fine. Whether the file can
+// be read is checked when the transfer runs.
+func checkMetadataFile(tr *TransferRequest) error {
+	if tr.MetadataFile != "" && !filepath.IsAbs(tr.MetadataFile) {
+		return fmt.Errorf("the metadata file %s must be an absolute path", tr.MetadataFile)
+	}
+	return nil
+}
+
+// metadataFile returns the metadata file for the transfer, which is the one in
+// the request if there is one and the configured one otherwise.
+func (a *App) metadataFile(tr *TransferRequest) string {
+	if tr.MetadataFile != "" {
+		return tr.MetadataFile
+	}
+	return a.MetadataFile
+}
+
+// checkIRODSConfig returns an error if the request gives an iRODS config file
+// that can't be used. Requests may only give one if the iRODS config directory
+// is configured, and it must be a readable file in that directory. Symbolic
+// links are resolved before the file's location is checked, so that a link in
+// the directory can't be used to read a file outside of it.
+func (a *App) checkIRODSConfig(tr *TransferRequest) error {
+	if tr.IRODSConfig == "" {
+		return nil
+	}
+
+	if a.IRODSConfigDir == "" {
+		return errors.New("requests can't give an iRODS config because no iRODS config directory is configured")
+	}
+
+	if !filepath.IsAbs(tr.IRODSConfig) {
+		return fmt.Errorf("the iRODS config %s must be an absolute path", tr.IRODSConfig)
+	}
+
+	// The location is checked before the file is, so that requests can't
+	// find out whether files outside of the directory exist.
+	notInDir := fmt.Errorf("the iRODS config %s is not in the iRODS config directory %s", tr.IRODSConfig, a.IRODSConfigDir)
+	if !inDirectory(tr.IRODSConfig, a.IRODSConfigDir) {
+		return notInDir
+	}
+
+	if err := fileProblem("iRODS config", tr.IRODSConfig); err != nil {
+		return err
+	}
+
+	resolved, err := filepath.EvalSymlinks(tr.IRODSConfig)
+	if err != nil {
+		return errors.Wrapf(err, "the iRODS config %s is not usable", tr.IRODSConfig)
+	}
+	dir, err := filepath.EvalSymlinks(a.IRODSConfigDir)
+	if err != nil {
+		return errors.Wrapf(err, "the iRODS config directory %s is not usable", a.IRODSConfigDir)
+	}
+	if !inDirectory(resolved, dir) {
+		return notInDir
+	}
+	return nil
+}
+
+// inDirectory returns true if p is somewhere inside of dir, but isn't dir
+// itself.
+func inDirectory(p, dir string) bool {
+	return underPrefix(p, dir) && filepath.Clean(p) != filepath.Clean(dir)
+}
+
+// configPath returns the iRODS config file for the transfer, which is the one
+// in the request if there is one and the configured one otherwise.
+func (a *App) configPath(tr *TransferRequest) string {
+	if tr.IRODSConfig != "" {
+		return tr.IRODSConfig
+	}
+	return a.ConfigPath
+}
+
+// decodeTransferRequest parses the JSON body of the request. A request without
+// a body is treated as an empty TransferRequest.
+func decodeTransferRequest(req *http.Request) (*TransferRequest, error) {
+	tr := &TransferRequest{}
+	if req.Body == nil {
+		return tr, nil
+	}
+
+	if err := decodeJSON(req.Body, tr); err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "invalid request body")
+	}
+	return tr, nil
+}
+
+// decodeJSON decodes the single JSON value in body into v, rejecting fields
+// that v doesn't have so that misspelled fields aren't silently ignored.
+// Failures are described in terms of the problem with the JSON: where the
+// syntax error is, which field is unknown or has the wrong type, or that it was
+// cut short. An empty body returns io.EOF, and a body that was too long returns
+// an error whose cause is the *http.MaxBytesError.
+func decodeJSON(body io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err == nil {
+		if decoder.More() {
+			return errors.New("unexpected data after the JSON value")
+		}
+		return nil
+	}
+
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return fmt.Errorf("invalid JSON at offset %d: %s", e.Offset, strings.TrimPrefix(e.Error(), "json: "))
+	case *json.UnmarshalTypeError:
+		if e.Field == "" {
+			return fmt.Errorf("the JSON value must be %s, not %s", jsonKind(e.Type), e.Value)
+		}
+		return fmt.Errorf("the field %q must be %s, not %s", e.Field, jsonKind(e.Type), e.Value)
+	}
+
+	switch {
+	case err == io.EOF:
+		return err
+	case err == io.ErrUnexpectedEOF:
+		return errors.New("the JSON ends unexpectedly")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return fmt.Errorf("unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+	return err
+}
+
+// jsonKind describes the kind of JSON value that decodes into the Go type.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	}
+	return "a " + t.String()
+}
+
+// writeExcludesFile writes the exclude patterns to a new temporary file, one per
+// line, and returns its path. The caller is responsible for removing the file.
+func writeExcludesFile(patterns []string) (string, error) {
+	return writeLinesFile("excludes", "excludes file", patterns)
+}
+
+// writePathListFile writes the iRODS paths to a new temporary path list file,
+// one per line, and returns its path. The caller is responsible for removing
+// the file.
+func writePathListFile(paths []string) (string, error) {
+	return writeLinesFile("input-path-list", "path list file", paths)
+}
+
+// writeLinesFile writes the lines to a new temporary file whose name starts
+// with the prefix and returns its path. The description is used in errors.
+func writeLinesFile(prefix, description string, lines []string) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create the %s", description)
+	}
+	defer f.Close()
+
+	for _, line := range lines {
+		if _, err = fmt.Fprintln(f, line); err != nil {
+			os.Remove(f.Name())
+			return "", errors.Wrapf(err, "failed to write the %s %s", description, f.Name())
+		}
+	}
+
+	return f.Name(), nil
+}
+
+// writeJSONError writes the error to the writer as a JSON object with the
+// provided status code.
+func writeJSONError(writer http.ResponseWriter, status int, err error) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(map[string]string{"error": err.Error()})
+}
+
+// prefersAsync returns true if the request's Prefer header contains the
+// respond-async preference.
+func prefersAsync(req *http.Request) bool {
+	for _, header := range req.Header["Prefer"] {
+		for _, pref := range strings.Split(header, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wantsBlocking returns true if the request asks for the response to be delayed
+// until the transfer finishes. The precedence is:
+//
+//   - ?wait=true asks for a blocking request.
+//   - ?non-blocking, ?wait=false, and "Prefer: respond-async" ask for a
+//     non-blocking request.
+//   - Asking for both a blocking and a non-blocking request is an error.
+//   - A request that asks for neither is non-blocking.
+func wantsBlocking(req *http.Request) (bool, error) {
+	query := req.URL.Query()
+
+	var blocking, nonBlocking bool
+
+	switch wait := query.Get("wait"); wait {
+	case "":
+	case "true":
+		blocking = true
+	case "false":
+		nonBlocking = true
+	default:
+		return false, fmt.Errorf("invalid value for wait: %q", wait)
+	}
+
+	if _, ok := query[nonBlockingKey]; ok {
+		nonBlocking = true
+	}
+
+	if prefersAsync(req) {
+		nonBlocking = true
+	}
+
+	if blocking && nonBlocking {
+		return false, errors.New("wait=true can't be combined with non-blocking or Prefer: respond-async")
+	}
+
+	return blocking, nil
+}
+
+// writeTransferResponse responds to a request that created the record. The
+// response is a 202 with a Location header pointing at the transfer's status if
+// the transfer was started, or a 200 for a blocking request once the transfer
+// has finished. A transfer that wasn't started because another one is running
+// gets a 409. The record is included in the body in every case, except for
+// transfers to paths that aren't allowed, which get a 403 with a JSON error,
+// and transfers refused because the queue is full, which get a 503.
+func writeTransferResponse(writer http.ResponseWriter, req *http.Request, r *TransferRecord, startErr error, blocking bool) {
+	if isPathNotAllowed(startErr) {
+		writeJSONError(writer, http.StatusForbidden, startErr)
+		return
+	}
+
+	if isQueueFull(startErr) {
+		writeQueueFull(writer, startErr)
+		return
+	}
+
+	status := http.StatusAccepted
+
+	switch {
+	case startErr == errTransferRunning:
+		status = http.StatusConflict
+	case startErr != nil:
+		status = http.StatusOK
+	case blocking:
+		if !waitForRecord(req, r) {
+			return
+		}
+		status = http.StatusOK
+	}
+
+	if startErr == nil {
+		writer.Header().Set("Location", path.Join("/", r.Kind, r.UUID.String()))
+	}
+
+	render(writer, req, status, r)
+}
+
+// waitForRecord blocks until the transfer described by the record finishes.
+// Returns false if the client went away before that happened, in which case the
+// transfer is cancelled since nobody is waiting for it any longer.
+func waitForRecord(req *http.Request, r *TransferRecord) bool {
+	select {
+	case <-r.Done():
+		return true
+	case <-req.Context().Done():
+		r.Cancel()
+		return false
+	}
+}
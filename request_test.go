@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWantsBlocking(t *testing.T) {
+	for _, tc := range []struct {
+		target   string
+		prefer   string
+		blocking bool
+		invalid  bool
+	}{
+		{target: "/download"},
+		{target: "/download?non-blocking"},
+		{target: "/download?wait=false"},
+		{target: "/download?wait=false&non-blocking"},
+		{target: "/download", prefer: "respond-async"},
+		{target: "/download?non-blocking", prefer: "handling=lenient, respond-async"},
+		{target: "/download?wait=true", blocking: true},
+		{target: "/download?wait=true", prefer: "handling=strict", blocking: true},
+		{target: "/download?wait=true&non-blocking", invalid: true},
+		{target: "/download?wait=true", prefer: "respond-async", invalid: true},
+		{target: "/download?wait=soon", invalid: true},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.target, nil)
+		if tc.prefer != "" {
+			req.Header.Set("Prefer", tc.prefer)
+		}
+
+		blocking, err := wantsBlocking(req)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("expected an error for %s with Prefer %q", tc.target, tc.prefer)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error for %s with Prefer %q: %s", tc.target, tc.prefer, err)
+		}
+
+		if blocking != tc.blocking {
+			t.Errorf("blocking for %s with Prefer %q was %t, not %t", tc.target, tc.prefer, blocking, tc.blocking)
+		}
+	}
+}
+
+func TestDecodeMalformedBodies(t *testing.T) {
+	for _, tc := range []struct {
+		body    string
+		message string
+	}{
+		{`{"excludes": ["*.tmp"], "priorty": 2}`, `invalid request body: unknown field "priorty"`},
+		{`{"excludes": ["*.tmp"`, "invalid request body: the JSON ends unexpectedly"},
+		{`{"priority": 2,}`, "invalid request body: invalid JSON at offset 16: invalid character '}' looking for beginning of object key string"},
+		{`{"priority": "high"}`, `invalid request body: the field "priority" must be a number, not string`},
+		{`{"excludes": "*.tmp"}`, `invalid request body: the field "excludes" must be an array, not string`},
+		{`["*.tmp"]`, "invalid request body: the JSON value must be an object, not array"},
+		{`{"priority": 1} {"priority": 2}`, "invalid request body: unexpected data after the JSON value"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(tc.body))
+		_, err := decodeTransferRequest(req)
+		if err == nil {
+			t.Errorf("no error for %s", tc.body)
+			continue
+		}
+		if err.Error() != tc.message {
+			t.Errorf("the error for %s was %q, not %q", tc.body, err, tc.message)
+		}
+		if status := decodeErrorStatus(err); status != http.StatusBadRequest {
+			t.Errorf("the status for %s was %d", tc.body, status)
+		}
+	}
+}
+
+func TestMalformedBodyResponses(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	for name, tc := range map[string]struct {
+		handler http.HandlerFunc
+		body    string
+		message string
+	}{
+		"upload":   {app.UploadFilesHandler, `{"exclude": ["*.tmp"]}`, `unknown field "exclude"`},
+		"batch":    {app.BatchDownloadHandler, `[{"paths2": ["/iplant/home/test-user/a.txt"]}]`, `unknown field "paths2"`},
+		"statuses": {app.GetTransferStatuses, `[1, 2]`, `must be a string, not number`},
+	} {
+		rec, body := postTransfer(tc.handler, "/", nil, tc.body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d %v", name, rec.Code, body)
+		}
+		if msg, _ := body["error"].(string); !strings.Contains(msg, tc.message) {
+			t.Errorf("%s error was %q, which doesn't mention %q", name, msg, tc.message)
+		}
+	}
+}
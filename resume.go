@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// resumePathList returns the path to a path list containing the paths from the
+// one at pathList whose files aren't already in the destination, along with the
+// number of paths that were left out. Only regular files count as present, so
+// directories are always downloaded again. The original path list is returned
+// if nothing was left out, and an empty path if nothing is left to download.
+// Callers must remove any other path list that's returned.
+func resumePathList(pathList, destination string) (string, int, error) {
+	paths, err := readPathList(pathList)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var missing []string
+	skipped := 0
+	for _, p := range paths {
+		if info, err := os.Stat(filepath.Join(destination, path.Base(p))); err == nil && info.Mode().IsRegular() {
+			skipped++
+			continue
+		}
+		missing = append(missing, p)
+	}
+
+	if skipped == 0 {
+		return pathList, 0, nil
+	}
+	if len(missing) == 0 {
+		return "", skipped, nil
+	}
+
+	resumed, err := writePathListFile(missing)
+	if err != nil {
+		return "", 0, err
+	}
+	return resumed, skipped, nil
+}
+
+// readPathList returns the paths in the path list at pathList, leaving out
+// blank lines.
+func readPathList(pathList string) ([]string, error) {
+	f, err := os.Open(pathList)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open path list file %s", pathList)
+	}
+	defer f.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p := strings.TrimSpace(scanner.Text()); p != "" {
+			paths = append(paths, p)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read path list file %s", pathList)
+	}
+	return paths, nil
+}
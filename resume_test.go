@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeGet records the paths it's asked to download in the received file next
+// to the script and creates the downloaded files in the destination.
+const fakeGet = `dir=$(dirname "$0")
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--source-list) list=$2 ;;
+	--destination) dest=$2 ;;
+	esac
+	shift
+done
+while read -r p; do
+	echo "$p" >> "$dir/received"
+	touch "$dest/$(basename "$p")"
+done < "$list"`
+
+func runResumableDownload(t *testing.T, app *App) (*TransferRecord, []byte) {
+	received := filepath.Join(app.LogDirectory, "received")
+	os.Remove(received)
+
+	r, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, r)
+
+	if r.Status != CompletedStatus {
+		t.Fatalf("download finished with status %s: %s", r.Status, r.ErrorMessage)
+	}
+
+	paths, _ := ioutil.ReadFile(received)
+	return r, paths
+}
+
+func TestResumeDownload(t *testing.T) {
+	app, cleanup := newTestApp(t, fakeGet)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(app.InputPathList, []byte("/iplant/home/test-user/a.txt\n/iplant/home/test-user/b.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(app.DownloadDestination, "a.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, paths := runResumableDownload(t, app)
+	if string(paths) != "/iplant/home/test-user/a.txt\n/iplant/home/test-user/b.txt\n" {
+		t.Errorf("download without --resume fetched %q", paths)
+	}
+	if r.SkippedFiles != 0 {
+		t.Errorf("download without --resume skipped %d files", r.SkippedFiles)
+	}
+
+	app.Resume = true
+	os.Remove(filepath.Join(app.DownloadDestination, "b.txt"))
+
+	r, paths = runResumableDownload(t, app)
+	if string(paths) != "/iplant/home/test-user/b.txt\n" {
+		t.Errorf("resumed download fetched %q", paths)
+	}
+	if r.SkippedFiles != 1 {
+		t.Errorf("resumed download skipped %d files, not 1", r.SkippedFiles)
+	}
+
+	r, paths = runResumableDownload(t, app)
+	if len(paths) != 0 {
+		t.Errorf("download with every file present fetched %q", paths)
+	}
+	if r.SkippedFiles != 2 {
+		t.Errorf("download with every file present skipped %d files, not 2", r.SkippedFiles)
+	}
+}
+
+func TestResumePathListKeepsOriginal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pathList := filepath.Join(dir, "input-path-list")
+	if err = ioutil.WriteFile(pathList, []byte("/iplant/home/test-user/dir\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(filepath.Join(dir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, skipped, err := resumePathList(pathList, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed != pathList || skipped != 0 {
+		t.Errorf("an existing directory was skipped: %s, %d", resumed, skipped)
+	}
+}
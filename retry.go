@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// retryableExit returns true if a failed porklock run should be retried. Every
+// failure is retryable when no retry exit codes are configured. Otherwise only
+// runs that exited with one of the codes are, and failures to run porklock at
+// all aren't.
+func (a *App) retryableExit(err error) bool {
+	if len(a.RetryExitCodes) == 0 {
+		return true
+	}
+
+	exitErr, ok := errors.Cause(err).(*exec.ExitError)
+	if !ok {
+		return false
+	}
+
+	for _, code := range a.RetryExitCodes {
+		if exitErr.ExitCode() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// runPorklock runs the porklock command for the record with its output going to
+// the logs. Failed runs are retried up to the configured number of times, after
+// waiting for the retry delay, as long as the failure is retryable and the
+// context isn't done. The error from the last run is returned, or the error
+// from looking up porklock if it can't be found.
+func (a *App) runPorklock(ctx context.Context, r *TransferRecord, parts []string, logs *transferLogs) error {
+	r.SetCommand(parts)
+
+	for attempt := 1; ; attempt++ {
+		r.SetAttempts(attempt)
+
+		cmd, err := a.newPorklockCommand(ctx, parts)
+		if err != nil {
+			return err
+		}
+		cmd.Stdout = logs.stdoutOutput
+		cmd.Stderr = logs.stderrOutput
+
+		err = cmd.Run()
+		r.SetProcessState(cmd.ProcessState)
+		if err == nil || attempt > a.TransferRetries || ctx.Err() != nil {
+			return err
+		}
+
+		if !a.retryableExit(err) {
+			log.Warnf("not retrying %s %s, its failure isn't retryable: %s", r.Kind, r.UUID, err)
+			return err
+		}
+
+		log.Warnf("retrying %s %s in %s after attempt %d failed: %s", r.Kind, r.UUID, a.RetryDelay, attempt, err)
+		select {
+		case <-time.After(a.RetryDelay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// checkRetryExitCodes returns an error if any of the retry exit codes can't be
+// the exit code of a failed process.
+func (o *Options) checkRetryExitCodes() error {
+	for _, code := range o.RetryExitCodes {
+		if code < 1 || code > 255 {
+			return fmt.Errorf("the retry exit code %d must be between 1 and 255", code)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// failingScript exits with the code until it has been run the number of times,
+// then succeeds.
+func failingScript(code, failures int) string {
+	return fmt.Sprintf(`count="$(dirname "$0")/attempts"
+echo x >> "$count"
+[ "$(wc -l < "$count")" -gt %d ] || exit %d`, failures, code)
+}
+
+func runRetriedDownload(t *testing.T, app *App) *TransferRecord {
+	r, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, r)
+	return r
+}
+
+func TestRetryExitCodes(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		codes    []int
+		exitCode int
+		status   string
+		attempts int
+	}{
+		{"retryable", []int{75, 111}, 75, CompletedStatus, 3},
+		{"not retryable", []int{75, 111}, 13, FailedStatus, 1},
+		{"all retryable by default", nil, 13, CompletedStatus, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app, cleanup := newTestApp(t, failingScript(tc.exitCode, 2))
+			defer cleanup()
+
+			app.TransferRetries = 3
+			app.RetryDelay = 10 * time.Millisecond
+			app.RetryExitCodes = tc.codes
+
+			r := runRetriedDownload(t, app)
+			snapshot := r.Snapshot()
+			if snapshot.Status != tc.status || snapshot.Attempts != tc.attempts {
+				t.Errorf("download finished with status %s after %d attempts, not %s after %d", snapshot.Status, snapshot.Attempts, tc.status, tc.attempts)
+			}
+			if tc.status == FailedStatus && (snapshot.ExitCode == nil || *snapshot.ExitCode != tc.exitCode) {
+				t.Errorf("exit code was %v, not %d", snapshot.ExitCode, tc.exitCode)
+			}
+		})
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	app, cleanup := newTestApp(t, failingScript(75, 5))
+	defer cleanup()
+
+	app.TransferRetries = 2
+	app.RetryDelay = 10 * time.Millisecond
+
+	r := runRetriedDownload(t, app)
+	if snapshot := r.Snapshot(); snapshot.Status != FailedStatus || snapshot.Attempts != 3 {
+		t.Errorf("download finished with status %s after %d attempts", snapshot.Status, snapshot.Attempts)
+	}
+}
+
+func TestNoRetriesByDefault(t *testing.T) {
+	app, cleanup := newTestApp(t, failingScript(75, 1))
+	defer cleanup()
+
+	r := runRetriedDownload(t, app)
+	if snapshot := r.Snapshot(); snapshot.Status != FailedStatus || snapshot.Attempts != 1 {
+		t.Errorf("download finished with status %s after %d attempts", snapshot.Status, snapshot.Attempts)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+const (
+	// ringLines is the number of the most recent lines of porklock's stderr
+	// that are kept in memory for each transfer.
+	ringLines = 256
+
+	// maxRingLineBytes caps the length of the lines that are kept, so that
+	// output without line breaks can't use an unbounded amount of memory.
+	maxRingLineBytes = 4096
+)
+
+// lineRing is an io.Writer that keeps the last lines written to it in a fixed
+// size ring. Lines longer than maxRingLineBytes are truncated. It's safe for
+// concurrent use.
+type lineRing struct {
+	lines   []string
+	size    int
+	next    int
+	partial []byte
+	mutex   sync.Mutex
+}
+
+// newLineRing returns a lineRing that keeps the last size lines.
+func newLineRing(size int) *lineRing {
+	return &lineRing{size: size}
+}
+
+// Write adds the complete lines in p to the ring. A trailing partial line is
+// held until the rest of it is written.
+func (l *lineRing) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			l.appendPartial(p)
+			break
+		}
+
+		l.appendPartial(p[:i])
+		l.add(string(l.partial))
+		l.partial = l.partial[:0]
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// appendPartial adds as much of b to the partial line as fits.
+func (l *lineRing) appendPartial(b []byte) {
+	if room := maxRingLineBytes - len(l.partial); len(b) > room {
+		b = b[:room]
+	}
+	l.partial = append(l.partial, b...)
+}
+
+// add puts the line in the ring, replacing the oldest line once it's full.
+func (l *lineRing) add(line string) {
+	if len(l.lines) < l.size {
+		l.lines = append(l.lines, line)
+		return
+	}
+	l.lines[l.next] = line
+	l.next = (l.next + 1) % l.size
+}
+
+// Lines returns the lines in the ring, oldest first.
+func (l *lineRing) Lines() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	lines := make([]string, 0, len(l.lines))
+	lines = append(lines, l.lines[l.next:]...)
+	return append(lines, l.lines[:l.next]...)
+}
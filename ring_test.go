@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLineRingKeepsLastLines(t *testing.T) {
+	ring := newLineRing(ringLines)
+
+	for i := 0; i < ringLines+44; i++ {
+		fmt.Fprintf(ring, "line %d\n", i)
+	}
+
+	lines := ring.Lines()
+	if len(lines) != ringLines {
+		t.Fatalf("%d lines were kept, not %d", len(lines), ringLines)
+	}
+	for i, line := range lines {
+		if expected := fmt.Sprintf("line %d", i+44); line != expected {
+			t.Fatalf("line %d was %q, not %q", i, line, expected)
+		}
+	}
+}
+
+func TestLineRingPartialLines(t *testing.T) {
+	ring := newLineRing(3)
+
+	ring.Write([]byte("fir"))
+	ring.Write([]byte("st\nsec"))
+	if lines := ring.Lines(); len(lines) != 1 || lines[0] != "first" {
+		t.Errorf("lines were %q", lines)
+	}
+
+	ring.Write([]byte("ond\n" + strings.Repeat("x", 2*maxRingLineBytes) + "\n"))
+	lines := ring.Lines()
+	if len(lines) != 3 || lines[1] != "second" {
+		t.Fatalf("lines were %q", lines)
+	}
+	if len(lines[2]) != maxRingLineBytes {
+		t.Errorf("a long line was kept with %d bytes", len(lines[2]))
+	}
+}
+
+func TestStatusTailFromMemory(t *testing.T) {
+	app, cleanup := newTestApp(t, `i=0; while [ $i -lt 300 ]; do echo "error $i" >&2; i=$((i+1)); done; exit 1`)
+	defer cleanup()
+
+	app.LogTailStatuses = []string{FailedStatus}
+	app.LogTailLines = 3
+
+	record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, record)
+
+	if lines := record.LastLines(); len(lines) != ringLines || lines[ringLines-1] != "error 299" {
+		t.Fatalf("%d lines were kept in memory", len(lines))
+	}
+
+	// The tail comes from memory, so it's still there once the log is gone.
+	if err = os.Remove(record.StderrPath()); err != nil {
+		t.Fatal(err)
+	}
+
+	app.setLogTail(record)
+	if tail := strings.Join(record.StderrTail, ","); tail != "error 297,error 298,error 299" {
+		t.Errorf("stderr tail was %q", tail)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// logRotationLayout is the layout of the timestamps in the names of the logs
+// written with log-rotate-on-run.
+const logRotationLayout = "20060102-150405"
+
+// rotatedLogPattern returns a pattern matching the names of the rotated logs
+// with the prefix for the stream, either stdout or stderr. The timestamp comes
+// straight after the prefix, followed by the counter that tells apart the logs
+// of transfers that started in the same second.
+func rotatedLogPattern(prefix, stream string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `\.(\d{8}-\d{6})(?:\.(\d+))?(?:\.[0-9a-f-]{36})?\.` + stream + `\.log$`)
+}
+
+// rotatedLogs returns the paths of the rotated logs in dir with the prefix for
+// the stream, oldest first. Logs are ordered by their timestamps, and then by
+// their counters.
+func rotatedLogs(dir, prefix, stream string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list the logs in %s", dir)
+	}
+
+	type rotatedLog struct {
+		path    string
+		stamp   string
+		counter int
+	}
+
+	pattern := rotatedLogPattern(prefix, stream)
+	var logs []rotatedLog
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		match := pattern.FindStringSubmatch(info.Name())
+		if match == nil {
+			continue
+		}
+		counter, _ := strconv.Atoi(match[2])
+		logs = append(logs, rotatedLog{path.Join(dir, info.Name()), match[1], counter})
+	}
+
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].stamp != logs[j].stamp {
+			return logs[i].stamp < logs[j].stamp
+		}
+		if logs[i].counter != logs[j].counter {
+			return logs[i].counter < logs[j].counter
+		}
+		return logs[i].path < logs[j].path
+	})
+
+	paths := make([]string, len(logs))
+	for i, l := range logs {
+		paths[i] = l.path
+	}
+	return paths, nil
+}
+
+// rotationTime returns the time that names the record's rotated logs, which is
+// when it started running, or when it was requested if it hasn't started.
+func (r *TransferRecord) rotationTime() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.runStart.IsZero() {
+		return r.StartTime
+	}
+	return r.runStart
+}
+
+// unusedLogName returns the name of the logs in dir made of the name and the
+// suffix, adding a counter between them if there are already logs with that
+// name, so that a transfer doesn't truncate the logs of another transfer that
+// started in the same second. The logs mutex must be held by the caller.
+func unusedLogName(dir, name, suffix string) string {
+	candidate := name + suffix
+	for counter := 1; logNameExists(dir, candidate); counter++ {
+		candidate = fmt.Sprintf("%s.%d%s", name, counter, suffix)
+	}
+	return candidate
+}
+
+// logNameExists returns true if either of the logs with the name exists in dir.
+func logNameExists(dir, name string) bool {
+	for _, stream := range []string{"stdout", "stderr"} {
+		if _, err := os.Lstat(path.Join(dir, name+"."+stream+".log")); !os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// logsInUse returns the log paths of the transfers that haven't finished.
+func (a *App) logsInUse() map[string]bool {
+	inUse := make(map[string]bool)
+	for _, records := range []*HistoricalRecords{a.downloadRecords, a.uploadRecords} {
+		for _, r := range records.all() {
+			if isTerminalStatus(r.CurrentStatus()) {
+				continue
+			}
+			stdoutPath, stderrPath := r.LogPaths()
+			inUse[stdoutPath] = true
+			inUse[stderrPath] = true
+		}
+	}
+	return inUse
+}
+
+// forgetLogs stops the records of finished transfers from pointing to the logs
+// that have been removed.
+func (a *App) forgetLogs(removed map[string]bool) {
+	for _, records := range []*HistoricalRecords{a.downloadRecords, a.uploadRecords} {
+		for _, r := range records.all() {
+			stdoutPath, stderrPath := r.LogPaths()
+			if !removed[stdoutPath] && !removed[stderrPath] {
+				continue
+			}
+			if removed[stdoutPath] {
+				stdoutPath = ""
+			}
+			if removed[stderrPath] {
+				stderrPath = ""
+			}
+			r.SetLogPaths(stdoutPath, stderrPath)
+		}
+	}
+}
+
+// pruneRotatedLogs deletes the oldest rotated logs in dir with the prefix,
+// keeping the newest of each stream up to the retention count. The logs of
+// transfers that haven't finished are always kept. Nothing is deleted if the
+// retention count isn't positive. Failures are logged rather than returned,
+// since they shouldn't fail the transfer that's starting.
+func (a *App) pruneRotatedLogs(dir, prefix string) {
+	if a.LogRetentionCount <= 0 {
+		return
+	}
+
+	inUse := a.logsInUse()
+	removed := make(map[string]bool)
+	for _, stream := range []string{"stdout", "stderr"} {
+		paths, err := rotatedLogs(dir, prefix, stream)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+
+		for i := 0; i < len(paths)-a.LogRetentionCount; i++ {
+			if inUse[paths[i]] {
+				continue
+			}
+			if err := os.Remove(paths[i]); err != nil && !os.IsNotExist(err) {
+				log.Warn(errors.Wrapf(err, "failed to remove rotated log file %s", paths[i]))
+				continue
+			}
+			removed[paths[i]] = true
+		}
+	}
+
+	a.forgetLogs(removed)
+}
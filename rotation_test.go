@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logNames returns the names of the log files in dir, sorted.
+func logNames(t *testing.T, dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range matches {
+		names = append(names, filepath.Base(m))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLogRotateOnRun(t *testing.T) {
+	app, cleanup := newTestApp(t, "echo out; echo err >&2")
+	defer cleanup()
+
+	app.LogRotateOnRun = true
+
+	record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForStatus(t, record); status != CompletedStatus {
+		t.Fatalf("the download had status %s", status)
+	}
+
+	stamp := record.rotationTime().Format(logRotationLayout)
+	stdoutPath, stderrPath := record.LogPaths()
+	if stdoutPath != filepath.Join(app.LogDirectory, "downloads."+stamp+".stdout.log") {
+		t.Errorf("the stdout log was %s", stdoutPath)
+	}
+	if stderrPath != filepath.Join(app.LogDirectory, "downloads."+stamp+".stderr.log") {
+		t.Errorf("the stderr log was %s", stderrPath)
+	}
+	if contents, err := ioutil.ReadFile(stdoutPath); err != nil || string(contents) != "out\n" {
+		t.Errorf("the stdout log had %q: %v", contents, err)
+	}
+}
+
+func TestLogRetentionCount(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	app.LogRotateOnRun = true
+	app.LogRetentionCount = 2
+
+	// An unrelated log with the same prefix is never pruned.
+	unrelated := filepath.Join(app.LogDirectory, "downloads.stdout.log")
+	if err := ioutil.WriteFile(unrelated, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var records []*TransferRecord
+	for i := 0; i < 4; i++ {
+		r := NewDownloadRecord()
+		r.StartTime = start.Add(time.Duration(i) * time.Minute)
+		app.appendRecord(app.downloadRecords, r)
+
+		// The first transfer is still running, so its logs are kept.
+		if i > 0 {
+			r.SetStatus(CompletedStatus)
+		}
+
+		logs, err := app.openTransferLogsIn(app.LogDirectory, r, "downloads")
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs.Close()
+		records = append(records, r)
+	}
+
+	expected := []string{
+		"downloads.20200102-030405.stderr.log",
+		"downloads.20200102-030405.stdout.log",
+		"downloads.20200102-030605.stderr.log",
+		"downloads.20200102-030605.stdout.log",
+		"downloads.20200102-030705.stderr.log",
+		"downloads.20200102-030705.stdout.log",
+		"downloads.stdout.log",
+	}
+	names := logNames(t, app.LogDirectory)
+	if len(names) != len(expected) {
+		t.Fatalf("the logs were %v", names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("the logs were %v, not %v", names, expected)
+			break
+		}
+	}
+
+	if stdoutPath, stderrPath := records[1].LogPaths(); stdoutPath != "" || stderrPath != "" {
+		t.Errorf("the pruned record still has the logs %s and %s", stdoutPath, stderrPath)
+	}
+	if stdoutPath, _ := records[0].LogPaths(); stdoutPath == "" {
+		t.Error("the running record lost its logs")
+	}
+}
+
+func TestLogRotateOnRunSameSecond(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	app.LogRotateOnRun = true
+	app.LogRetentionCount = 2
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var records []*TransferRecord
+	for i := 0; i < 3; i++ {
+		r := NewDownloadRecord()
+		r.StartTime = start.Add(time.Duration(i) * time.Millisecond)
+		app.appendRecord(app.downloadRecords, r)
+
+		logs, err := app.openTransferLogsIn(app.LogDirectory, r, "downloads")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = logs.stdoutOutput.Write([]byte(r.UUID.String())); err != nil {
+			t.Fatal(err)
+		}
+		logs.Close()
+		r.SetStatus(CompletedStatus)
+		records = append(records, r)
+	}
+
+	// The oldest of the transfers that started in the same second is pruned,
+	// and the others keep their own logs.
+	expected := []string{
+		"downloads.20200102-030405.1.stderr.log",
+		"downloads.20200102-030405.1.stdout.log",
+		"downloads.20200102-030405.2.stderr.log",
+		"downloads.20200102-030405.2.stdout.log",
+	}
+	names := logNames(t, app.LogDirectory)
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Errorf("the logs were %v, not %v", names, expected)
+	}
+
+	for _, r := range records[1:] {
+		stdoutPath, _ := r.LogPaths()
+		if contents, err := ioutil.ReadFile(stdoutPath); err != nil || string(contents) != r.UUID.String() {
+			t.Errorf("the stdout log of %s had %q: %v", r.UUID, contents, err)
+		}
+	}
+}
+
+func TestLogRetentionCountWithoutRotation(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 0")
+	defer cleanup()
+
+	app.LogRetentionCount = 1
+
+	for i := 0; i < 2; i++ {
+		r := NewDownloadRecord()
+		r.StartTime = r.StartTime.Add(time.Duration(i) * time.Minute)
+		logs, err := app.openTransferLogsIn(app.LogDirectory, r, "downloads")
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs.Close()
+	}
+
+	names := logNames(t, app.LogDirectory)
+	if len(names) != 2 || names[0] != "downloads.stderr.log" || names[1] != "downloads.stdout.log" {
+		t.Errorf("the logs were %v", names)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// redacted replaces the values of configuration settings that may be secret.
+const redacted = "<redacted>"
+
+// RuntimeConfig is the configuration that the running service is using, after
+// the command line, the config file, and the defaults have been merged.
+type RuntimeConfig struct {
+	User                   string   `json:"user"`
+	InvocationID           string   `json:"invocation_id"`
+	LogDirectory           string   `json:"log_dir"`
+	LogFileMode            string   `json:"log_file_mode"`
+	LogFallback            bool     `json:"log_fallback"`
+	LogRotateOnRun         bool     `json:"log_rotate_on_run"`
+	LogRetentionCount      int      `json:"log_retention_count"`
+	CombinedLogs           bool     `json:"combined_logs"`
+	UploadDestination      string   `json:"upload_destination"`
+	DownloadDestination    string   `json:"download_destination"`
+	NamespaceByUser        bool     `json:"namespace_by_user"`
+	AllowedPathPrefixes    []string `json:"allowed_path_prefixes"`
+	ExcludesFile           string   `json:"excludes_file"`
+	PathListFile           string   `json:"path_list_file"`
+	IRODSConfig            string   `json:"irods_config"`
+	IRODSConfigDir         string   `json:"irods_config_dir"`
+	PorklockPath           string   `json:"porklock_path"`
+	TransferWrapper        string   `json:"transfer_wrapper"`
+	PorklockJar            string   `json:"porklock_jar"`
+	PorklockEnv            []string `json:"porklock_env"`
+	PorklockExtraArgCount  int      `json:"porklock_extra_args_count"`
+	FileMetadata           []string `json:"metadata"`
+	MetadataFile           string   `json:"metadata_file"`
+	MaxHistory             int      `json:"max_history"`
+	MaxConcurrentDownloads int      `json:"max_concurrent_downloads"`
+	MaxConcurrentUploads   int      `json:"max_concurrent_uploads"`
+	MaxTotalTransfers      int      `json:"max_total_transfers"`
+	MaxQueueLength         int      `json:"max_queue_length"`
+	ChunkSize              int      `json:"chunk_size"`
+	TransferTimeout        string   `json:"transfer_timeout"`
+	TransferRetries        int      `json:"transfer_retries"`
+	RetryDelay             string   `json:"retry_delay"`
+	RetryExitCodes         []int    `json:"retry_exit_codes"`
+	MaxTransferTimeout     string   `json:"max_transfer_timeout"`
+	UploadDebounce         string   `json:"upload_debounce"`
+	StatusCacheTTL         string   `json:"status_cache_ttl"`
+	StatusMaxAge           string   `json:"status_max_age"`
+	VerifyChecksums        bool     `json:"verify_checksums"`
+	ChecksumCommand        string   `json:"checksum_command"`
+	Resume                 bool     `json:"resume"`
+	RequireNonempty        bool     `json:"require_nonempty"`
+	UploadMove             bool     `json:"upload_move"`
+	ShutdownLogDestination string   `json:"shutdown_log_destination"`
+	ShutdownTimeout        string   `json:"shutdown_timeout"`
+	AuditLog               string   `json:"audit_log"`
+}
+
+// redactEnv returns the KEY=VALUE environment variables with their values
+// replaced, since they may hold credentials.
+func redactEnv(env []string) []string {
+	out := make([]string, len(env))
+	for i, kv := range env {
+		out[i] = strings.SplitN(kv, "=", 2)[0] + "=" + redacted
+	}
+	return out
+}
+
+// runtimeConfig returns the app's configuration with the sensitive values
+// redacted. Only the number of extra porklock arguments is given, since they
+// may include credentials that can't be told apart from the other arguments.
+func (a *App) runtimeConfig() *RuntimeConfig {
+	logFileMode := a.LogFileMode
+	if logFileMode == 0 {
+		logFileMode = defaultLogFileMode
+	}
+
+	return &RuntimeConfig{
+		User:                   a.User,
+		InvocationID:           a.InvocationID,
+		LogDirectory:           a.LogDirectory,
+		LogFileMode:            "0" + strconv.FormatUint(uint64(logFileMode), 8),
+		LogFallback:            a.LogFallback,
+		LogRotateOnRun:         a.LogRotateOnRun,
+		LogRetentionCount:      a.LogRetentionCount,
+		CombinedLogs:           a.CombinedLogs,
+		UploadDestination:      a.UploadDestination,
+		DownloadDestination:    a.DownloadDestination,
+		NamespaceByUser:        a.NamespaceByUser,
+		AllowedPathPrefixes:    a.AllowedPathPrefixes,
+		ExcludesFile:           a.ExcludesPath,
+		PathListFile:           a.InputPathList,
+		IRODSConfig:            a.ConfigPath,
+		IRODSConfigDir:         a.IRODSConfigDir,
+		PorklockPath:           a.PorklockPath,
+		TransferWrapper:        a.TransferWrapper,
+		PorklockJar:            a.PorklockJar,
+		PorklockEnv:            redactEnv(a.PorklockEnv),
+		PorklockExtraArgCount:  len(a.PorklockExtraArgs),
+		FileMetadata:           a.FileMetadata,
+		MetadataFile:           a.MetadataFile,
+		MaxHistory:             a.downloadRecords.maxRecords,
+		MaxConcurrentDownloads: a.queue(DownloadKind).maxWorkers,
+		MaxConcurrentUploads:   a.queue(UploadKind).maxWorkers,
+		MaxTotalTransfers:      a.MaxTotalTransfers,
+		MaxQueueLength:         a.MaxQueueLength,
+		ChunkSize:              a.ChunkSize,
+		TransferTimeout:        a.TransferTimeout.String(),
+		TransferRetries:        a.TransferRetries,
+		RetryDelay:             a.RetryDelay.String(),
+		RetryExitCodes:         a.RetryExitCodes,
+		MaxTransferTimeout:     a.MaxTransferTimeout.String(),
+		UploadDebounce:         a.UploadDebounce.String(),
+		StatusCacheTTL:         a.StatusCacheTTL.String(),
+		StatusMaxAge:           a.StatusMaxAge.String(),
+		VerifyChecksums:        a.VerifyChecksums,
+		ChecksumCommand:        a.ChecksumCommand,
+		Resume:                 a.Resume,
+		RequireNonempty:        a.RequireNonempty,
+		UploadMove:             a.UploadMove,
+		ShutdownLogDestination: a.ShutdownLogDestination,
+		ShutdownTimeout:        a.ShutdownTimeout.String(),
+		AuditLog:               a.AuditLog,
+	}
+}
+
+// GetConfig returns the configuration that the service is running with. The
+// values of the porklock environment variables and the extra porklock
+// arguments are left out.
+func (a *App) GetConfig(writer http.ResponseWriter, req *http.Request) {
+	render(writer, req, http.StatusOK, a.runtimeConfig())
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetConfig(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	app.PorklockEnv = []string{"JAVA_OPTS=-Xmx512m", "IRODS_PASSWORD=secret"}
+	app.PorklockExtraArgs = []string{"--token", "hunter2"}
+	app.TransferTimeout = 2 * time.Hour
+	app.LogFileMode = 0600
+	app.MaxConcurrentDownloads = 3
+
+	rec := httptest.NewRecorder()
+	app.GetConfig(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("config returned %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("config included a porklock environment value: %s", rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "hunter2") {
+		t.Errorf("config included an extra porklock argument: %s", rec.Body.String())
+	}
+
+	config := &RuntimeConfig{}
+	if err := json.Unmarshal(rec.Body.Bytes(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := app.runtimeConfig()
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("config was %+v, not %+v", config, expected)
+	}
+
+	for name, values := range map[string][2]string{
+		"user":               {config.User, app.User},
+		"upload destination": {config.UploadDestination, app.UploadDestination},
+		"porklock path":      {config.PorklockPath, app.PorklockPath},
+		"irods config":       {config.IRODSConfig, app.ConfigPath},
+		"transfer timeout":   {config.TransferTimeout, "2h0m0s"},
+		"log file mode":      {config.LogFileMode, "0600"},
+	} {
+		if values[0] != values[1] {
+			t.Errorf("%s was %q, not %q", name, values[0], values[1])
+		}
+	}
+
+	if config.MaxConcurrentDownloads != 3 {
+		t.Errorf("max concurrent downloads was %d", config.MaxConcurrentDownloads)
+	}
+	if config.PorklockExtraArgCount != 2 {
+		t.Errorf("porklock extra arg count was %d", config.PorklockExtraArgCount)
+	}
+	if env := []string{"JAVA_OPTS=<redacted>", "IRODS_PASSWORD=<redacted>"}; !reflect.DeepEqual(config.PorklockEnv, env) {
+		t.Errorf("porklock env was %v, not %v", config.PorklockEnv, env)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// selfTestTimeout is how long porklock may take to print its help during the
+// self-test. Starting the JVM can be slow, but it shouldn't take this long.
+const selfTestTimeout = time.Minute
+
+// selfTest runs porklock's help command with the configured porklock binary,
+// jar, wrapper, and environment to check that the jar can actually be run,
+// which finding the binary doesn't show. The output is logged. It returns an
+// error including the output if porklock fails or doesn't finish within the
+// self-test timeout.
+func (a *App) selfTest(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	parts := a.wrapCommand(a.porklockCommand("help"))
+	command := strings.Join(parts, " ")
+	output, err := a.newCommand(ctx, parts).CombinedOutput()
+	trimmed := strings.TrimSpace(string(output))
+	if err != nil {
+		if trimmed == "" {
+			return errors.Wrapf(err, "the porklock self-test %q failed without any output", command)
+		}
+		return errors.Wrapf(err, "the porklock self-test %q failed with output %q", command, trimmed)
+	}
+
+	log.Infof("porklock self-test %q succeeded with output %q", command, trimmed)
+	return nil
+}
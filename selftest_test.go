@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	app, cleanup := newTestApp(t, `echo "porklock $*"`)
+	defer cleanup()
+
+	if err := app.selfTest(context.Background()); err != nil {
+		t.Errorf("the self-test failed with a working porklock: %s", err)
+	}
+}
+
+func TestSelfTestFailure(t *testing.T) {
+	app, cleanup := newTestApp(t, "echo 'Error: Unable to access jarfile' >&2\nexit 1")
+	defer cleanup()
+
+	err := app.selfTest(context.Background())
+	if err == nil {
+		t.Fatal("the self-test passed with a broken porklock")
+	}
+	if !strings.Contains(err.Error(), "Unable to access jarfile") {
+		t.Errorf("the error didn't include porklock's output: %s", err)
+	}
+	if !strings.Contains(err.Error(), "fake-porklock.jar help") {
+		t.Errorf("the error didn't include the command: %s", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Transferrer runs a porklock command described by its command parts, killing
+// it if the context is done first.
+type Transferrer interface {
+	Transfer(ctx context.Context, parts []string) error
+}
+
+// commandTransferrer is a Transferrer that runs porklock as a child process with
+// its output going to the service's own stdout and stderr.
+type commandTransferrer struct {
+	app *App
+}
+
+// Transfer runs the command and waits for it to complete.
+func (c *commandTransferrer) Transfer(ctx context.Context, parts []string) error {
+	cmd, err := c.app.newPorklockCommand(ctx, parts)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// finalLogsCommand returns the command that uploads the log directory to the
+// shutdown log destination. It's built like the command for uploads, without
+// any excludes.
+func (a *App) finalLogsCommand() []string {
+	return a.putCommand(a.LogDirectory, a.ShutdownLogDestination, "", a.ConfigPath, "")
+}
+
+// uploadFinalLogs uploads the contents of the log directory to the shutdown log
+// destination, giving up when the context is done. It does nothing if no
+// shutdown log destination is configured.
+func (a *App) uploadFinalLogs(ctx context.Context) error {
+	if a.ShutdownLogDestination == "" {
+		return nil
+	}
+
+	log.Warnf("uploading logs in %s to %s", a.LogDirectory, a.ShutdownLogDestination)
+
+	if err := a.transferrer.Transfer(ctx, a.finalLogsCommand()); err != nil {
+		return errors.Wrap(err, "error uploading the final logs")
+	}
+	return nil
+}
+
+// waitForTransfers blocks until the running transfers complete or the context
+// is done, whichever happens first.
+func (a *App) waitForTransfers(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			a.queue(DownloadKind).Wait()
+			wg.Done()
+		}()
+		go func() {
+			a.queue(UploadKind).Wait()
+			wg.Done()
+		}()
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown stops the server from accepting new requests, waits for running
+// transfers to finish, and then uploads the final logs if configured to. The
+// transfers are cancelled if they don't finish before the context is done. The
+// final upload runs either way, with a timeout of its own so that it still gets
+// to run when the transfers have used up the context, and is killed if it
+// doesn't finish within the shutdown timeout.
+func (a *App) shutdown(ctx context.Context, server *http.Server) error {
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error(errors.Wrap(err, "error shutting down the web server"))
+	}
+
+	if err := a.waitForTransfers(ctx); err != nil {
+		log.Error(errors.Wrap(err, "gave up waiting for transfers to finish, cancelling them"))
+		a.cancelTransfers()
+	}
+
+	uploadCtx, cancel := a.finalLogsContext()
+	defer cancel()
+
+	return a.uploadFinalLogs(uploadCtx)
+}
+
+// finalLogsContext returns the context for the final log upload, which is done
+// once the shutdown timeout has passed. It's never done if there's no timeout.
+func (a *App) finalLogsContext() (context.Context, context.CancelFunc) {
+	if a.ShutdownTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), a.ShutdownTimeout)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeTransferrer struct {
+	calls   [][]string
+	ctxErrs []error
+}
+
+func (f *fakeTransferrer) Transfer(ctx context.Context, parts []string) error {
+	f.calls = append(f.calls, parts)
+	f.ctxErrs = append(f.ctxErrs, ctx.Err())
+	return nil
+}
+
+func TestShutdownUploadsFinalLogs(t *testing.T) {
+	transferrer := &fakeTransferrer{}
+	app := &App{
+		User:                   "test-user",
+		LogDirectory:           "/input-files",
+		ShutdownLogDestination: "/iplant/home/test-user/logs",
+		ConfigPath:             "/etc/porklock/irods-config.properties",
+		PorklockExtraArgs:      []string{"--skip-parent-meta"},
+		TransferWrapper:        "nice",
+		transferrer:            transferrer,
+	}
+
+	if err := app.shutdown(context.Background(), &http.Server{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(transferrer.calls) != 1 {
+		t.Fatalf("final upload was invoked %d times, not 1", len(transferrer.calls))
+	}
+
+	cmd := strings.Join(transferrer.calls[0], " ")
+	if transferrer.calls[0][0] != "nice" {
+		t.Errorf("final upload command %q isn't run by the transfer wrapper", cmd)
+	}
+	for _, expected := range []string{" put ", "--source /input-files", "--destination /iplant/home/test-user/logs", "--skip-parent-meta"} {
+		if !strings.Contains(cmd, expected) {
+			t.Errorf("final upload command %q does not contain %q", cmd, expected)
+		}
+	}
+}
+
+func TestShutdownWithoutLogDestination(t *testing.T) {
+	transferrer := &fakeTransferrer{}
+	app := &App{transferrer: transferrer}
+
+	if err := app.shutdown(context.Background(), &http.Server{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(transferrer.calls) != 0 {
+		t.Errorf("final upload was invoked %d times, not 0", len(transferrer.calls))
+	}
+}
+
+func TestFinalLogsUploadTimeout(t *testing.T) {
+	app, cleanup := newTestApp(t, "exec sleep 10")
+	defer cleanup()
+
+	app.ShutdownLogDestination = "/iplant/home/test-user/logs"
+	app.ShutdownTimeout = 100 * time.Millisecond
+	app.transferrer = &commandTransferrer{app: app}
+
+	start := time.Now()
+	if err := app.shutdown(context.Background(), &http.Server{}); err == nil {
+		t.Error("the final upload didn't fail when the shutdown timed out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the final upload took %s to give up", elapsed)
+	}
+}
+
+func TestFinalLogsUploadAfterTransfersTimeOut(t *testing.T) {
+	app, cleanup := newTestApp(t, "exec sleep 10")
+	defer cleanup()
+
+	transferrer := &fakeTransferrer{}
+	app.ShutdownLogDestination = "/iplant/home/test-user/logs"
+	app.ShutdownTimeout = time.Minute
+	app.transferrer = transferrer
+
+	if _, err := app.DownloadFiles(context.Background(), &TransferRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForRunning(t, app.downloadRecords, DownloadingStatus, 1)
+
+	// The download outlasts the shutdown context, which is done by the time
+	// the final upload starts.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := app.shutdown(ctx, &http.Server{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(transferrer.calls) != 1 {
+		t.Fatalf("final upload was invoked %d times, not 1", len(transferrer.calls))
+	}
+	if err := transferrer.ctxErrs[0]; err != nil {
+		t.Errorf("the final upload started with a finished context: %s", err)
+	}
+}
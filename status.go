@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statusGeneration is incremented whenever a record is added, removed, or changes
+// status, which lets cached status summaries detect that they're out of date.
+var statusGeneration uint64
+
+func bumpStatusGeneration() {
+	atomic.AddUint64(&statusGeneration, 1)
+}
+
+func currentStatusGeneration() uint64 {
+	return atomic.LoadUint64(&statusGeneration)
+}
+
+// StatusSummary contains the number of records in each status for each kind of
+// transfer.
+type StatusSummary struct {
+	Downloads map[string]int `json:"downloads"`
+	Uploads   map[string]int `json:"uploads"`
+}
+
+// statusSummaryCache holds a StatusSummary for a short time so that frequent
+// requests don't scan the records every time. The cached summary is discarded
+// when it's older than the TTL or when any record changes status.
+type statusSummaryCache struct {
+	summary      []byte
+	computedAt   time.Time
+	generation   uint64
+	computations int
+	mutex        sync.Mutex
+}
+
+// get returns the cached summary, calling compute to refresh it if it's stale.
+// Concurrent callers wait for a single refresh rather than each computing the
+// summary themselves.
+func (c *statusSummaryCache) get(ttl time.Duration, compute func() ([]byte, error)) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	generation := currentStatusGeneration()
+	if c.summary != nil && c.generation == generation && time.Since(c.computedAt) < ttl {
+		return c.summary, nil
+	}
+
+	summary, err := compute()
+	if err != nil {
+		return nil, err
+	}
+
+	c.summary = summary
+	c.computedAt = time.Now()
+	c.generation = generation
+	c.computations++
+
+	return summary, nil
+}
+
+// CountByStatus returns the number of records in each status.
+func (h *HistoricalRecords) CountByStatus() map[string]int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	counts := make(map[string]int)
+	for _, tr := range h.records {
+		counts[tr.CurrentStatus()]++
+	}
+	return counts
+}
+
+// GetStatusSummary returns the number of downloads and uploads in each status.
+func (a *App) GetStatusSummary(writer http.ResponseWriter, request *http.Request) {
+	summary, err := a.statusCache.get(a.StatusCacheTTL, func() ([]byte, error) {
+		return json.Marshal(&StatusSummary{
+			Downloads: a.downloadRecords.CountByStatus(),
+			Uploads:   a.uploadRecords.CountByStatus(),
+		})
+	})
+	if err != nil {
+		log.Error(err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(summary)
+}
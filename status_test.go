@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getSummary(t *testing.T, app *App) *StatusSummary {
+	rec := httptest.NewRecorder()
+	app.GetStatusSummary(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status was %d, not %d", rec.Code, http.StatusOK)
+	}
+
+	summary := &StatusSummary{}
+	if err := json.Unmarshal(rec.Body.Bytes(), summary); err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+func TestStatusSummaryCache(t *testing.T) {
+	app := &App{
+		StatusCacheTTL:  time.Minute,
+		downloadRecords: &HistoricalRecords{},
+		uploadRecords:   &HistoricalRecords{},
+	}
+
+	record := NewDownloadRecord()
+	app.downloadRecords.Append(record)
+
+	summary := getSummary(t, app)
+	if summary.Downloads[RequestedStatus] != 1 {
+		t.Errorf("requested downloads was %d, not 1", summary.Downloads[RequestedStatus])
+	}
+
+	getSummary(t, app)
+	getSummary(t, app)
+
+	if app.statusCache.computations != 1 {
+		t.Errorf("summary was computed %d times, not 1", app.statusCache.computations)
+	}
+
+	record.SetStatus(CompletedStatus)
+
+	summary = getSummary(t, app)
+	if summary.Downloads[CompletedStatus] != 1 || summary.Downloads[RequestedStatus] != 0 {
+		t.Errorf("summary was not refreshed after a status change: %v", summary.Downloads)
+	}
+
+	if app.statusCache.computations != 2 {
+		t.Errorf("summary was computed %d times, not 2", app.statusCache.computations)
+	}
+
+	app.downloadRecords.Remove(record.UUID.String())
+
+	summary = getSummary(t, app)
+	if summary.Downloads[CompletedStatus] != 0 {
+		t.Errorf("summary was not refreshed after a record was removed: %v", summary.Downloads)
+	}
+}
+
+func TestStatusSummaryCacheExpires(t *testing.T) {
+	app := &App{
+		StatusCacheTTL:  time.Millisecond,
+		downloadRecords: &HistoricalRecords{},
+		uploadRecords:   &HistoricalRecords{},
+	}
+
+	getSummary(t, app)
+	time.Sleep(5 * time.Millisecond)
+	getSummary(t, app)
+
+	if app.statusCache.computations != 2 {
+		t.Errorf("summary was computed %d times, not 2", app.statusCache.computations)
+	}
+}
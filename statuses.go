@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// decodeUUIDs parses the JSON array of record UUIDs in the body of the request.
+func decodeUUIDs(req *http.Request) ([]string, error) {
+	var ids []string
+	if req.Body == nil {
+		return nil, errors.New("the request body must be a JSON array of UUIDs")
+	}
+
+	if err := decodeJSON(req.Body, &ids); err != nil {
+		return nil, errors.Wrap(err, "invalid request body")
+	}
+	return ids, nil
+}
+
+// findAnyRecord returns the download or upload record with the UUID, or nil if
+// there isn't one.
+func (a *App) findAnyRecord(id string) *TransferRecord {
+	if r := a.downloadRecords.FindRecord(id); r != nil {
+		return r
+	}
+	return a.uploadRecords.FindRecord(id)
+}
+
+// GetTransferStatuses looks up each of the UUIDs in the JSON array in the
+// request body among both the downloads and the uploads, and responds with an
+// object mapping the UUIDs to their records. UUIDs without a record map to null.
+func (a *App) GetTransferStatuses(writer http.ResponseWriter, request *http.Request) {
+	ids, err := decodeUUIDs(request)
+	if err != nil {
+		writeJSONError(writer, decodeErrorStatus(err), err)
+		return
+	}
+
+	statuses := make(map[string]*TransferRecord, len(ids))
+	for _, id := range ids {
+		r := a.findAnyRecord(id)
+		if r != nil {
+			a.setLogTail(r)
+		}
+		statuses[id] = r
+	}
+
+	render(writer, request, http.StatusOK, statuses)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTransferStatuses(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	download := NewDownloadRecord()
+	download.SetStatus(CompletedStatus)
+	app.downloadRecords.Append(download)
+
+	upload := NewUploadRecord()
+	upload.SetStatus(FailedStatus)
+	app.uploadRecords.Append(upload)
+
+	unknown := uuid.New().String()
+
+	rec, body := postTransfer(app.GetTransferStatuses, "/transfers/status", nil,
+		fmt.Sprintf(`[%q, %q, %q]`, download.UUID, unknown, upload.UUID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("statuses returned %d", rec.Code)
+	}
+	if len(body) != 3 {
+		t.Errorf("response had %d entries, not 3: %v", len(body), body)
+	}
+
+	for _, r := range []*TransferRecord{download, upload} {
+		status, ok := body[r.UUID.String()].(map[string]interface{})
+		if !ok {
+			t.Errorf("no record for the %s: %v", r.Kind, body)
+			continue
+		}
+		if status["kind"] != r.Kind || status["status"] != r.Status {
+			t.Errorf("unexpected record for the %s: %v", r.Kind, status)
+		}
+	}
+
+	if value, ok := body[unknown]; !ok || value != nil {
+		t.Errorf("unknown UUID mapped to %v", value)
+	}
+}
+
+func TestTransferStatusesInvalidBody(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	for _, body := range []string{"", `{"uuid": "x"}`, `[1, 2]`} {
+		if rec, _ := postTransfer(app.GetTransferStatuses, "/transfers/status", nil, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("body %q returned %d", body, rec.Code)
+		}
+	}
+}
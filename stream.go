@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// streamPollInterval is how often a log stream checks for new lines in the log
+// file and for a change in the transfer's status.
+var streamPollInterval = 500 * time.Millisecond
+
+// writeEvent writes a single Server-Sent Event to the writer.
+func writeEvent(writer io.Writer, event, data string) error {
+	_, err := fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// streamRecordLog sends each line appended to the stderr log of the transfer
+// as a "log" event until the transfer reaches a terminal status, at which point
+// a final "status" event is sent and the stream is closed.
+func (a *App) streamRecordLog(writer http.ResponseWriter, request *http.Request, records *HistoricalRecords) {
+	id := mux.Vars(request)["id"]
+
+	foundRecord := records.FindRecord(id)
+	if foundRecord == nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var (
+		logFile *os.File
+		reader  *bufio.Reader
+		partial string
+	)
+
+	defer func() {
+		if logFile != nil {
+			logFile.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// The log file is opened lazily since the transfer may not have created it yet.
+		if logFile == nil {
+			if logPath := foundRecord.StderrPath(); logPath != "" {
+				if f, err := os.Open(logPath); err == nil {
+					logFile = f
+					reader = bufio.NewReader(f)
+				}
+			}
+		}
+
+		// Read the status before draining the log so that lines written before the
+		// transfer finished are always sent ahead of the final event.
+		status := foundRecord.CurrentStatus()
+
+		if reader != nil {
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					partial += line
+					break
+				}
+
+				if err = writeEvent(writer, "log", strings.TrimRight(partial+line, "\r\n")); err != nil {
+					return
+				}
+				partial = ""
+			}
+		}
+
+		if isTerminalStatus(status) {
+			if partial != "" {
+				writeEvent(writer, "log", strings.TrimRight(partial, "\r\n"))
+			}
+			writeEvent(writer, "status", status)
+			flusher.Flush()
+			return
+		}
+
+		flusher.Flush()
+
+		select {
+		case <-request.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// StreamDownloadLog streams the stderr log of a download as Server-Sent Events.
+func (a *App) StreamDownloadLog(writer http.ResponseWriter, request *http.Request) {
+	a.streamRecordLog(writer, request, a.downloadRecords)
+}
+
+// StreamUploadLog streams the stderr log of an upload as Server-Sent Events.
+func (a *App) StreamUploadLog(writer http.ResponseWriter, request *http.Request) {
+	a.streamRecordLog(writer, request, a.uploadRecords)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// readEvent reads a single Server-Sent Event from the reader, returning its
+// event name and data.
+func readEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error reading event: %s", err)
+		}
+		line = strings.TrimRight(line, "\n")
+
+		switch {
+		case line == "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestStreamDownloadLog(t *testing.T) {
+	origInterval := streamPollInterval
+	streamPollInterval = 10 * time.Millisecond
+	defer func() { streamPollInterval = origInterval }()
+
+	dir, err := ioutil.TempDir("", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stderrPath := filepath.Join(dir, "downloads.stderr.log")
+	logFile, err := os.Create(stderrPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	app := &App{downloadRecords: &HistoricalRecords{}}
+	record := NewDownloadRecord()
+	record.SetStatus(DownloadingStatus)
+	record.SetLogPaths(filepath.Join(dir, "downloads.stdout.log"), stderrPath)
+	app.downloadRecords.Append(record)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/download/{id}/stream", app.StreamDownloadLog)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	if _, err = logFile.WriteString("first line\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(server.URL + "/download/" + record.UUID.String() + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type was %q", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+
+	if event, data := readEvent(t, reader); event != "log" || data != "first line" {
+		t.Errorf("first event was %q %q", event, data)
+	}
+
+	if _, err = logFile.WriteString("second line\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if event, data := readEvent(t, reader); event != "log" || data != "second line" {
+		t.Errorf("second event was %q %q", event, data)
+	}
+
+	record.SetStatus(CompletedStatus)
+
+	if event, data := readEvent(t, reader); event != "status" || data != CompletedStatus {
+		t.Errorf("final event was %q %q", event, data)
+	}
+}
+
+func TestStreamDownloadLogNotFound(t *testing.T) {
+	app := &App{downloadRecords: &HistoricalRecords{}}
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/download/nope/stream", nil), map[string]string{"id": "nope"})
+	rec := httptest.NewRecorder()
+	app.StreamDownloadLog(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status was %d, not %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/cyverse-de/vice-file-transfers"
+
+// setupTracing configures the global tracer provider to export spans to the
+// OTLP/HTTP endpoint, e.g. http://otel-collector:4318. When the endpoint is
+// empty the global no-op tracer provider is left in place. Incoming trace
+// context is propagated using the W3C trace context and baggage headers either
+// way. The returned function flushes and stops the exporter.
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create the OTLP exporter for %s", endpoint)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName("vice-file-transfers"),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// tracer returns the tracer used for transfer spans, which comes from the
+// global tracer provider unless the App was given its own.
+func (a *App) tracer() trace.Tracer {
+	if a.tracerProvider != nil {
+		return a.tracerProvider.Tracer(tracerName)
+	}
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// startTransferSpan starts a span covering the porklock execution for the
+// record, as a child of any span in the context.
+func (a *App) startTransferSpan(ctx context.Context, r *TransferRecord) (context.Context, trace.Span) {
+	return a.tracer().Start(ctx, r.Kind, trace.WithAttributes(
+		attribute.String("transfer.kind", r.Kind),
+		attribute.String("transfer.uuid", r.UUID.String()),
+		attribute.String("transfer.user", a.User),
+	))
+}
+
+// endTransferSpan records the outcome of the transfer on the span and ends it.
+// The exit status is only recorded for transfers where porklock ran.
+func endTransferSpan(span trace.Span, r *TransferRecord, err error) {
+	span.SetAttributes(attribute.String("transfer.status", r.CurrentStatus()))
+
+	if err == nil {
+		span.SetAttributes(attribute.Int("transfer.exit_status", 0))
+	} else if exitErr, ok := errors.Cause(err).(*exec.ExitError); ok {
+		span.SetAttributes(attribute.Int("transfer.exit_status", exitErr.ExitCode()))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
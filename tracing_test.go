@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTransferSpans(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	recorder := tracetest.NewSpanRecorder()
+	app.tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	download, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, download)
+
+	upload, err := app.UploadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, upload)
+
+	// The spans end before the records' completion times are set, so they've
+	// all been recorded once the transfers are done.
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans were recorded, not 2", len(spans))
+	}
+
+	for i, kind := range []string{DownloadKind, UploadKind} {
+		attrs := spanAttributes(spans[i])
+
+		if attrs["transfer.kind"].AsString() != kind {
+			t.Errorf("span %d had kind %q, not %q", i, attrs["transfer.kind"].AsString(), kind)
+		}
+		if attrs["transfer.user"].AsString() != "test-user" {
+			t.Errorf("span %d had user %q", i, attrs["transfer.user"].AsString())
+		}
+		if attrs["transfer.exit_status"].AsInt64() != 0 {
+			t.Errorf("span %d had exit status %d", i, attrs["transfer.exit_status"].AsInt64())
+		}
+		if attrs["transfer.status"].AsString() != CompletedStatus {
+			t.Errorf("span %d had status %q", i, attrs["transfer.status"].AsString())
+		}
+	}
+}
+
+func TestFailedTransferSpan(t *testing.T) {
+	app, cleanup := newTestApp(t, "exit 3")
+	defer cleanup()
+
+	recorder := tracetest.NewSpanRecorder()
+	app.tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	record, err := app.DownloadFiles(context.Background(), &TransferRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, record)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans were recorded, not 1", len(spans))
+	}
+
+	if status := spanAttributes(spans[0])["transfer.exit_status"].AsInt64(); status != 3 {
+		t.Errorf("span had exit status %d, not 3", status)
+	}
+
+	if spans[0].Status().Code != codes.Error {
+		t.Errorf("span had status %v", spans[0].Status())
+	}
+}
+
+func TestSetupTracingWithoutEndpoint(t *testing.T) {
+	shutdown, err := setupTracing(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// webSocketWriteTimeout is how long a write to a WebSocket client may take
+// before the client is considered gone.
+const webSocketWriteTimeout = 10 * time.Second
+
+// Subscribe returns a channel that receives a value whenever the record
+// changes, such as its status or progress, and a function that must be called
+// to stop the notifications. Changes that happen before the last one was
+// received are coalesced, so subscribers should read the record's current
+// state rather than count notifications.
+func (r *TransferRecord) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	r.mutex.Lock()
+	if r.subscribers == nil {
+		r.subscribers = make(map[chan struct{}]struct{})
+	}
+	r.subscribers[ch] = struct{}{}
+	r.mutex.Unlock()
+
+	return ch, func() {
+		r.mutex.Lock()
+		delete(r.subscribers, ch)
+		r.mutex.Unlock()
+	}
+}
+
+// notifySubscribers tells each of the record's subscribers that it has changed,
+// without waiting for any of them. The mutex must be held by the caller.
+func (r *TransferRecord) notifySubscribers() {
+	for ch := range r.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// checkWebSocketOrigin allows WebSocket connections from pages served by the
+// service itself and from the configured CORS origin. Requests without an
+// Origin header, which don't come from browsers, are always allowed.
+func (a *App) checkWebSocketOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" || a.CORSOrigin == "*" || origin == a.CORSOrigin {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// watchRecord upgrades the request to a WebSocket and sends the record whose
+// UUID is in the request's path as JSON, and again each time it changes. The
+// connection is closed once the transfer has finished and the final record has
+// been sent, or when the client goes away.
+func (a *App) watchRecord(writer http.ResponseWriter, request *http.Request, records *HistoricalRecords) {
+	foundRecord := records.FindRecord(mux.Vars(request)["id"])
+	if foundRecord == nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: a.checkWebSocketOrigin}
+	conn, err := upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		// The upgrader has already responded to the client.
+		log.Warnf("failed to upgrade the connection for %s %s: %s", foundRecord.Kind, foundRecord.UUID, err)
+		return
+	}
+	defer conn.Close()
+
+	updates, unsubscribe := foundRecord.Subscribe()
+	defer unsubscribe()
+
+	// Clients aren't expected to send anything, but reading is how a closed
+	// connection is noticed.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		// Whether the transfer has finished is checked before the record is
+		// sent, so that the final message includes the completion time.
+		finished := false
+		select {
+		case <-foundRecord.Done():
+			finished = true
+		default:
+		}
+
+		a.setLogTail(foundRecord)
+		conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+		if err = conn.WriteJSON(foundRecord); err != nil {
+			return
+		}
+
+		if finished {
+			conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, foundRecord.CurrentStatus()),
+				time.Now().Add(webSocketWriteTimeout),
+			)
+			return
+		}
+
+		select {
+		case <-updates:
+		case <-foundRecord.Done():
+		case <-gone:
+			return
+		}
+	}
+}
+
+// WatchDownload sends the status of a download over a WebSocket as it changes.
+func (a *App) WatchDownload(writer http.ResponseWriter, request *http.Request) {
+	a.watchRecord(writer, request, a.downloadRecords)
+}
+
+// WatchUpload sends the status of an upload over a WebSocket as it changes.
+func (a *App) WatchUpload(writer http.ResponseWriter, request *http.Request) {
+	a.watchRecord(writer, request, a.uploadRecords)
+}
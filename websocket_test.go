@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialRecord opens a WebSocket to the path on the server, accepting gzip like a
+// browser does to check that the upgrade isn't disturbed by compression.
+func dialRecord(server *httptest.Server, path string) (*websocket.Conn, *http.Response, error) {
+	header := http.Header{"Accept-Encoding": {"gzip, deflate"}}
+	return websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, header)
+}
+
+// readStatus reads the next record sent over the connection and returns its
+// status.
+func readStatus(t *testing.T, conn *websocket.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	body := map[string]interface{}{}
+	if err := conn.ReadJSON(&body); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := body["status"].(string)
+	return status
+}
+
+func TestWatchRecord(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	server := httptest.NewServer(app.newRouter())
+	defer server.Close()
+
+	r := NewDownloadRecord()
+	app.downloadRecords.Append(r)
+
+	conn, _, err := dialRecord(server, "/download/"+r.UUID.String()+"/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if status := readStatus(t, conn); status != RequestedStatus {
+		t.Errorf("first message had status %s", status)
+	}
+
+	r.SetStatus(DownloadingStatus)
+	if status := readStatus(t, conn); status != DownloadingStatus {
+		t.Errorf("message after the status change had status %s", status)
+	}
+
+	r.SetStatus(CompletedStatus)
+	r.SetCompletionTime()
+
+	// The completed status may be sent on its own before the completion
+	// time is, but the last message must have both.
+	var body map[string]interface{}
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var next map[string]interface{}
+		if err = conn.ReadJSON(&next); err != nil {
+			break
+		}
+		body = next
+	}
+
+	if body["status"] != CompletedStatus || body["completion_time"] == nil {
+		t.Errorf("last message was %v", body)
+	}
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("connection wasn't closed normally: %v", err)
+	}
+}
+
+func TestWatchRecordProgress(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	server := httptest.NewServer(app.newRouter())
+	defer server.Close()
+
+	r := NewDownloadRecord()
+	app.downloadRecords.Append(r)
+	r.SetRunning(DownloadingStatus)
+
+	conn, _, err := dialRecord(server, "/download/"+r.UUID.String()+"/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	readStatus(t, conn)
+
+	r.SetProgress(1, 4)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	body := map[string]interface{}{}
+	if err = conn.ReadJSON(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["status"] != DownloadingStatus || body["percent_complete"] != 25.0 {
+		t.Errorf("message after the progress changed was %v", body)
+	}
+}
+
+func TestWatchRecordDisconnect(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	server := httptest.NewServer(app.newRouter())
+	defer server.Close()
+
+	r := NewUploadRecord()
+	app.uploadRecords.Append(r)
+
+	conn, _, err := dialRecord(server, "/upload/"+r.UUID.String()+"/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readStatus(t, conn)
+	conn.Close()
+
+	// The handler unsubscribes once it notices the client has gone.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mutex.Lock()
+		n := len(r.subscribers)
+		r.mutex.Unlock()
+
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the handler didn't unsubscribe after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchUnknownRecord(t *testing.T) {
+	app, cleanup := newTestApp(t, "true")
+	defer cleanup()
+
+	server := httptest.NewServer(app.newRouter())
+	defer server.Close()
+
+	_, resp, err := dialRecord(server, "/download/unknown/ws")
+	if err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("dialing an unknown record returned %v %v", resp, err)
+	}
+}